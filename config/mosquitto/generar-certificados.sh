@@ -0,0 +1,60 @@
+#!/bin/bash
+
+# generar-certificados.sh - CA, certificados y claves de desarrollo para
+# config/mosquitto/seguro.conf. No usar en producción.
+# Uso:
+#   config/mosquitto/generar-certificados.sh [oficina...]   (por defecto A B C)
+#
+# La clave de los usuarios se toma de MQTT_CLAVE (por defecto "desarrollo").
+
+set -e
+
+DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+CERTS="$DIR/certs"
+CLAVE="${MQTT_CLAVE:-desarrollo}"
+DIAS=825
+
+OFICINAS=("$@")
+if [ ${#OFICINAS[@]} -eq 0 ]; then
+    OFICINAS=(A B C)
+fi
+USUARIOS=(subscriptor-edge publicador-sensores "${OFICINAS[@]}")
+
+mkdir -p "$CERTS"
+cd "$CERTS"
+
+if [ ! -f ca.crt ]; then
+    echo "🔐 Creando la CA de desarrollo"
+    openssl req -x509 -newkey rsa:2048 -nodes -days "$DIAS" \
+        -keyout ca.key -out ca.crt -subj "/CN=Monitoreo Consumo CA de desarrollo"
+fi
+
+# firmar <nombre> <cn> [extensiones]
+firmar() {
+    openssl req -newkey rsa:2048 -nodes -keyout "$1.key" -out "$1.csr" -subj "/CN=$2"
+    openssl x509 -req -in "$1.csr" -CA ca.crt -CAkey ca.key -CAcreateserial \
+        -days "$DIAS" -out "$1.crt" ${3:+-extfile <(printf "%s" "$3")}
+    rm -f "$1.csr"
+}
+
+echo "🔐 Certificado del broker (localhost)"
+firmar servidor localhost "subjectAltName=DNS:localhost,IP:127.0.0.1"
+
+for u in "${USUARIOS[@]}"; do
+    echo "🔐 Certificado de cliente $u"
+    firmar "$u" "$u" "extendedKeyUsage=clientAuth"
+done
+chmod 600 ./*.key
+
+if command -v mosquitto_passwd &> /dev/null; then
+    rm -f "$DIR/passwd"
+    touch "$DIR/passwd"
+    for u in "${USUARIOS[@]}"; do
+        mosquitto_passwd -b "$DIR/passwd" "$u" "$CLAVE"
+    done
+    chmod 600 "$DIR/passwd"
+    echo "✅ Usuarios en $DIR/passwd: ${USUARIOS[*]}"
+else
+    echo "⚠️  mosquitto_passwd no está instalado: no se creó $DIR/passwd"
+fi
+echo "✅ Certificados en $CERTS"
@@ -56,7 +56,8 @@ export default defineConfig({
                     text: 'Referencia de API',
                     items: [
                         { text: 'WebSocket API', link: '/api/websocket' },
-                        { text: 'MQTT API', link: '/api/mqtt' }
+                        { text: 'MQTT API', link: '/api/mqtt' },
+                        { text: 'REST API', link: '/api/rest' }
                     ]
                 }
             ]
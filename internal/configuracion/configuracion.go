@@ -0,0 +1,439 @@
+// Package configuracion carga la configuración del publisher y del subscriber desde
+// (en orden de prioridad creciente) valores por defecto, un archivo JSON,
+// variables de entorno MONITOREO_* y opciones de línea de comandos.
+package configuracion
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const prefijoEntorno = "MONITOREO_"
+
+// idInquilino limita el inquilino a lo que sirve como nivel de tópico MQTT,
+// clave de Firebase y nombre de directorio.
+var idInquilino = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Duracion es un time.Duration que en JSON se escribe como "10s", "1m30s"...
+type Duracion time.Duration
+
+func (d Duracion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duracion) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duración inválida %s: use el formato \"10s\"", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duracion(v)
+	return nil
+}
+
+func (d Duracion) String() string { return time.Duration(d).String() }
+
+func (d *Duracion) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duracion(v)
+	return nil
+}
+
+type Config struct {
+	// Broker MQTT, por ejemplo tcp://localhost:1883.
+	Broker    string `json:"broker"`
+	ClienteID string `json:"cliente_id"`
+	// Credenciales MQTT; la clave conviene pasarla por MONITOREO_MQTT_CLAVE.
+	MQTTUsuario string `json:"mqtt_usuario"`
+	MQTTClave   string `json:"mqtt_clave"`
+	// TLS: CA con la que se verifica el broker y, para TLS mutuo,
+	// certificado y clave del cliente en PEM. Requieren un broker ssl://,
+	// tls://, mqtts:// o wss://.
+	MQTTCA               string `json:"mqtt_ca"`
+	MQTTCertificado      string `json:"mqtt_certificado"`
+	MQTTClaveCertificado string `json:"mqtt_clave_certificado"`
+	// El publisher abre una conexión por oficina, con cliente_id
+	// <cliente_id>-<oficina> y {oficina} reemplazado en mqtt_usuario,
+	// mqtt_clave y los archivos del certificado, para que el broker pueda
+	// limitar cada identidad a los tópicos de su oficina.
+	MQTTPorOficina bool `json:"mqtt_por_oficina"`
+	// Host y puerto del hub WebSocket al que se conectan los clientes.
+	ServidorWS string `json:"servidor_ws"`
+	// Dirección donde el subscriber sirve el hub; vacío para conectarse a
+	// ServidorWS en su lugar.
+	HubWS        string `json:"hub_ws"`
+	Credenciales string `json:"credenciales"`
+	FirebaseURL  string `json:"firebase_url"`
+	API          string `json:"api"`
+	Metricas     string `json:"metricas"`
+	// Intervalo entre lecturas de cada oficina: el publisher publica con este
+	// período y el subscriber lo usa para convertir lecturas en tiempo.
+	Intervalo Duracion `json:"intervalo"`
+	// Directorio del almacén persistente de la sesión MQTT; vacío para
+	// mantenerla en memoria.
+	AlmacenMQTT string `json:"almacen_mqtt"`
+	// Archivo JSONL donde el subscriber guarda los mensajes que no pudo
+	// decodificar; vacío para solo publicarlos en oficinas/<id>/deadletter.
+	DeadLetter string `json:"dead_letter"`
+	// Directorio del archivo de lecturas crudas del subscriber, por día y
+	// oficina; vacío para no archivarlas. RetencionArchivo es en días, 0
+	// para conservarlas siempre.
+	Archivo          string `json:"archivo"`
+	RetencionArchivo int    `json:"retencion_archivo"`
+	// Lecturas que el publisher retiene mientras no hay broker.
+	BufferMax int `json:"buffer_max"`
+	// Tiempo máximo para el cierre ordenado tras SIGINT/SIGTERM.
+	PlazoCierre Duracion `json:"plazo_cierre"`
+	// Lecturas en espera por oficina en el subscriber; al llenarse la cola
+	// las nuevas se descartan.
+	ColaOficina int `json:"cola_oficina"`
+	// Cuánto espera el subscriber lecturas atrasadas de una oficina antes de
+	// procesarlas en orden; 0 las procesa al llegar.
+	RetrasoMax Duracion `json:"retraso_max"`
+	// Desfase de reloj de un sensor a partir del cual se genera un aviso; 0
+	// para no controlarlo. Con Reestampar, las lecturas de esa oficina
+	// toman la hora de llegada al subscriber.
+	DesfaseMax Duracion `json:"desfase_max"`
+	Reestampar bool     `json:"reestampar"`
+	// Tiempo que deben seguir encendidas sin hacer falta las luces o el aire
+	// de una oficina para que el subscriber los apague; 0 desactiva la
+	// automatización.
+	RetardoAutomatizacion Duracion `json:"retardo_automatizacion"`
+	// Archivo JSON con las acciones programadas sobre los dispositivos de
+	// cada oficina; vacío para no programar ninguna.
+	Programacion string `json:"programacion"`
+	// Archivo JSON con los límites de corriente por edificio y las
+	// prioridades del deslastre de carga; vacío para desactivarlo.
+	Deslastre string `json:"deslastre"`
+	// Archivo JSON con los canales (webhooks y correo) por los que se
+	// notifican los avisos; vacío para no notificar.
+	Notificaciones string `json:"notificaciones"`
+	// Archivo JSON con las políticas de escalamiento de los avisos que
+	// nadie reconoce; requiere Notificaciones.
+	Escalamiento string `json:"escalamiento"`
+	// Organización a la que pertenecen los datos. Separa los tópicos MQTT
+	// (inquilinos/<id>/oficinas/...) y la raíz de Firebase
+	// (inquilinos/<id>/monitoreo_consumo); vacío para el espacio compartido.
+	Inquilino string `json:"inquilino"`
+	// Archivo JSON con los inquilinos que el subscriber atiende, cada uno en
+	// un proceso propio; vacío para atender solo a Inquilino.
+	Inquilinos string `json:"inquilinos"`
+}
+
+type valorBooleano struct{ p *bool }
+
+func (v valorBooleano) String() string {
+	if v.p == nil {
+		return "false"
+	}
+	return strconv.FormatBool(*v.p)
+}
+
+func (v valorBooleano) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v.p = b
+	return nil
+}
+
+// IsBoolFlag permite escribir -reestampar sin valor.
+func (v valorBooleano) IsBoolFlag() bool { return true }
+
+type opcion struct {
+	nombre string
+	ayuda  string
+	valor  flag.Value
+}
+
+type valorTexto struct{ p *string }
+
+func (v valorTexto) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v valorTexto) Set(s string) error {
+	*v.p = s
+	return nil
+}
+
+type valorEntero struct{ p *int }
+
+func (v valorEntero) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return strconv.Itoa(*v.p)
+}
+
+func (v valorEntero) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v.p = n
+	return nil
+}
+
+func (c *Config) opciones() []opcion {
+	return []opcion{
+		{"broker", "broker MQTT", valorTexto{&c.Broker}},
+		{"cliente_id", "ID de cliente MQTT", valorTexto{&c.ClienteID}},
+		{"mqtt_usuario", "usuario MQTT (vacío para conectarse sin usuario)", valorTexto{&c.MQTTUsuario}},
+		{"mqtt_clave", "clave del usuario MQTT", valorTexto{&c.MQTTClave}},
+		{"mqtt_ca", "archivo PEM de la CA del broker (vacío para las del sistema)", valorTexto{&c.MQTTCA}},
+		{"mqtt_certificado", "archivo PEM del certificado de cliente para TLS mutuo", valorTexto{&c.MQTTCertificado}},
+		{"mqtt_clave_certificado", "archivo PEM de la clave del certificado de cliente", valorTexto{&c.MQTTClaveCertificado}},
+		{"mqtt_por_oficina", "publisher: una conexión e identidad MQTT por oficina", valorBooleano{&c.MQTTPorOficina}},
+		{"servidor_ws", "host:puerto del hub WebSocket", valorTexto{&c.ServidorWS}},
+		{"hub_ws", "dirección donde servir el hub WebSocket (vacío para usar servidor_ws)", valorTexto{&c.HubWS}},
+		{"credenciales", "archivo de credenciales de Firebase", valorTexto{&c.Credenciales}},
+		{"firebase_url", "URL de Firebase Realtime Database", valorTexto{&c.FirebaseURL}},
+		{"api", "dirección del API REST (vacío para desactivarlo)", valorTexto{&c.API}},
+		{"metricas", "dirección del endpoint /metrics (vacío para desactivarlo)", valorTexto{&c.Metricas}},
+		{"intervalo", "intervalo entre lecturas de cada oficina", &c.Intervalo},
+		{"almacen_mqtt", "directorio del almacén de la sesión MQTT (vacío para memoria)", valorTexto{&c.AlmacenMQTT}},
+		{"dead_letter", "archivo JSONL de mensajes que no se pudieron decodificar", valorTexto{&c.DeadLetter}},
+		{"archivo", "directorio del archivo de lecturas crudas (vacío para desactivarlo)", valorTexto{&c.Archivo}},
+		{"retencion_archivo", "días que se conserva el archivo de lecturas (0 para siempre)", valorEntero{&c.RetencionArchivo}},
+		{"buffer_max", "lecturas retenidas sin conexión al broker", valorEntero{&c.BufferMax}},
+		{"plazo_cierre", "tiempo máximo para el cierre ordenado", &c.PlazoCierre},
+		{"cola_oficina", "lecturas en espera por oficina en el subscriber", valorEntero{&c.ColaOficina}},
+		{"retraso_max", "espera por lecturas fuera de orden en el subscriber", &c.RetrasoMax},
+		{"desfase_max", "desfase de reloj de un sensor que genera aviso (0 para no controlarlo)", &c.DesfaseMax},
+		{"reestampar", "usar la hora de llegada en lecturas de sensores con el reloj desfasado", valorBooleano{&c.Reestampar}},
+		{"retardo_automatizacion", "espera antes de apagar luces o aire que no hacen falta (0 para desactivarlo)", &c.RetardoAutomatizacion},
+		{"programacion", "archivo JSON de acciones programadas de dispositivos (vacío para desactivarlo)", valorTexto{&c.Programacion}},
+		{"deslastre", "archivo JSON de límites de corriente por edificio (vacío para desactivarlo)", valorTexto{&c.Deslastre}},
+		{"notificaciones", "archivo JSON de canales de notificación de avisos (vacío para desactivarlo)", valorTexto{&c.Notificaciones}},
+		{"escalamiento", "archivo JSON de políticas de escalamiento de avisos (vacío para desactivarlo)", valorTexto{&c.Escalamiento}},
+		{"inquilino", "organización de los datos: prefijo de tópicos y de la raíz de Firebase (vacío para el espacio compartido)", valorTexto{&c.Inquilino}},
+		{"inquilinos", "archivo JSON de inquilinos a supervisar, un subscriber por inquilino (vacío para desactivarlo)", valorTexto{&c.Inquilinos}},
+	}
+}
+
+// nombreFlag convierte "cliente_id" en "cliente-id".
+func nombreFlag(nombre string) string {
+	return strings.ReplaceAll(nombre, "_", "-")
+}
+
+// Cargar arma la configuración a partir de porDefecto. El archivo se indica
+// con -config o MONITOREO_CONFIG. Devuelve los argumentos no consumidos.
+func Cargar(programa string, porDefecto Config, args []string) (Config, []string, error) {
+	cfg := porDefecto
+
+	// Las opciones se registran sobre una copia para aplicarlas al final,
+	// después del archivo y del entorno.
+	desdeFlags := porDefecto
+	fs := flag.NewFlagSet(programa, flag.ContinueOnError)
+	archivo := fs.String("config", os.Getenv(prefijoEntorno+"CONFIG"), "archivo de configuración JSON")
+	for _, o := range desdeFlags.opciones() {
+		fs.Var(o.valor, nombreFlag(o.nombre), o.ayuda)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if *archivo != "" {
+		datos, err := os.ReadFile(*archivo)
+		if err != nil {
+			return cfg, nil, fmt.Errorf("error leyendo %s: %v", *archivo, err)
+		}
+		if err := json.Unmarshal(datos, &cfg); err != nil {
+			return cfg, nil, fmt.Errorf("error parseando %s: %v", *archivo, err)
+		}
+	}
+
+	for _, o := range cfg.opciones() {
+		if v, ok := os.LookupEnv(prefijoEntorno + strings.ToUpper(o.nombre)); ok {
+			if err := o.valor.Set(v); err != nil {
+				return cfg, nil, fmt.Errorf("%s%s: %v", prefijoEntorno, strings.ToUpper(o.nombre), err)
+			}
+		}
+	}
+
+	destinos := make(map[string]flag.Value)
+	for _, o := range cfg.opciones() {
+		destinos[nombreFlag(o.nombre)] = o.valor
+	}
+	var errFlags error
+	fs.Visit(func(f *flag.Flag) {
+		if destino, ok := destinos[f.Name]; ok {
+			if err := destino.Set(f.Value.String()); err != nil && errFlags == nil {
+				errFlags = err
+			}
+		}
+	})
+	if errFlags != nil {
+		return cfg, nil, errFlags
+	}
+
+	if err := cfg.Validar(); err != nil {
+		return cfg, nil, err
+	}
+	cfg.separarInquilino()
+	return cfg, fs.Args(), nil
+}
+
+// separarInquilino lleva los archivos locales a un subdirectorio del
+// inquilino, para que varios procesos puedan compartir la configuración.
+func (c *Config) separarInquilino() {
+	if c.Inquilino == "" {
+		return
+	}
+	for _, ruta := range []*string{&c.AlmacenMQTT, &c.Archivo} {
+		if *ruta != "" {
+			*ruta = filepath.Join(*ruta, c.Inquilino)
+		}
+	}
+	if c.DeadLetter != "" {
+		c.DeadLetter = filepath.Join(filepath.Dir(c.DeadLetter), c.Inquilino, filepath.Base(c.DeadLetter))
+	}
+}
+
+func validarDireccion(nombre, dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(dir); err != nil {
+		return fmt.Errorf("%s inválido (%q): %v", nombre, dir, err)
+	}
+	return nil
+}
+
+// Validar revisa el formato de los campos. Los campos vacíos opcionales
+// (api, metricas, hub_ws, firebase) se consideran desactivados.
+func (c Config) Validar() error {
+	var errs []error
+
+	if u, err := url.Parse(c.Broker); err != nil || u.Host == "" {
+		errs = append(errs, fmt.Errorf("broker inválido: %q", c.Broker))
+	} else {
+		switch u.Scheme {
+		case "tcp", "mqtt", "ws":
+			if c.MQTTCA != "" || c.MQTTCertificado != "" {
+				errs = append(errs, fmt.Errorf("mqtt_ca y mqtt_certificado requieren un broker TLS (ssl, tls, mqtts o wss), no %s", u.Scheme))
+			}
+		case "ssl", "tls", "mqtts", "wss":
+		default:
+			errs = append(errs, fmt.Errorf("esquema de broker no soportado: %s", u.Scheme))
+		}
+	}
+	if (c.MQTTCertificado == "") != (c.MQTTClaveCertificado == "") {
+		errs = append(errs, errors.New("mqtt_certificado y mqtt_clave_certificado van juntos"))
+	}
+	if c.MQTTClave != "" && c.MQTTUsuario == "" {
+		errs = append(errs, errors.New("mqtt_clave requiere mqtt_usuario"))
+	}
+	if c.ClienteID == "" {
+		errs = append(errs, errors.New("cliente_id no puede estar vacío"))
+	}
+	if c.ServidorWS == "" && c.HubWS == "" {
+		errs = append(errs, errors.New("servidor_ws o hub_ws debe estar definido"))
+	}
+	for _, d := range []struct{ nombre, valor string }{
+		{"servidor_ws", c.ServidorWS},
+		{"hub_ws", c.HubWS},
+		{"api", c.API},
+		{"metricas", c.Metricas},
+	} {
+		if err := validarDireccion(d.nombre, d.valor); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.FirebaseURL != "" {
+		if u, err := url.Parse(c.FirebaseURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("firebase_url inválida: %q", c.FirebaseURL))
+		}
+	}
+	if c.BufferMax < 0 {
+		errs = append(errs, fmt.Errorf("buffer_max negativo: %d", c.BufferMax))
+	}
+	if c.RetencionArchivo < 0 {
+		errs = append(errs, fmt.Errorf("retencion_archivo negativa: %d", c.RetencionArchivo))
+	}
+	if c.ColaOficina < 0 {
+		errs = append(errs, fmt.Errorf("cola_oficina negativa: %d", c.ColaOficina))
+	}
+	if c.RetrasoMax < 0 {
+		errs = append(errs, fmt.Errorf("retraso_max negativo: %s", c.RetrasoMax))
+	}
+	if c.DesfaseMax < 0 {
+		errs = append(errs, fmt.Errorf("desfase_max negativo: %s", c.DesfaseMax))
+	}
+	if c.RetardoAutomatizacion < 0 {
+		errs = append(errs, fmt.Errorf("retardo_automatizacion negativo: %s", c.RetardoAutomatizacion))
+	}
+	if c.PlazoCierre <= 0 {
+		errs = append(errs, fmt.Errorf("plazo_cierre debe ser positivo: %s", c.PlazoCierre))
+	}
+	if c.Intervalo <= 0 {
+		errs = append(errs, fmt.Errorf("intervalo debe ser positivo: %s", c.Intervalo))
+	}
+	if c.Inquilino != "" && !idInquilino.MatchString(c.Inquilino) {
+		errs = append(errs, fmt.Errorf("inquilino inválido: %q (minúsculas, dígitos, - y _)", c.Inquilino))
+	}
+	return errors.Join(errs...)
+}
+
+// RaizFirebase es la ruta bajo la que se guardan los datos del inquilino.
+func (c Config) RaizFirebase() string {
+	if c.Inquilino == "" {
+		return "monitoreo_consumo"
+	}
+	return "inquilinos/" + c.Inquilino + "/monitoreo_consumo"
+}
+
+// ValidarFirebase exige los datos de conexión a Firebase.
+func (c Config) ValidarFirebase() error {
+	if c.Credenciales == "" || c.FirebaseURL == "" {
+		return errors.New("credenciales y firebase_url son obligatorios")
+	}
+	if _, err := os.Stat(c.Credenciales); err != nil {
+		return fmt.Errorf("credenciales: %v", err)
+	}
+	return nil
+}
+
+// ParaOficina es la configuración de la conexión MQTT propia de una
+// oficina con mqtt_por_oficina.
+func (c Config) ParaOficina(oficina string) Config {
+	c.ClienteID += "-" + oficina
+	for _, v := range []*string{&c.MQTTUsuario, &c.MQTTClave, &c.MQTTCertificado, &c.MQTTClaveCertificado} {
+		*v = strings.ReplaceAll(*v, "{oficina}", oficina)
+	}
+	if c.AlmacenMQTT != "" {
+		c.AlmacenMQTT = filepath.Join(c.AlmacenMQTT, oficina)
+	}
+	return c
+}
+
+// Imprimir muestra la configuración efectiva, sin la clave MQTT.
+func (c Config) Imprimir(w io.Writer) {
+	if c.MQTTClave != "" {
+		c.MQTTClave = "********"
+	}
+	datos, _ := json.MarshalIndent(c, "", "  ")
+	fmt.Fprintf(w, "⚙️  Configuración efectiva:\n%s\n", datos)
+}
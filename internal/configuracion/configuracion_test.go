@@ -0,0 +1,159 @@
+package configuracion
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var porDefecto = Config{
+	Broker:      "tcp://localhost:1883",
+	ClienteID:   "por-defecto",
+	ServidorWS:  "localhost:8081",
+	Intervalo:   Duracion(10 * time.Second),
+	PlazoCierre: Duracion(10 * time.Second),
+	BufferMax:   100,
+}
+
+func TestCargarPrecedencia(t *testing.T) {
+	archivo := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(archivo, []byte(`{"cliente_id": "archivo", "buffer_max": 200, "intervalo": "20s"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	casos := []struct {
+		nombre    string
+		entorno   map[string]string
+		args      []string
+		clienteID string
+		bufferMax int
+		intervalo time.Duration
+	}{
+		{
+			nombre:    "por defecto",
+			clienteID: "por-defecto",
+			bufferMax: 100,
+			intervalo: 10 * time.Second,
+		},
+		{
+			nombre:    "el archivo reemplaza los valores por defecto",
+			args:      []string{"-config", archivo},
+			clienteID: "archivo",
+			bufferMax: 200,
+			intervalo: 20 * time.Second,
+		},
+		{
+			nombre:    "MONITOREO_CONFIG indica el archivo",
+			entorno:   map[string]string{"MONITOREO_CONFIG": archivo},
+			clienteID: "archivo",
+			bufferMax: 200,
+			intervalo: 20 * time.Second,
+		},
+		{
+			nombre:    "el entorno reemplaza al archivo",
+			entorno:   map[string]string{"MONITOREO_CLIENTE_ID": "entorno", "MONITOREO_INTERVALO": "30s"},
+			args:      []string{"-config", archivo},
+			clienteID: "entorno",
+			bufferMax: 200,
+			intervalo: 30 * time.Second,
+		},
+		{
+			nombre:    "las opciones reemplazan al entorno",
+			entorno:   map[string]string{"MONITOREO_CLIENTE_ID": "entorno", "MONITOREO_BUFFER_MAX": "300"},
+			args:      []string{"-config", archivo, "-cliente-id", "opcion"},
+			clienteID: "opcion",
+			bufferMax: 300,
+			intervalo: 20 * time.Second,
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			for k, v := range c.entorno {
+				t.Setenv(k, v)
+			}
+			cfg, resto, err := Cargar("prueba", porDefecto, c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resto) != 0 {
+				t.Errorf("argumentos sobrantes: %v", resto)
+			}
+			if cfg.ClienteID != c.clienteID || cfg.BufferMax != c.bufferMax || time.Duration(cfg.Intervalo) != c.intervalo {
+				t.Errorf("cliente_id=%q buffer_max=%d intervalo=%s; se esperaba %q %d %s",
+					cfg.ClienteID, cfg.BufferMax, cfg.Intervalo, c.clienteID, c.bufferMax, c.intervalo)
+			}
+		})
+	}
+}
+
+func TestCargarErrores(t *testing.T) {
+	casos := []struct {
+		nombre string
+		args   []string
+		error  error
+	}{
+		{"ayuda", []string{"-h"}, flag.ErrHelp},
+		{"opción desconocida", []string{"-no-existe"}, nil},
+		{"valor inválido", []string{"-buffer-max", "-1"}, nil},
+		{"TLS sin broker TLS", []string{"-mqtt-ca", "ca.crt"}, nil},
+		{"certificado sin clave", []string{"-broker", "ssl://localhost:8883", "-mqtt-certificado", "a.crt"}, nil},
+		{"inquilino inválido", []string{"-inquilino", "Acme"}, nil},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			_, _, err := Cargar("prueba", porDefecto, c.args)
+			if err == nil {
+				t.Fatal("se esperaba un error")
+			}
+			if c.error != nil && !errors.Is(err, c.error) {
+				t.Errorf("error %v; se esperaba %v", err, c.error)
+			}
+		})
+	}
+}
+
+func TestCargarArgumentosSobrantes(t *testing.T) {
+	_, resto, err := Cargar("prueba", porDefecto, []string{"-cliente-id", "x", "informe", "-mes", "2026-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resto) != 3 || resto[0] != "informe" {
+		t.Errorf("argumentos sobrantes: %v", resto)
+	}
+}
+
+func TestParaOficina(t *testing.T) {
+	c := porDefecto
+	c.MQTTUsuario = "sensor-{oficina}"
+	c.MQTTClave = "clave-{oficina}"
+	c.MQTTCertificado = "certs/{oficina}.crt"
+	c.MQTTClaveCertificado = "certs/{oficina}.key"
+	c.AlmacenMQTT = "data/mqtt"
+
+	o := c.ParaOficina("A")
+	esperado := Config{
+		ClienteID:            "por-defecto-A",
+		MQTTUsuario:          "sensor-A",
+		MQTTClave:            "clave-A",
+		MQTTCertificado:      "certs/A.crt",
+		MQTTClaveCertificado: "certs/A.key",
+		AlmacenMQTT:          filepath.Join("data/mqtt", "A"),
+	}
+	obtenido := Config{
+		ClienteID:            o.ClienteID,
+		MQTTUsuario:          o.MQTTUsuario,
+		MQTTClave:            o.MQTTClave,
+		MQTTCertificado:      o.MQTTCertificado,
+		MQTTClaveCertificado: o.MQTTClaveCertificado,
+		AlmacenMQTT:          o.AlmacenMQTT,
+	}
+	if obtenido != esperado {
+		t.Errorf("ParaOficina: %+v; se esperaba %+v", obtenido, esperado)
+	}
+	if c.MQTTClave != "clave-{oficina}" {
+		t.Error("ParaOficina modificó la configuración original")
+	}
+}
@@ -0,0 +1,49 @@
+// Package metricas agrupa lo común de las métricas Prometheus del publisher
+// y del subscriber.
+package metricas
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"monitoreo_consumo/internal/servidorhttp"
+)
+
+const Namespace = "monitoreo"
+
+// ReconexionesWS cuenta los intentos de reconexión WebSocket por endpoint.
+var ReconexionesWS = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "ws_reconexiones_total",
+	Help:      "Intentos de reconexión WebSocket por endpoint.",
+}, []string{"endpoint"})
+
+// ConexionMQTT vale 1 mientras el cliente MQTT está conectado.
+var ConexionMQTT = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "mqtt_conectado",
+	Help:      "1 si el cliente MQTT está conectado al broker.",
+})
+
+// ReconexionesMQTT cuenta las conexiones perdidas con el broker.
+var ReconexionesMQTT = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "mqtt_conexiones_perdidas_total",
+	Help:      "Conexiones perdidas con el broker MQTT.",
+})
+
+// Servir expone /metrics en la dirección indicada y, si salud no es nil,
+// el chequeo de salud en /salud. Bloquea hasta que se cancela ctx.
+func Servir(ctx context.Context, direccion string, salud http.Handler, plazo time.Duration) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if salud != nil {
+		mux.Handle("/salud", salud)
+	}
+	servidorhttp.Servir(ctx, "Métricas Prometheus", direccion, mux, plazo)
+}
@@ -0,0 +1,143 @@
+// Package mqttcliente arma las opciones MQTT comunes al publisher y al
+// subscriber: reconexión automática, reintento de la conexión inicial,
+// sesión persistente con QoS 1, credenciales y TLS.
+package mqttcliente
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"monitoreo_consumo/internal/configuracion"
+	"monitoreo_consumo/internal/metricas"
+)
+
+// QoS usado para publicar lecturas y suscribirse a ellas.
+const QoS = 1
+
+const (
+	intervaloReintento     = 5 * time.Second
+	maxIntervaloReconexion = time.Minute
+	keepAlive              = 30 * time.Second
+)
+
+// Opciones devuelve las opciones del cliente. alConectar se ejecuta en cada
+// conexión (inicial o reconexión) y es el lugar para suscribirse: con sesión
+// persistente el broker conserva las suscripciones, pero volver a pedirlas
+// cubre el caso de que haya perdido la sesión. Falla si no puede leer los
+// archivos TLS.
+func Opciones(cfg configuracion.Config, alConectar mqtt.OnConnectHandler) (*mqtt.ClientOptions, error) {
+	opciones := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClienteID).
+		SetCleanSession(false).
+		SetResumeSubs(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(intervaloReintento).
+		SetMaxReconnectInterval(maxIntervaloReconexion).
+		SetKeepAlive(keepAlive).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Printf("✅ Conectado al broker MQTT %s como %s", cfg.Broker, cfg.ClienteID)
+			metricas.ConexionMQTT.Set(1)
+			if alConectar != nil {
+				alConectar(c)
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("⚠️  Conexión MQTT perdida: %v", err)
+			metricas.ConexionMQTT.Set(0)
+			metricas.ReconexionesMQTT.Inc()
+		}).
+		SetReconnectingHandler(func(_ mqtt.Client, _ *mqtt.ClientOptions) {
+			log.Printf("🔄 Reconectando al broker MQTT %s...", cfg.Broker)
+		})
+
+	if cfg.AlmacenMQTT != "" {
+		opciones.SetStore(mqtt.NewFileStore(cfg.AlmacenMQTT))
+	}
+	if cfg.MQTTUsuario != "" {
+		opciones.SetUsername(cfg.MQTTUsuario).SetPassword(cfg.MQTTClave)
+	}
+	if cfg.MQTTCA != "" || cfg.MQTTCertificado != "" {
+		configTLS, err := ConfigTLS(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opciones.SetTLSConfig(configTLS)
+	}
+	return opciones, nil
+}
+
+// ConfigTLS arma la configuración TLS con la CA de mqtt_ca (o las del
+// sistema) y el certificado de cliente de mqtt_certificado, si lo hay.
+func ConfigTLS(cfg configuracion.Config) (*tls.Config, error) {
+	configTLS := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.MQTTCA != "" {
+		pem, err := os.ReadFile(cfg.MQTTCA)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt_ca: %v", err)
+		}
+		cas := x509.NewCertPool()
+		if !cas.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mqtt_ca: %s no tiene certificados PEM", cfg.MQTTCA)
+		}
+		configTLS.RootCAs = cas
+	}
+	if cfg.MQTTCertificado != "" {
+		if cfg.MQTTClaveCertificado == "" {
+			return nil, errors.New("mqtt_certificado sin mqtt_clave_certificado")
+		}
+		certificado, err := tls.LoadX509KeyPair(cfg.MQTTCertificado, cfg.MQTTClaveCertificado)
+		if err != nil {
+			return nil, fmt.Errorf("certificado de cliente MQTT: %v", err)
+		}
+		configTLS.Certificates = []tls.Certificate{certificado}
+	}
+	return configTLS, nil
+}
+
+// Conectar inicia la conexión sin bloquear: con ConnectRetry el cliente
+// reintenta en segundo plano hasta que el broker esté disponible.
+func Conectar(opciones *mqtt.ClientOptions) mqtt.Client {
+	cliente := mqtt.NewClient(opciones)
+	token := cliente.Connect()
+	go func() {
+		token.Wait()
+		if err := token.Error(); err != nil {
+			log.Printf("❌ Error conectando al broker MQTT: %v", err)
+		}
+	}()
+	return cliente
+}
+
+// Topico devuelve oficinas/<oficina>/<tipo> en el espacio del inquilino:
+// inquilinos/<inquilino>/oficinas/<oficina>/<tipo>, o sin prefijo si el
+// inquilino es vacío. Con oficina "+" es el filtro de todas las oficinas.
+func Topico(inquilino, oficina, tipo string) string {
+	topico := "oficinas/" + oficina + "/" + tipo
+	if inquilino == "" {
+		return topico
+	}
+	return "inquilinos/" + inquilino + "/" + topico
+}
+
+// OficinaDelTopico extrae la oficina de un tópico armado con Topico; vacío
+// si no tiene esa forma.
+func OficinaDelTopico(topico string) string {
+	partes := strings.Split(topico, "/")
+	if len(partes) == 5 && partes[0] == "inquilinos" {
+		partes = partes[2:]
+	}
+	if len(partes) != 3 || partes[0] != "oficinas" {
+		return ""
+	}
+	return partes[1]
+}
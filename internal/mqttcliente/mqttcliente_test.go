@@ -0,0 +1,53 @@
+package mqttcliente
+
+import "testing"
+
+func TestTopico(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		inquilino string
+		oficina   string
+		tipo      string
+		topico    string
+	}{
+		{"sin inquilino", "", "A", "sensores", "oficinas/A/sensores"},
+		{"con inquilino", "acme", "A", "comandos", "inquilinos/acme/oficinas/A/comandos"},
+		{"filtro de todas las oficinas", "acme", "+", "sensores", "inquilinos/acme/oficinas/+/sensores"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			topico := Topico(c.inquilino, c.oficina, c.tipo)
+			if topico != c.topico {
+				t.Fatalf("Topico: %q; se esperaba %q", topico, c.topico)
+			}
+			if c.oficina != "+" {
+				if oficina := OficinaDelTopico(topico); oficina != c.oficina {
+					t.Errorf("OficinaDelTopico(%q): %q; se esperaba %q", topico, oficina, c.oficina)
+				}
+			}
+		})
+	}
+}
+
+func TestOficinaDelTopico(t *testing.T) {
+	casos := []struct {
+		topico  string
+		oficina string
+	}{
+		{"oficinas/A/sensores", "A"},
+		{"inquilinos/acme/oficinas/B/deadletter", "B"},
+		{"oficinas/A", ""},
+		{"oficinas/A/sensores/extra", ""},
+		{"salas/A/sensores", ""},
+		{"inquilinos/acme/salas/A/sensores", ""},
+		{"otros/acme/oficinas/A/sensores", ""},
+		{"", ""},
+	}
+	for _, c := range casos {
+		t.Run(c.topico, func(t *testing.T) {
+			if oficina := OficinaDelTopico(c.topico); oficina != c.oficina {
+				t.Errorf("OficinaDelTopico(%q): %q; se esperaba %q", c.topico, oficina, c.oficina)
+			}
+		})
+	}
+}
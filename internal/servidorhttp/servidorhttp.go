@@ -0,0 +1,38 @@
+// Package servidorhttp levanta los servidores HTTP de los servicios y los
+// detiene ordenadamente al cancelarse el contexto.
+package servidorhttp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Servir atiende en direccion hasta que se cancela ctx; entonces deja de
+// aceptar conexiones y espera hasta plazo a que terminen las solicitudes en
+// curso. Bloquea hasta que el servidor se detiene.
+func Servir(ctx context.Context, nombre, direccion string, h http.Handler, plazo time.Duration) {
+	srv := &http.Server{Addr: direccion, Handler: h}
+
+	detenido := make(chan struct{})
+	go func() {
+		defer close(detenido)
+		<-ctx.Done()
+		ctxCierre, cancel := context.WithTimeout(context.Background(), plazo)
+		defer cancel()
+		if err := srv.Shutdown(ctxCierre); err != nil {
+			log.Printf("⚠️  %s no se detuvo a tiempo: %v", nombre, err)
+			srv.Close()
+		}
+	}()
+
+	log.Printf("🌐 %s escuchando en %s", nombre, direccion)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("❌ Error en %s: %v", nombre, err)
+		return
+	}
+	<-detenido
+	log.Printf("🛑 %s detenido", nombre)
+}
@@ -0,0 +1,217 @@
+// Package wscliente implementa el cliente WebSocket del plano de control que
+// usan el publisher y el subscriber para recibir parámetros, oficinas y
+// estados de dispositivos. Se reconecta con backoff exponencial y, como el
+// servidor envía el estado completo de cada canal al conectarse, cada
+// reconexión resincroniza a quien lo usa.
+package wscliente
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"monitoreo_consumo/internal/metricas"
+)
+
+const (
+	esperaPong      = 60 * time.Second
+	periodoPing     = esperaPong * 9 / 10
+	esperaEscritura = 10 * time.Second
+	backoffInicial  = 500 * time.Millisecond
+	backoffMaximo   = 30 * time.Second
+)
+
+var ErrDesconectado = errors.New("websocket desconectado")
+
+// Estado resume la conexión para el chequeo de salud.
+type Estado struct {
+	Endpoint      string `json:"endpoint"`
+	Conectado     bool   `json:"conectado"`
+	UltimoMensaje int64  `json:"ultimo_mensaje,omitempty"`
+	Reconexiones  int    `json:"reconexiones"`
+	UltimoError   string `json:"ultimo_error,omitempty"`
+}
+
+type Cliente struct {
+	url      string
+	endpoint string
+	procesar func([]byte)
+
+	// AlConectar, si no es nil, se llama después de cada conexión exitosa.
+	AlConectar func()
+
+	mu      sync.Mutex
+	conn    *websocket.Conn
+	escribe sync.Mutex
+	estado  Estado
+}
+
+// Nuevo crea un cliente para ws://<servidor><endpoint>. procesar recibe cada
+// mensaje tal como llega.
+func Nuevo(servidor, endpoint string, procesar func([]byte)) *Cliente {
+	u := url.URL{Scheme: "ws", Host: servidor, Path: endpoint}
+	return &Cliente{
+		url:      u.String(),
+		endpoint: endpoint,
+		procesar: procesar,
+		estado:   Estado{Endpoint: endpoint},
+	}
+}
+
+func (c *Cliente) Estado() Estado {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.estado
+}
+
+// Enviar escribe un mensaje JSON por la conexión actual.
+func (c *Cliente) Enviar(v interface{}) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrDesconectado
+	}
+
+	c.escribe.Lock()
+	defer c.escribe.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(esperaEscritura))
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// Ejecutar mantiene la conexión hasta que se cancele el contexto.
+func (c *Cliente) Ejecutar(ctx context.Context) {
+	espera := backoffInicial
+	for {
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.url, nil)
+		if err == nil {
+			espera = backoffInicial
+			err = c.atender(ctx, conn)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		c.mu.Lock()
+		c.estado.UltimoError = err.Error()
+		c.estado.Reconexiones++
+		c.mu.Unlock()
+		metricas.ReconexionesWS.WithLabelValues(c.endpoint).Inc()
+
+		// Jitter de ±20% para que los clientes no reconecten todos juntos.
+		pausa := espera + time.Duration((rand.Float64()*0.4-0.2)*float64(espera))
+		log.Printf("⚠️  WebSocket %s: %v. Reintentando en %s", c.endpoint, err, pausa.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pausa):
+		}
+		espera *= 2
+		if espera > backoffMaximo {
+			espera = backoffMaximo
+		}
+	}
+}
+
+func (c *Cliente) atender(ctx context.Context, conn *websocket.Conn) error {
+	c.mu.Lock()
+	c.conn = conn
+	c.estado.Conectado = true
+	c.mu.Unlock()
+	log.Printf("✅ WebSocket %s conectado", c.endpoint)
+
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.estado.Conectado = false
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	if c.AlConectar != nil {
+		c.AlConectar()
+	}
+
+	conn.SetReadDeadline(time.Now().Add(esperaPong))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(esperaPong))
+	})
+
+	fin := make(chan struct{})
+	defer close(fin)
+	go func() {
+		ticker := time.NewTicker(periodoPing)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				c.escribe.Lock()
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+					time.Now().Add(esperaEscritura))
+				c.escribe.Unlock()
+				conn.Close()
+				return
+			case <-fin:
+				return
+			case <-ticker.C:
+				c.escribe.Lock()
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(esperaEscritura))
+				c.escribe.Unlock()
+				if err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(esperaPong))
+		c.mu.Lock()
+		c.estado.UltimoMensaje = time.Now().Unix()
+		c.mu.Unlock()
+		c.procesar(msg)
+	}
+}
+
+// ManejadorSalud responde con el estado de los clientes: 200 si todos están
+// conectados y 503 si alguno no lo está.
+func ManejadorSalud(clientes ...*Cliente) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respuesta := struct {
+			Estado     string   `json:"estado"`
+			Conexiones []Estado `json:"conexiones"`
+		}{Estado: "ok", Conexiones: []Estado{}}
+
+		for _, c := range clientes {
+			e := c.Estado()
+			if !e.Conectado {
+				respuesta.Estado = "degradado"
+			}
+			respuesta.Conexiones = append(respuesta.Conexiones, e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if respuesta.Estado != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(respuesta)
+	}
+}
@@ -0,0 +1,136 @@
+package wscliente
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// esperar revisa la condición hasta que se cumpla o pasen 5 segundos.
+func esperar(t *testing.T, que string, condicion func() bool) {
+	t.Helper()
+	limite := time.Now().Add(5 * time.Second)
+	for !condicion() {
+		if time.Now().After(limite) {
+			t.Fatalf("no se cumplió: %s", que)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconexion(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		recibidos []string
+		enviados  = make(chan string, 1)
+		conexion  int
+	)
+	// La segunda conexión espera a que la prueba la deje pasar, para revisar
+	// el cliente mientras está desconectado.
+	permitir := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	servidor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conexion++
+		n := conexion
+		mu.Unlock()
+		if n > 1 {
+			<-permitir
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(map[int]string{1: "primera", 2: "segunda"}[n]))
+		if n == 1 {
+			// Se corta sin cierre ordenado.
+			return
+		}
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			enviados <- string(msg)
+		}
+	}))
+	defer servidor.Close()
+	var unaVez sync.Once
+	defer unaVez.Do(func() { close(permitir) })
+
+	c := Nuevo(strings.TrimPrefix(servidor.URL, "http://"), "/ws/prueba", func(msg []byte) {
+		mu.Lock()
+		recibidos = append(recibidos, string(msg))
+		mu.Unlock()
+	})
+	conexiones := 0
+	c.AlConectar = func() {
+		mu.Lock()
+		conexiones++
+		mu.Unlock()
+	}
+	ctx, cancelar := context.WithCancel(context.Background())
+	terminado := make(chan struct{})
+	go func() {
+		c.Ejecutar(ctx)
+		close(terminado)
+	}()
+
+	esperar(t, "primer mensaje y corte", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(recibidos) == 1 && conexion == 2
+	})
+	if e := c.Estado(); e.Conectado || e.Reconexiones != 1 || e.UltimoError == "" {
+		t.Errorf("estado desconectado: %+v", e)
+	}
+	if err := c.Enviar(map[string]string{"hola": "mundo"}); !errors.Is(err, ErrDesconectado) {
+		t.Errorf("Enviar desconectado: %v; se esperaba ErrDesconectado", err)
+	}
+	if err := c.Enviar(func() {}); err == nil || errors.Is(err, ErrDesconectado) {
+		t.Errorf("Enviar de un valor que no es JSON: %v", err)
+	}
+
+	unaVez.Do(func() { close(permitir) })
+	esperar(t, "reconexión", func() bool { return c.Estado().Conectado })
+	esperar(t, "mensaje después de reconectar", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(recibidos) == 2
+	})
+	mu.Lock()
+	if recibidos[0] != "primera" || recibidos[1] != "segunda" || conexiones != 2 {
+		t.Errorf("recibidos %q en %d conexiones", recibidos, conexiones)
+	}
+	mu.Unlock()
+
+	if err := c.Enviar(map[string]string{"hola": "mundo"}); err != nil {
+		t.Fatalf("Enviar conectado: %v", err)
+	}
+	select {
+	case msg := <-enviados:
+		if msg != `{"hola":"mundo"}` {
+			t.Errorf("el servidor recibió %s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("el servidor no recibió el mensaje")
+	}
+
+	cancelar()
+	select {
+	case <-terminado:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ejecutar no terminó al cancelar el contexto")
+	}
+	if c.Estado().Conectado {
+		t.Error("sigue conectado después de cancelar")
+	}
+}
@@ -234,10 +234,11 @@ comenzar() {
     # Iniciar componentes en orden
     print_info "🔄 Iniciando componentes..."
 
-    print_info "🔌 Iniciando WebSocket Server..."
-    node socket.js &
-    SOCKET_PID=$!
-    echo $SOCKET_PID >> "$DATA_DIR/pids.txt"
+    # El subscriber sirve el hub WebSocket (puerto 8081) que reemplaza a socket.js
+    print_info "📥 Iniciando Subscriber (hub WebSocket y API REST)..."
+    (cd mqtt/subscriber && go run .) &
+    SUBSCRIBER_PID=$!
+    echo $SUBSCRIBER_PID >> "$DATA_DIR/pids.txt"
     sleep 5
 
     print_info "🌐 Iniciando Dashboard..."
@@ -246,14 +247,8 @@ comenzar() {
     echo $DASHBOARD_PID >> "$DATA_DIR/pids.txt"
     sleep 5
 
-    print_info "📥 Iniciando Subscriber..."
-    (cd mqtt/subscriber && go run main.go) &
-    SUBSCRIBER_PID=$!
-    echo $SUBSCRIBER_PID >> "$DATA_DIR/pids.txt"
-    sleep 5
-
     print_info "📊 Iniciando Publisher..."
-    (cd mqtt/publisher && go run main.go) &
+    (cd mqtt/publisher && go run .) &
     PUBLISHER_PID=$!
     echo $PUBLISHER_PID >> "$DATA_DIR/pids.txt"
     sleep 3
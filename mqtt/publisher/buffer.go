@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"monitoreo_consumo/internal/mqttcliente"
+)
+
+const esperaPublicacion = 5 * time.Second
+
+type lecturaPendiente struct {
+	seq     uint64
+	oficina string
+	topico  string
+	payload []byte
+}
+
+// BufferSalida retiene las lecturas mientras el broker no está disponible.
+// Al llenarse descarta las más antiguas.
+type BufferSalida struct {
+	mu         sync.Mutex
+	pendientes []lecturaPendiente
+	max        int
+	siguiente  uint64
+	vaciando   sync.Mutex
+}
+
+func nuevoBufferSalida(max int) *BufferSalida {
+	return &BufferSalida{max: max}
+}
+
+func (b *BufferSalida) Agregar(l lecturaPendiente) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && len(b.pendientes) >= b.max {
+		descartada := b.pendientes[0]
+		b.pendientes = b.pendientes[1:]
+		metricaLecturasDescartadas.Inc()
+		metricaBufferSalida.Dec()
+		log.Printf("⚠️  Buffer lleno (%d): se descarta la lectura más antigua de %s", b.max, descartada.oficina)
+	}
+	b.siguiente++
+	l.seq = b.siguiente
+	b.pendientes = append(b.pendientes, l)
+	metricaBufferSalida.Inc()
+}
+
+func (b *BufferSalida) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pendientes)
+}
+
+// descartar vacía el buffer y devuelve cuántas lecturas tenía.
+func (b *BufferSalida) descartar() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(b.pendientes)
+	b.pendientes = nil
+	metricaBufferSalida.Sub(float64(n))
+	return n
+}
+
+// Vaciar publica en orden las lecturas pendientes mientras haya conexión.
+// Si una publicación falla, la lectura queda al frente para el próximo intento.
+func (b *BufferSalida) Vaciar(cliente mqtt.Client) int {
+	b.vaciando.Lock()
+	defer b.vaciando.Unlock()
+
+	publicadas := 0
+	for cliente.IsConnectionOpen() {
+		b.mu.Lock()
+		if len(b.pendientes) == 0 {
+			b.mu.Unlock()
+			break
+		}
+		l := b.pendientes[0]
+		b.mu.Unlock()
+
+		token := cliente.Publish(l.topico, mqttcliente.QoS, false, l.payload)
+		if !token.WaitTimeout(esperaPublicacion) || token.Error() != nil {
+			metricaErroresPublicacion.WithLabelValues(l.oficina).Inc()
+			log.Printf("❌ Error publicando en %s: %v", l.topico, token.Error())
+			break
+		}
+
+		b.mu.Lock()
+		// Agregar puede haber descartado el frente mientras se publicaba.
+		if len(b.pendientes) > 0 && b.pendientes[0].seq == l.seq {
+			b.pendientes = b.pendientes[1:]
+			metricaBufferSalida.Dec()
+		}
+		b.mu.Unlock()
+
+		metricaMensajesPublicados.WithLabelValues(l.oficina).Inc()
+		fmt.Printf("[PUBLICADO] %s -> %s\n", l.topico, l.payload)
+		publicadas++
+	}
+	return publicadas
+}
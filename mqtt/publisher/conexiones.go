@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"monitoreo_consumo/internal/configuracion"
+	"monitoreo_consumo/internal/mqttcliente"
+)
+
+// conexion es un cliente MQTT con las lecturas que retiene mientras no
+// puede publicarlas. Las publica su propia goroutine, así un broker lento o
+// caído no frena la simulación.
+type conexion struct {
+	cliente mqtt.Client
+	buffer  *BufferSalida
+	// pendiente despierta a vaciar; fin la detiene.
+	pendiente chan struct{}
+	fin       chan struct{}
+}
+
+// publicar encola la lectura para que la envíe vaciar.
+func (cx *conexion) publicar(l lecturaPendiente) {
+	cx.buffer.Agregar(l)
+	cx.avisar()
+}
+
+func (cx *conexion) avisar() {
+	select {
+	case cx.pendiente <- struct{}{}:
+	default:
+	}
+}
+
+func (cx *conexion) vaciar() {
+	for {
+		select {
+		case <-cx.fin:
+			return
+		case <-cx.pendiente:
+			cx.buffer.Vaciar(cx.cliente)
+		}
+	}
+}
+
+// conectar abre la conexión, que en cada (re)conexión se suscribe a
+// topicoComandos y reenvía lo retenido.
+func conectar(c configuracion.Config, topicoComandos string) (*conexion, error) {
+	cx := &conexion{
+		buffer:    nuevoBufferSalida(c.BufferMax),
+		pendiente: make(chan struct{}, 1),
+		fin:       make(chan struct{}),
+	}
+	opciones, err := mqttcliente.Opciones(c, func(cliente mqtt.Client) {
+		if token := cliente.Subscribe(topicoComandos, mqttcliente.QoS, aplicarComando); token.Wait() && token.Error() != nil {
+			log.Printf("❌ Error suscribiendo a %s: %v", topicoComandos, token.Error())
+		}
+		if n := cx.buffer.Len(); n > 0 {
+			log.Printf("📤 Reenviando %d lecturas retenidas", n)
+			cx.avisar()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	cx.cliente = mqttcliente.Conectar(opciones)
+	go cx.vaciar()
+	return cx, nil
+}
+
+// Conexiones reparte las oficinas entre los clientes MQTT: uno compartido
+// o, con mqtt_por_oficina, uno por oficina con su propia identidad, así
+// las ACL del broker pueden limitar cada sensor a sus tópicos.
+type Conexiones struct {
+	cfg        configuracion.Config
+	compartida *conexion
+
+	mu         sync.Mutex
+	porOficina map[string]*conexion
+	// eliminadas evita que una lectura simulada antes de eliminar la
+	// oficina vuelva a abrir su conexión.
+	eliminadas map[string]bool
+}
+
+var errOficinaEliminada = errors.New("oficina eliminada")
+
+func nuevasConexiones(c configuracion.Config) (*Conexiones, error) {
+	cs := &Conexiones{cfg: c, porOficina: make(map[string]*conexion), eliminadas: make(map[string]bool)}
+	if c.MQTTPorOficina {
+		// Las conexiones se abren con la primera lectura de cada oficina.
+		return cs, nil
+	}
+	var err error
+	cs.compartida, err = conectar(c, mqttcliente.Topico(c.Inquilino, "+", "comandos"))
+	return cs, err
+}
+
+// de devuelve la conexión por la que se publica la oficina, o
+// errOficinaEliminada si se eliminó.
+func (cs *Conexiones) de(oficina string) (*conexion, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.eliminadas[oficina] {
+		return nil, errOficinaEliminada
+	}
+	if cs.compartida != nil {
+		return cs.compartida, nil
+	}
+	if cx, ok := cs.porOficina[oficina]; ok {
+		return cx, nil
+	}
+	cx, err := conectar(cs.cfg.ParaOficina(oficina), mqttcliente.Topico(cs.cfg.Inquilino, oficina, "comandos"))
+	if err != nil {
+		return nil, err
+	}
+	cs.porOficina[oficina] = cx
+	return cx, nil
+}
+
+// activar vuelve a admitir las oficinas de la lista, por si alguna se había
+// eliminado antes.
+func (cs *Conexiones) activar(oficinas []string) {
+	cs.mu.Lock()
+	for _, oficina := range oficinas {
+		delete(cs.eliminadas, oficina)
+	}
+	cs.mu.Unlock()
+}
+
+// cerrarOficina desconecta la oficina eliminada; sus lecturas retenidas se
+// pierden.
+func (cs *Conexiones) cerrarOficina(oficina string) {
+	cs.mu.Lock()
+	cs.eliminadas[oficina] = true
+	cx, ok := cs.porOficina[oficina]
+	delete(cs.porOficina, oficina)
+	cs.mu.Unlock()
+	if !ok {
+		return
+	}
+	close(cx.fin)
+	if n := cx.buffer.descartar(); n > 0 {
+		log.Printf("⚠️  Se descartan %d lecturas sin publicar de %s", n, oficina)
+	}
+	cx.cliente.Disconnect(250)
+}
+
+func (cs *Conexiones) todas() []*conexion {
+	if cs.compartida != nil {
+		return []*conexion{cs.compartida}
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	todas := make([]*conexion, 0, len(cs.porOficina))
+	for _, cx := range cs.porOficina {
+		todas = append(todas, cx)
+	}
+	return todas
+}
+
+// cerrar intenta publicar las lecturas retenidas de todas las conexiones
+// antes de desconectarlas. Lo que no se alcance a enviar dentro del plazo
+// se pierde.
+func (cs *Conexiones) cerrar(limite <-chan time.Time) {
+	todas := cs.todas()
+	var wg sync.WaitGroup
+	for _, cx := range todas {
+		close(cx.fin)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cx.buffer.Len() > 0 && cx.cliente.IsConnectionOpen() {
+				if cx.buffer.Vaciar(cx.cliente) == 0 {
+					return
+				}
+			}
+		}()
+	}
+	vaciado := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(vaciado)
+	}()
+	select {
+	case <-vaciado:
+	case <-limite:
+		log.Println("⚠️  Plazo de cierre agotado vaciando el buffer")
+	}
+
+	pendientes := 0
+	for _, cx := range todas {
+		pendientes += cx.buffer.Len()
+		cx.cliente.Disconnect(250)
+	}
+	if pendientes > 0 {
+		log.Printf("⚠️  Se descartan %d lecturas sin publicar", pendientes)
+	}
+}
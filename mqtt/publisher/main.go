@@ -1,25 +1,52 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
-	"net/url"
-
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/gorilla/websocket"
+
+	"monitoreo_consumo/internal/configuracion"
+	"monitoreo_consumo/internal/metricas"
+	"monitoreo_consumo/internal/mqttcliente"
+	"monitoreo_consumo/internal/wscliente"
+)
+
+var (
+	cfg        configuracion.Config
+	conexiones *Conexiones
 )
 
+var cfgPorDefecto = configuracion.Config{
+	Broker:      "tcp://localhost:1883",
+	ClienteID:   "publicador-sensores",
+	ServidorWS:  "localhost:8081",
+	Metricas:    ":9100",
+	Intervalo:   configuracion.Duracion(10 * time.Second),
+	BufferMax:   1000,
+	AlmacenMQTT: "../../data/mqtt/publisher",
+	PlazoCierre: configuracion.Duracion(10 * time.Second),
+}
+
 type DatosSensor struct {
 	Oficina     string  `json:"oficina"`
 	TiempoUnix  int64   `json:"timestamp"`
 	Presencia   bool    `json:"presencia"`
 	CorrienteA  float64 `json:"corriente_a"`
 	Temperatura float64 `json:"temperatura"`
+	// Número de lectura de la oficina desde que arrancó el publisher; el
+	// subscriber lo usa para descartar duplicados.
+	Secuencia uint64 `json:"secuencia"`
 }
 
 type ParametrosConfig struct {
@@ -52,7 +79,10 @@ const (
 	consumoAire             = 10.0
 )
 
-var ultimaTemperatura = make(map[string]float64)
+var (
+	ultimaTemperatura = make(map[string]float64)
+	secuencias        = make(map[string]uint64)
+)
 
 func obtenerEstadoDispositivos(oficina string) map[string]bool {
 	mu.RLock()
@@ -139,13 +169,51 @@ func actualizarDispositivos(data []byte) {
 	if err != nil || msg.Tipo != "dispositivos" {
 		return
 	}
+	// El servidor siempre envía el estado completo, así que se reemplaza
+	// (también tras una reconexión).
 	mu.Lock()
+	dispositivos = make(map[string]map[string]bool, len(msg.Data))
 	for oficina, estados := range msg.Data {
 		dispositivos[oficina] = estados
 	}
 	mu.Unlock()
 }
 
+// ComandoDispositivo llega por oficinas/<id>/comandos cuando el subscriber
+// cambia un dispositivo, sea por el dashboard o por la automatización.
+type ComandoDispositivo struct {
+	Oficina     string `json:"oficina"`
+	Dispositivo string `json:"dispositivo"`
+	Estado      bool   `json:"estado"`
+	Origen      string `json:"origen"`
+	Motivo      string `json:"motivo"`
+}
+
+// aplicarComando hace de actuador: cambia el dispositivo en la simulación sin
+// esperar a que el estado llegue por /ws/dispositivos.
+func aplicarComando(_ mqtt.Client, msg mqtt.Message) {
+	var c ComandoDispositivo
+	if err := json.Unmarshal(msg.Payload(), &c); err != nil {
+		fmt.Printf("❌ Error parseando comando de %s: %v\n", msg.Topic(), err)
+		return
+	}
+	if c.Dispositivo != "luces" && c.Dispositivo != "aire" {
+		fmt.Printf("⚠️  Comando para dispositivo desconocido: %s\n", c.Dispositivo)
+		return
+	}
+	// Se reemplaza el mapa de la oficina en lugar de modificarlo porque
+	// obtenerEstadoDispositivos lo devuelve para leerlo sin el lock.
+	estados := map[string]bool{"aire": true, "luces": true}
+	for d, v := range obtenerEstadoDispositivos(c.Oficina) {
+		estados[d] = v
+	}
+	estados[c.Dispositivo] = c.Estado
+	mu.Lock()
+	dispositivos[c.Oficina] = estados
+	mu.Unlock()
+	fmt.Printf("🤖 Comando de %s: %s.%s = %v %s\n", c.Origen, c.Oficina, c.Dispositivo, c.Estado, c.Motivo)
+}
+
 func actualizarOficinas(data []byte) {
 	var msg struct {
 		Tipo string                 `json:"tipo"`
@@ -172,6 +240,7 @@ func actualizarOficinas(data []byte) {
 		}
 		oficinas = nuevasOficinas
 		mu.Unlock()
+		conexiones.activar(nuevasOficinas)
 
 		fmt.Printf("✅ LISTA DE OFICINAS ACTUALIZADA: %v\n", oficinas)
 	} else if msg.Tipo == "eliminar_oficina" {
@@ -207,18 +276,33 @@ func actualizarConfiguracion(data []byte) {
 	fmt.Printf("   - Umbral Corriente: %.1fA\n", params.UmbralCorriente)
 }
 
-func SimularYPublicar(cliente mqtt.Client, oficina string) {
+func SimularYPublicar(oficina string) {
+	// La oficina pudo eliminarse después de copiar la lista.
+	cx, err := conexiones.de(oficina)
+	if errors.Is(err, errOficinaEliminada) {
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Sin conexión MQTT para la oficina %s: %v", oficina, err)
+		return
+	}
+
 	ahora := time.Now()
 	timestamp := ahora.Unix()
 
 	presencia := DetectarPresencia(ahora)
 
+	// manejarEliminarOficina borra de estos mapas desde el listener.
+	mu.Lock()
 	tempAnterior, existe := ultimaTemperatura[oficina]
 	if !existe {
 		tempAnterior = rand.Float64()*(temperaturaMaxBase-temperaturaMinBase) + temperaturaMinBase
 	}
 	temperatura := CalcularSiguienteTemperatura(tempAnterior)
 	ultimaTemperatura[oficina] = temperatura
+	secuencias[oficina]++
+	secuencia := secuencias[oficina]
+	mu.Unlock()
 
 	corriente := 0.0
 	if presencia {
@@ -231,79 +315,104 @@ func SimularYPublicar(cliente mqtt.Client, oficina string) {
 		Presencia:   presencia,
 		CorrienteA:  corriente,
 		Temperatura: temperatura,
+		Secuencia:   secuencia,
 	}
 
 	payload, _ := json.Marshal(datos)
-	topico := fmt.Sprintf("oficinas/%s/sensores", oficina)
-	token := cliente.Publish(topico, 0, false, payload)
-	token.Wait()
-	fmt.Printf("[PUBLICADO] %s -> %s\n", topico, payload)
+	topico := mqttcliente.Topico(cfg.Inquilino, oficina, "sensores")
+	metricaCorriente.WithLabelValues(oficina).Set(corriente)
+	metricaTemperatura.WithLabelValues(oficina).Set(temperatura)
+
+	// Se encola siempre: si el broker no está disponible, la lectura espera
+	// en el buffer hasta la reconexión.
+	cx.publicar(lecturaPendiente{oficina: oficina, topico: topico, payload: payload})
 }
 
-func socketListener(endpoint string, updateFunc func(data []byte)) {
-	// CAMBIA de 8080 a 8081
-	u := url.URL{Scheme: "ws", Host: "localhost:8081", Path: endpoint}
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
-	if err != nil {
-		log.Printf("Error conectando a %s: %v\n", endpoint, err)
-		return
+func iniciarListeners(ctx context.Context) []*wscliente.Cliente {
+	clientes := []*wscliente.Cliente{
+		wscliente.Nuevo(cfg.ServidorWS, "/ws/dispositivos", actualizarDispositivos),
+		wscliente.Nuevo(cfg.ServidorWS, "/ws/oficinas", actualizarOficinas),
+		wscliente.Nuevo(cfg.ServidorWS, "/ws/params", actualizarConfiguracion),
 	}
-	defer conn.Close()
-
-	for {
-		_, message, err := conn.ReadMessage()
-		if err != nil {
-			log.Printf("Error leyendo mensaje de %s: %v\n", endpoint, err)
-			return
-		}
-		updateFunc(message)
+	for _, c := range clientes {
+		go c.Ejecutar(ctx)
 	}
-}
-
-func iniciarListeners() {
-	go socketListener("/ws/dispositivos", actualizarDispositivos)
-	go socketListener("/ws/oficinas", actualizarOficinas)
-	go socketListener("/ws/params", actualizarConfiguracion)
+	return clientes
 }
 
 func main() {
+	var err error
+	cfg, _, err = configuracion.Cargar("publisher", cfgPorDefecto, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		// La ayuda ya la imprimió el flag.FlagSet.
+		return
+	}
+	if err != nil {
+		log.Fatalf("❌ Configuración inválida: %v", err)
+	}
+	cfg.Imprimir(os.Stdout)
+	// Las conexiones van antes que los listeners, que pueden cerrar la de
+	// una oficina eliminada.
+	if conexiones, err = nuevasConexiones(cfg); err != nil {
+		log.Fatalf("❌ Configuración MQTT inválida: %v", err)
+	}
+
 	rand.Seed(time.Now().UnixNano())
 
-	// Test de conexión WebSocket temporal
-	fmt.Println("🔌 Probando conexión WebSocket...")
-	u := url.URL{Scheme: "ws", Host: "localhost:8081", Path: "/ws/params"}
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
-	if err != nil {
-		fmt.Printf("❌ Error conectando a WebSocket: %v\n", err)
+	ctx, detener := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer detener()
+	plazo := time.Duration(cfg.PlazoCierre)
+
+	clientesWS := iniciarListeners(ctx)
+	metricasDetenidas := make(chan struct{})
+	if cfg.Metricas != "" {
+		go func() {
+			defer close(metricasDetenidas)
+			metricas.Servir(ctx, cfg.Metricas, wscliente.ManejadorSalud(clientesWS...), plazo)
+		}()
 	} else {
-		fmt.Println("✅ WebSocket conectado correctamente")
-		conn.Close()
+		close(metricasDetenidas)
 	}
 
-	opciones := mqtt.NewClientOptions().
-		AddBroker("tcp://localhost:1883").
-		SetClientID("publicador-sensores")
-	clienteMQTT := mqtt.NewClient(opciones)
-	if token := clienteMQTT.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+	select {
+	case <-time.After(2 * time.Second):
+	case <-ctx.Done():
 	}
 
-	iniciarListeners()
-	time.Sleep(2 * time.Second)
-
-	temporizador := time.NewTicker(10 * time.Second)
+	temporizador := time.NewTicker(time.Duration(cfg.Intervalo))
 	defer temporizador.Stop()
 
-	for range temporizador.C {
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+			continue
+		case <-temporizador.C:
+		}
 		mu.RLock()
 		copyOficinas := make([]string, len(oficinas))
 		copy(copyOficinas, oficinas)
 		mu.RUnlock()
 
 		for _, oficina := range copyOficinas {
-			SimularYPublicar(clienteMQTT, oficina)
+			SimularYPublicar(oficina)
 		}
 	}
+
+	detener()
+	log.Println("🛑 Señal recibida, cerrando publisher...")
+	cerrar(plazo, metricasDetenidas)
+}
+
+// cerrar intenta publicar las lecturas retenidas antes de desconectarse.
+// Lo que no se alcance a enviar dentro del plazo se pierde.
+func cerrar(plazo time.Duration, metricasDetenidas <-chan struct{}) {
+	limite := time.After(plazo)
+	conexiones.cerrar(limite)
+	select {
+	case <-metricasDetenidas:
+	case <-limite:
+	}
+	log.Println("👋 Publisher detenido")
 }
 
 // En publisher/main.go, agrega esta función
@@ -338,7 +447,14 @@ func manejarEliminarOficina(data []byte) {
 
 	// Eliminar temperatura
 	delete(ultimaTemperatura, oficina)
+	delete(secuencias, oficina)
 	mu.Unlock()
 
+	conexiones.cerrarOficina(oficina)
+	metricaMensajesPublicados.DeleteLabelValues(oficina)
+	metricaErroresPublicacion.DeleteLabelValues(oficina)
+	metricaCorriente.DeleteLabelValues(oficina)
+	metricaTemperatura.DeleteLabelValues(oficina)
+
 	fmt.Printf("✅ Oficina %s eliminada del publisher. Oficinas restantes: %v\n", oficina, oficinas)
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"monitoreo_consumo/internal/metricas"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricaMensajesPublicados = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "mensajes_publicados_total",
+		Help:      "Lecturas publicadas por oficina.",
+	}, []string{"oficina"})
+
+	metricaErroresPublicacion = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "errores_publicacion_total",
+		Help:      "Publicaciones MQTT fallidas por oficina.",
+	}, []string{"oficina"})
+
+	metricaBufferSalida = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "buffer_salida_lecturas",
+		Help:      "Lecturas en espera de ser publicadas.",
+	})
+
+	metricaLecturasDescartadas = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "lecturas_descartadas_total",
+		Help:      "Lecturas descartadas por buffer de salida lleno.",
+	})
+
+	metricaCorriente = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "corriente_amperes",
+		Help:      "Última corriente simulada por oficina.",
+	}, []string{"oficina"})
+
+	metricaTemperatura = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "temperatura_celsius",
+		Help:      "Última temperatura simulada por oficina.",
+	}, []string{"oficina"})
+)
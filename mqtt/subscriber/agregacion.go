@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// ResumenAgregado acumula varios resúmenes de un mismo período.
+type ResumenAgregado struct {
+	Periodo             string  `json:"periodo"`
+	Desde               int64   `json:"desde"`
+	Hasta               int64   `json:"hasta"`
+	Cantidad            int     `json:"cantidad"`
+	CorrienteA          float64 `json:"corriente_a"`
+	CorrienteMaxA       float64 `json:"corriente_max_a"`
+	ConsumoKvh          float64 `json:"consumo_kvh"`
+	MinTemp             float64 `json:"min_temp"`
+	MaxTemp             float64 `json:"max_temp"`
+	TiempoPresente      int     `json:"tiempo_presente"`
+	Monto               float64 `json:"monto"`
+	EmisionesKg         float64 `json:"emisiones_kg"`
+	EmisionesEvitadasKg float64 `json:"emisiones_evitadas_kg"`
+}
+
+// Pasos de redondeo de los valores guardados en los resúmenes.
+const (
+	redondeoKvh   = 0.01
+	redondeoMonto = 0.01
+	redondeoKg    = 0.001
+)
+
+// porVentana devuelve el valor de cada resumen como diferencia entre su
+// acumulado y el del resumen anterior. Los acumulados se suman sin redondear,
+// así que sumar diferencias no arrastra el redondeo de cada ventana, que en
+// una de un minuto es del orden del valor mismo. Si la diferencia no
+// coincide con el valor propio dentro del redondeo (el primer resumen, un
+// acumulado que se reinició o un monto con otro costo por kWh), se usa el
+// propio.
+func porVentana(resumenes []Resumen, propio, acumulado func(Resumen) float64, paso float64) []float64 {
+	valores := make([]float64, len(resumenes))
+	for i, r := range resumenes {
+		valores[i] = propio(r)
+		if i == 0 {
+			continue
+		}
+		if d := acumulado(r) - acumulado(resumenes[i-1]); math.Abs(d-propio(r)) <= 1.5*paso+1e-9 {
+			valores[i] = d
+		}
+	}
+	return valores
+}
+
+// valoresVentana son el consumo, el monto y las emisiones propios de un
+// resumen, sin el redondeo de cada ventana.
+type valoresVentana struct {
+	consumo, monto, emisiones, evitadas float64
+}
+
+func valoresVentanas(resumenes []Resumen) []valoresVentana {
+	consumos := porVentana(resumenes,
+		func(r Resumen) float64 { return r.ConsumoKvh },
+		func(r Resumen) float64 { return r.ConsumoTotalKvh }, redondeoKvh)
+	montos := porVentana(resumenes,
+		func(r Resumen) float64 { return r.MontoEstimado },
+		func(r Resumen) float64 { return r.MontoTotal }, redondeoMonto)
+	emisiones := porVentana(resumenes,
+		func(r Resumen) float64 { return r.EmisionesKg },
+		func(r Resumen) float64 { return r.EmisionesTotalKg }, redondeoKg)
+	evitadas := porVentana(resumenes,
+		func(r Resumen) float64 { return r.EmisionesEvitadasKg },
+		func(r Resumen) float64 { return r.EmisionesEvitadasTotalKg }, redondeoKg)
+	valores := make([]valoresVentana, len(resumenes))
+	for i := range valores {
+		valores[i] = valoresVentana{consumos[i], montos[i], emisiones[i], evitadas[i]}
+	}
+	return valores
+}
+
+// claveAgrupacion devuelve la etiqueta del período al que pertenece el
+// instante según la agrupación pedida: "hora", "dia", "mes" o "total".
+func claveAgrupacion(instante int64, agrupar string) (string, error) {
+	t := time.Unix(instante, 0)
+	switch agrupar {
+	case "hora":
+		return t.Format("2006-01-02T15"), nil
+	case "dia":
+		return t.Format("2006-01-02"), nil
+	case "mes":
+		return t.Format("2006-01"), nil
+	case "", "total":
+		return "total", nil
+	}
+	return "", fmt.Errorf("agrupación desconocida: %s", agrupar)
+}
+
+// agregarResumenes suma los resúmenes, ordenados por timestamp, por período.
+func agregarResumenes(resumenes []Resumen, agrupar string) ([]ResumenAgregado, error) {
+	grupos := make(map[string]*ResumenAgregado)
+	sumasCorriente := make(map[string]float64)
+	valores := valoresVentanas(resumenes)
+
+	for i, r := range resumenes {
+		clave, err := claveAgrupacion(r.Timestamp, agrupar)
+		if err != nil {
+			return nil, err
+		}
+		g, existe := grupos[clave]
+		if !existe {
+			g = &ResumenAgregado{
+				Periodo: clave,
+				Desde:   r.Timestamp,
+				Hasta:   r.Timestamp,
+				MinTemp: r.MinTemp,
+				MaxTemp: r.MaxTemp,
+			}
+			grupos[clave] = g
+		}
+		g.Cantidad++
+		sumasCorriente[clave] += r.CorrienteA
+		g.CorrienteMaxA = math.Max(g.CorrienteMaxA, r.CorrienteA)
+		g.ConsumoKvh += valores[i].consumo
+		g.MinTemp = math.Min(g.MinTemp, r.MinTemp)
+		g.MaxTemp = math.Max(g.MaxTemp, r.MaxTemp)
+		g.TiempoPresente += r.TiempoPresente
+		g.Monto += valores[i].monto
+		g.EmisionesKg += valores[i].emisiones
+		g.EmisionesEvitadasKg += valores[i].evitadas
+		if r.Timestamp < g.Desde {
+			g.Desde = r.Timestamp
+		}
+		if r.Timestamp > g.Hasta {
+			g.Hasta = r.Timestamp
+		}
+	}
+
+	agregados := make([]ResumenAgregado, 0, len(grupos))
+	for clave, g := range grupos {
+		g.CorrienteA = math.Round(sumasCorriente[clave]/float64(g.Cantidad)*100) / 100
+		g.ConsumoKvh = math.Round(g.ConsumoKvh*100) / 100
+		g.Monto = math.Round(g.Monto*100) / 100
+		g.EmisionesKg = math.Round(g.EmisionesKg*1000) / 1000
+		g.EmisionesEvitadasKg = math.Round(g.EmisionesEvitadasKg*1000) / 1000
+		agregados = append(agregados, *g)
+	}
+	sort.Slice(agregados, func(i, j int) bool {
+		return agregados[i].Desde < agregados[j].Desde
+	})
+	return agregados, nil
+}
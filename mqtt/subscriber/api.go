@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"monitoreo_consumo/internal/servidorhttp"
+)
+
+func nuevoMuxAPI() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/oficinas", manejarListarOficinas)
+	mux.HandleFunc("GET /api/oficinas/{oficina}/resumenes", manejarResumenes)
+	mux.HandleFunc("GET /api/avisos", manejarAvisos)
+	mux.HandleFunc("GET /api/estados", manejarEstados)
+	mux.HandleFunc("GET /api/estados/{oficina}", manejarEstadoOficina)
+	mux.HandleFunc("GET /api/emisiones", manejarEmisiones)
+	mux.HandleFunc("GET /api/jerarquia", manejarJerarquia)
+	mux.HandleFunc("GET /api/grupos/resumenes", manejarResumenesGrupo)
+	mux.HandleFunc("GET /api/grupos/avisos", manejarAvisosGrupo)
+	return mux
+}
+
+func iniciarAPI(ctx context.Context, direccion string, plazo time.Duration) {
+	servidorhttp.Servir(ctx, "API REST", direccion, nuevoMuxAPI(), plazo)
+}
+
+func responderJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("❌ Error escribiendo respuesta: %v", err)
+	}
+}
+
+func responderError(w http.ResponseWriter, status int, err error) {
+	responderJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// parsearInstante acepta un Unix timestamp, una fecha RFC3339 o YYYY-MM-DD.
+func parsearInstante(valor string, porDefecto int64) (int64, error) {
+	if valor == "" {
+		return porDefecto, nil
+	}
+	if n, err := strconv.ParseInt(valor, 10, 64); err == nil {
+		return n, nil
+	}
+	if t, err := time.Parse(time.RFC3339, valor); err == nil {
+		return t.Unix(), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", valor, time.Local); err == nil {
+		return t.Unix(), nil
+	}
+	return 0, fmt.Errorf("instante inválido: %s", valor)
+}
+
+// parsearRango lee desde/hasta de la query; por defecto, las últimas 24 horas.
+func parsearRango(r *http.Request) (int64, int64, error) {
+	hasta, err := parsearInstante(r.URL.Query().Get("hasta"), time.Now().Unix())
+	if err != nil {
+		return 0, 0, err
+	}
+	desde, err := parsearInstante(r.URL.Query().Get("desde"), hasta-24*3600)
+	if err != nil {
+		return 0, 0, err
+	}
+	if desde > hasta {
+		return 0, 0, fmt.Errorf("desde (%d) es posterior a hasta (%d)", desde, hasta)
+	}
+	return desde, hasta, nil
+}
+
+func existeOficina(oficina string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, o := range oficinas {
+		if o == oficina {
+			return true
+		}
+	}
+	return false
+}
+
+func manejarListarOficinas(w http.ResponseWriter, r *http.Request) {
+	responderJSON(w, http.StatusOK, listarOficinas())
+}
+
+func manejarResumenes(w http.ResponseWriter, r *http.Request) {
+	oficina := r.PathValue("oficina")
+	if !existeOficina(oficina) {
+		responderError(w, http.StatusNotFound, fmt.Errorf("oficina desconocida: %s", oficina))
+		return
+	}
+	desde, hasta, err := parsearRango(r)
+	if err != nil {
+		responderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resumenes, err := leerResumenes(r.Context(), oficina, desde, hasta)
+	if err != nil {
+		responderError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	agrupar := r.URL.Query().Get("agrupar")
+	if agrupar == "" {
+		responderJSON(w, http.StatusOK, resumenes)
+		return
+	}
+	agregados, err := agregarResumenes(resumenes, agrupar)
+	if err != nil {
+		responderError(w, http.StatusBadRequest, err)
+		return
+	}
+	responderJSON(w, http.StatusOK, agregados)
+}
+
+// manejarAvisos lista avisos filtrando por oficina, tipo, impacto mínimo y
+// rango de tiempo. Los más recientes primero, hasta "limite" (100 por defecto).
+func manejarAvisos(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	desde, hasta, err := parsearRango(r)
+	if err != nil {
+		responderError(w, http.StatusBadRequest, err)
+		return
+	}
+	limite := 100
+	if v := q.Get("limite"); v != "" {
+		if limite, err = strconv.Atoi(v); err != nil || limite <= 0 {
+			responderError(w, http.StatusBadRequest, fmt.Errorf("límite inválido: %s", v))
+			return
+		}
+	}
+	impactoMin := int64(0)
+	if v := q.Get("impacto_min"); v != "" {
+		if impactoMin, err = strconv.ParseInt(v, 10, 64); err != nil {
+			responderError(w, http.StatusBadRequest, fmt.Errorf("impacto inválido: %s", v))
+			return
+		}
+	}
+
+	filtro := filtroDeQuery(q)
+	consultadas := oficinasDe(filtro)
+	if oficina := q.Get("oficina"); oficina != "" {
+		if !existeOficina(oficina) {
+			responderError(w, http.StatusNotFound, fmt.Errorf("oficina desconocida: %s", oficina))
+			return
+		}
+		consultadas = nil
+		if filtro.incluye(ubicacionDe(oficina)) {
+			consultadas = []string{oficina}
+		}
+	}
+
+	mu.RLock()
+	catalogo := tiposAvisos
+	mu.RUnlock()
+
+	tipo := q.Get("tipo")
+	avisos := []AvisoOficina{}
+	for _, oficina := range consultadas {
+		encontrados, err := leerAvisos(r.Context(), oficina, desde, hasta)
+		if err != nil {
+			responderError(w, http.StatusBadGateway, err)
+			return
+		}
+		for _, a := range encontrados {
+			if tipo != "" && a.IDTipo != tipo {
+				continue
+			}
+			if impactoMin > 0 && catalogo[a.IDTipo].Impacto < impactoMin {
+				continue
+			}
+			avisos = append(avisos, a)
+		}
+	}
+
+	sort.Slice(avisos, func(i, j int) bool {
+		return avisos[i].Timestamp > avisos[j].Timestamp
+	})
+	if len(avisos) > limite {
+		avisos = avisos[:limite]
+	}
+	responderJSON(w, http.StatusOK, avisos)
+}
+
+func manejarEstados(w http.ResponseWriter, r *http.Request) {
+	responderJSON(w, http.StatusOK, snapshotsEstados())
+}
+
+func manejarEstadoOficina(w http.ResponseWriter, r *http.Request) {
+	oficina := r.PathValue("oficina")
+	mu.RLock()
+	est, existe := mapaEstados[oficina]
+	mu.RUnlock()
+	if !existe {
+		responderError(w, http.StatusNotFound, fmt.Errorf("oficina desconocida: %s", oficina))
+		return
+	}
+	responderJSON(w, http.StatusOK, est.snapshot(oficina))
+}
+
+func manejarEmisiones(w http.ResponseWriter, r *http.Request) {
+	total, porOficina := rollupEmisiones()
+	responderJSON(w, http.StatusOK, map[string]interface{}{
+		"total":    total,
+		"oficinas": porOficina,
+	})
+}
+
+func manejarJerarquia(w http.ResponseWriter, r *http.Request) {
+	sitios, sectores := arbolJerarquia()
+	responderJSON(w, http.StatusOK, map[string]interface{}{
+		"sitios":   sitios,
+		"sectores": sectores,
+	})
+}
+
+// oficinasDelGrupo devuelve las oficinas que cumplen los filtros sitio,
+// edificio, piso y sector de la query; sin filtros, todas.
+func oficinasDelGrupo(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	lista := oficinasDe(filtroDeQuery(r.URL.Query()))
+	if len(lista) == 0 {
+		responderError(w, http.StatusNotFound, fmt.Errorf("ninguna oficina coincide con el filtro"))
+		return nil, false
+	}
+	return lista, true
+}
+
+// manejarResumenesGrupo suma los resúmenes agregados de las oficinas del
+// grupo; agrupa por hora si no se indica otra cosa.
+func manejarResumenesGrupo(w http.ResponseWriter, r *http.Request) {
+	lista, ok := oficinasDelGrupo(w, r)
+	if !ok {
+		return
+	}
+	desde, hasta, err := parsearRango(r)
+	if err != nil {
+		responderError(w, http.StatusBadRequest, err)
+		return
+	}
+	agrupar := r.URL.Query().Get("agrupar")
+	if agrupar == "" {
+		agrupar = "hora"
+	}
+	if _, err := claveAgrupacion(0, agrupar); err != nil {
+		responderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	porOficina := make([][]ResumenAgregado, 0, len(lista))
+	for _, oficina := range lista {
+		resumenes, err := leerResumenes(r.Context(), oficina, desde, hasta)
+		if err != nil {
+			responderError(w, http.StatusBadGateway, err)
+			return
+		}
+		agregados, err := agregarResumenes(resumenes, agrupar)
+		if err != nil {
+			responderError(w, http.StatusBadRequest, err)
+			return
+		}
+		porOficina = append(porOficina, agregados)
+	}
+	responderJSON(w, http.StatusOK, map[string]interface{}{
+		"oficinas":  lista,
+		"resumenes": sumarAgregados(porOficina),
+	})
+}
+
+// manejarAvisosGrupo cuenta los avisos de las oficinas del grupo por tipo.
+func manejarAvisosGrupo(w http.ResponseWriter, r *http.Request) {
+	lista, ok := oficinasDelGrupo(w, r)
+	if !ok {
+		return
+	}
+	desde, hasta, err := parsearRango(r)
+	if err != nil {
+		responderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var avisos []AvisoOficina
+	for _, oficina := range lista {
+		encontrados, err := leerAvisos(r.Context(), oficina, desde, hasta)
+		if err != nil {
+			responderError(w, http.StatusBadGateway, err)
+			return
+		}
+		avisos = append(avisos, encontrados...)
+	}
+	mu.RLock()
+	catalogo := tiposAvisos
+	mu.RUnlock()
+	responderJSON(w, http.StatusOK, map[string]interface{}{
+		"oficinas": lista,
+		"total":    len(avisos),
+		"por_tipo": contarAvisos(avisos, catalogo, 0),
+	})
+}
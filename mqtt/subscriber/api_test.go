@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// conOficinas reemplaza la lista de oficinas durante la prueba.
+func conOficinas(t *testing.T, lista ...string) {
+	t.Helper()
+	mu.Lock()
+	anteriores := oficinas
+	oficinas = lista
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		oficinas = anteriores
+		mu.Unlock()
+	})
+}
+
+// resumenesDePrueba arma n resúmenes cada paso desde el instante, con kwh
+// por ventana y los mismos redondeos que calcularResumen.
+func resumenesDePrueba(desde time.Time, paso time.Duration, n int, kwh float64) []Resumen {
+	redondear := func(v, escala float64) float64 { return math.Round(v*escala) / escala }
+	resumenes := make([]Resumen, n)
+	for i := range resumenes {
+		total := kwh * float64(i+1)
+		resumenes[i] = Resumen{
+			Timestamp:        desde.Add(time.Duration(i) * paso).Unix(),
+			CorrienteA:       1,
+			ConsumoKvh:       redondear(kwh, 100),
+			ConsumoTotalKvh:  redondear(total, 100),
+			MontoEstimado:    redondear(kwh*0.25, 100),
+			MontoTotal:       redondear(total*0.25, 100),
+			EmisionesKg:      redondear(kwh*0.5, 1000),
+			EmisionesTotalKg: redondear(total*0.5, 1000),
+		}
+	}
+	return resumenes
+}
+
+func TestAgregarResumenes(t *testing.T) {
+	// Una hora de ventanas de un minuto de 0,0036 kWh: cada consumo_kvh
+	// redondeado es 0, pero la hora suma 0,216 kWh.
+	resumenes := resumenesDePrueba(time.Date(2025, 12, 1, 10, 0, 0, 0, time.Local), time.Minute, 60, 0.0036)
+	agregados, err := agregarResumenes(resumenes, "hora")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agregados) != 1 {
+		t.Fatalf("agregados: %+v", agregados)
+	}
+	a := agregados[0]
+	if a.Cantidad != 60 || math.Abs(a.ConsumoKvh-0.216) > 0.011 || math.Abs(a.Monto-0.054) > 0.011 || math.Abs(a.EmisionesKg-0.108) > 0.0011 {
+		t.Errorf("agregado: %+v; se esperaban 0,216 kWh, 0,054 de monto y 0,108 kg", a)
+	}
+
+	// Un acumulado que vuelve a empezar no resta: se usa el consumo propio.
+	reinicio := resumenesDePrueba(time.Date(2025, 12, 1, 11, 0, 0, 0, time.Local), time.Minute, 2, 1)
+	agregados, err = agregarResumenes(append(resumenesDePrueba(time.Date(2025, 12, 1, 10, 0, 0, 0, time.Local), time.Minute, 2, 1), reinicio...), "total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agregados[0].ConsumoKvh != 4 {
+		t.Errorf("consumo con reinicio: %v; se esperaba 4", agregados[0].ConsumoKvh)
+	}
+
+	if _, err := agregarResumenes(resumenes, "semana"); err == nil {
+		t.Error("se aceptó una agrupación desconocida")
+	}
+}
+
+func TestAPIResumenes(t *testing.T) {
+	// Cada 10 minutos de 22:00 a 01:50 del día siguiente, 0,05 kWh por ventana.
+	inicio := time.Date(2025, 12, 1, 22, 0, 0, 0, time.Local)
+	resumenes := make(map[string]Resumen)
+	for i, r := range resumenesDePrueba(inicio, 10*time.Minute, 24, 0.05) {
+		resumenes[fmt.Sprintf("r%02d", i)] = r
+	}
+	datos, _ := json.Marshal(map[string]interface{}{"monitoreo_consumo": map[string]interface{}{
+		"oficinas": map[string]interface{}{"A": map[string]interface{}{"resumenes": resumenes}},
+	}})
+	f := usarFirebaseDePrueba(t, string(datos))
+	conOficinas(t, "A", "B")
+
+	rango := fmt.Sprintf("desde=%d&hasta=%d", inicio.Unix(), inicio.Add(4*time.Hour).Unix())
+	casos := []struct {
+		nombre     string
+		ruta       string
+		falla      bool
+		status     int
+		cantidad   int
+		consumos   []float64
+		periodo    string
+		sinAgrupar bool
+	}{
+		{"sin agrupar", "/api/oficinas/A/resumenes?" + rango, false, http.StatusOK, 24, nil, "", true},
+		{"por hora", "/api/oficinas/A/resumenes?agrupar=hora&" + rango, false, http.StatusOK, 4, []float64{0.3, 0.3, 0.3, 0.3}, "2025-12-01T22", false},
+		{"por día", "/api/oficinas/A/resumenes?agrupar=dia&" + rango, false, http.StatusOK, 2, []float64{0.6, 0.6}, "2025-12-01", false},
+		{"por mes", "/api/oficinas/A/resumenes?agrupar=mes&" + rango, false, http.StatusOK, 1, []float64{1.2}, "2025-12", false},
+		{"total", "/api/oficinas/A/resumenes?agrupar=total&" + rango, false, http.StatusOK, 1, []float64{1.2}, "total", false},
+		{"rango parcial", fmt.Sprintf("/api/oficinas/A/resumenes?agrupar=total&desde=%d&hasta=%d", inicio.Unix(), inicio.Add(55*time.Minute).Unix()), false, http.StatusOK, 1, []float64{0.3}, "total", false},
+		{"oficina sin resúmenes", "/api/oficinas/B/resumenes?agrupar=dia&" + rango, false, http.StatusOK, 0, nil, "", false},
+		{"desde posterior a hasta", fmt.Sprintf("/api/oficinas/A/resumenes?desde=%d&hasta=%d", inicio.Unix()+1, inicio.Unix()), false, http.StatusBadRequest, 0, nil, "", false},
+		{"desde inválido", "/api/oficinas/A/resumenes?desde=ayer", false, http.StatusBadRequest, 0, nil, "", false},
+		{"hasta en fecha", "/api/oficinas/A/resumenes?agrupar=dia&desde=2025-12-01&hasta=2025-12-02", false, http.StatusOK, 2, []float64{0.6, 0.05}, "2025-12-01", false},
+		{"agrupación desconocida", "/api/oficinas/A/resumenes?agrupar=semana&" + rango, false, http.StatusBadRequest, 0, nil, "", false},
+		{"oficina desconocida", "/api/oficinas/Z/resumenes?" + rango, false, http.StatusNotFound, 0, nil, "", false},
+		{"falla de Firebase", "/api/oficinas/A/resumenes?" + rango, true, http.StatusBadGateway, 0, nil, "", false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			f.mu.Lock()
+			if c.falla {
+				f.fallas["GET monitoreo_consumo/oficinas/A/resumenes"] = http.StatusForbidden
+			} else {
+				delete(f.fallas, "GET monitoreo_consumo/oficinas/A/resumenes")
+			}
+			f.mu.Unlock()
+
+			w := httptest.NewRecorder()
+			nuevoMuxAPI().ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.ruta, nil))
+			if w.Code != c.status {
+				t.Fatalf("status %d; se esperaba %d: %s", w.Code, c.status, w.Body)
+			}
+			if c.status != http.StatusOK {
+				var e map[string]string
+				if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e["error"] == "" {
+					t.Errorf("respuesta de error sin mensaje: %s", w.Body)
+				}
+				return
+			}
+			if c.sinAgrupar {
+				var lista []Resumen
+				if err := json.Unmarshal(w.Body.Bytes(), &lista); err != nil {
+					t.Fatal(err)
+				}
+				if len(lista) != c.cantidad || lista[0].Timestamp != inicio.Unix() {
+					t.Errorf("%d resúmenes; se esperaban %d desde %d", len(lista), c.cantidad, inicio.Unix())
+				}
+				return
+			}
+			var agregados []ResumenAgregado
+			if err := json.Unmarshal(w.Body.Bytes(), &agregados); err != nil {
+				t.Fatal(err)
+			}
+			if len(agregados) != c.cantidad {
+				t.Fatalf("%d períodos; se esperaban %d: %+v", len(agregados), c.cantidad, agregados)
+			}
+			for i, consumo := range c.consumos {
+				if math.Abs(agregados[i].ConsumoKvh-consumo) > 0.011 {
+					t.Errorf("período %s: %v kWh; se esperaban %v", agregados[i].Periodo, agregados[i].ConsumoKvh, consumo)
+				}
+			}
+			if c.periodo != "" && agregados[0].Periodo != c.periodo {
+				t.Errorf("primer período %q; se esperaba %q", agregados[0].Periodo, c.periodo)
+			}
+		})
+	}
+}
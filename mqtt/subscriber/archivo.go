@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	intervaloArchivo = 30 * time.Second
+	formatoDia       = "2006-01-02"
+)
+
+// LecturaArchivada es una lectura tal como llegó, con su hora de llegada.
+type LecturaArchivada struct {
+	DatosSensor
+	Recibida int64 `json:"recibida"`
+}
+
+type particionArchivo struct {
+	dia, oficina string
+}
+
+func (p particionArchivo) ruta(dir string) string {
+	return filepath.Join(dir, p.dia, p.oficina+".jsonl.gz")
+}
+
+// Archivo guarda todas las lecturas válidas en <dir>/<AAAA-MM-DD>/<oficina>.jsonl.gz,
+// según el día del timestamp de la lectura. Las lecturas se juntan en memoria
+// y cada intervaloArchivo se agregan a los archivos como un nuevo miembro
+// gzip, así no quedan archivos abiertos y una caída pierde a lo sumo las
+// del último intervalo. Los días más viejos que la retención se borran.
+type Archivo struct {
+	dir       string
+	retencion int
+
+	mu          sync.Mutex
+	pendientes  map[particionArchivo]*bytes.Buffer
+	ultimaPurga time.Time
+
+	detener   chan struct{}
+	terminado chan struct{}
+}
+
+var archivo *Archivo
+
+func nuevoArchivo(dir string, retencionDias int) *Archivo {
+	return &Archivo{
+		dir:        dir,
+		retencion:  retencionDias,
+		pendientes: make(map[particionArchivo]*bytes.Buffer),
+		detener:    make(chan struct{}),
+		terminado:  make(chan struct{}),
+	}
+}
+
+func (a *Archivo) iniciar() {
+	go func() {
+		defer close(a.terminado)
+		t := time.NewTicker(intervaloArchivo)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				a.volcar()
+			case <-a.detener:
+				a.volcar()
+				return
+			}
+		}
+	}()
+}
+
+// Agregar no hace E/S; se puede llamar desde el callback de paho.
+func (a *Archivo) Agregar(datos DatosSensor) {
+	linea, err := json.Marshal(LecturaArchivada{DatosSensor: datos, Recibida: datos.llegada()})
+	if err != nil {
+		return
+	}
+	p := particionArchivo{
+		dia:     time.Unix(datos.Timestamp, 0).Format(formatoDia),
+		oficina: datos.Oficina,
+	}
+	a.mu.Lock()
+	buf := a.pendientes[p]
+	if buf == nil {
+		buf = new(bytes.Buffer)
+		a.pendientes[p] = buf
+	}
+	buf.Write(linea)
+	buf.WriteByte('\n')
+	a.mu.Unlock()
+}
+
+func (a *Archivo) volcar() {
+	a.mu.Lock()
+	pendientes := a.pendientes
+	a.pendientes = make(map[particionArchivo]*bytes.Buffer)
+	a.mu.Unlock()
+
+	for p, buf := range pendientes {
+		if err := a.escribir(p, buf.Bytes()); err != nil {
+			metricaErroresArchivo.Inc()
+			log.Printf("❌ Error archivando lecturas de %s del %s: %v", p.oficina, p.dia, err)
+			continue
+		}
+		metricaLecturasArchivadas.Add(float64(bytes.Count(buf.Bytes(), []byte{'\n'})))
+	}
+
+	if a.retencion > 0 && time.Since(a.ultimaPurga) >= time.Hour {
+		a.ultimaPurga = time.Now()
+		a.purgar()
+	}
+}
+
+func (a *Archivo) escribir(p particionArchivo, datos []byte) error {
+	ruta := p.ruta(a.dir)
+	if err := os.MkdirAll(filepath.Dir(ruta), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ruta, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(datos); err != nil {
+		f.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// purgar borra los directorios de días fuera de la retención.
+func (a *Archivo) purgar() {
+	dias, err := os.ReadDir(a.dir)
+	if err != nil {
+		return
+	}
+	limite := time.Now().AddDate(0, 0, -a.retencion).Format(formatoDia)
+	for _, d := range dias {
+		if _, err := time.Parse(formatoDia, d.Name()); err != nil || !d.IsDir() {
+			continue
+		}
+		if d.Name() < limite {
+			if err := os.RemoveAll(filepath.Join(a.dir, d.Name())); err != nil {
+				log.Printf("❌ Error borrando archivo del %s: %v", d.Name(), err)
+				continue
+			}
+			log.Printf("🧹 Archivo del %s borrado por retención", d.Name())
+		}
+	}
+}
+
+// cerrar vuelca lo pendiente. No se debe llamar a Agregar después.
+func (a *Archivo) cerrar() {
+	close(a.detener)
+	<-a.terminado
+}
+
+// leerArchivo devuelve las lecturas archivadas de una oficina en un día. Si
+// el último miembro gzip quedó cortado (una caída a mitad de escritura), se
+// devuelven las lecturas anteriores.
+func leerArchivo(dir, dia, oficina string) ([]LecturaArchivada, error) {
+	ruta := particionArchivo{dia, oficina}.ruta(dir)
+	f, err := os.Open(ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ruta, err)
+	}
+
+	var (
+		lecturas []LecturaArchivada
+		errLinea error
+	)
+	lector := bufio.NewScanner(gz)
+	for n := 1; lector.Scan(); n++ {
+		if errLinea != nil {
+			return nil, errLinea
+		}
+		var l LecturaArchivada
+		if err := json.Unmarshal(lector.Bytes(), &l); err != nil {
+			// Solo se admite si es la última línea de un archivo cortado.
+			errLinea = fmt.Errorf("%s:%d: %v", ruta, n, err)
+			continue
+		}
+		l.recibida = l.Recibida
+		lecturas = append(lecturas, l)
+	}
+	if err := lector.Err(); err != nil {
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%s: %v", ruta, err)
+		}
+		log.Printf("⚠️  %s termina cortado; se usan las %d lecturas completas", ruta, len(lecturas))
+		return lecturas, nil
+	}
+	return lecturas, errLinea
+}
+
+// oficinasArchivadas lista las oficinas con lecturas en alguno de los días.
+func oficinasArchivadas(dir string, dias []string) ([]string, error) {
+	vistas := make(map[string]bool)
+	for _, dia := range dias {
+		entradas, err := os.ReadDir(filepath.Join(dir, dia))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entradas {
+			if oficina, ok := strings.CutSuffix(e.Name(), ".jsonl.gz"); ok && !e.IsDir() {
+				vistas[oficina] = true
+			}
+		}
+	}
+	lista := make([]string, 0, len(vistas))
+	for oficina := range vistas {
+		lista = append(lista, oficina)
+	}
+	sort.Strings(lista)
+	return lista, nil
+}
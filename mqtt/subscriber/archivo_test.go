@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func lecturaArchivo(oficina string, instante time.Time, corriente float64) DatosSensor {
+	return DatosSensor{Oficina: oficina, Timestamp: instante.Unix(), CorrienteA: corriente, recibida: instante.Unix() + 2}
+}
+
+func corrientesArchivadas(t *testing.T, dir, dia, oficina string) []float64 {
+	t.Helper()
+	lecturas, err := leerArchivo(dir, dia, oficina)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var corrientes []float64
+	for _, l := range lecturas {
+		if l.Recibida != l.Timestamp+2 || l.llegada() != l.Recibida {
+			t.Errorf("lectura de %s sin su hora de llegada: %+v", oficina, l)
+		}
+		corrientes = append(corrientes, l.CorrienteA)
+	}
+	return corrientes
+}
+
+func TestArchivoParticiones(t *testing.T) {
+	dir := t.TempDir()
+	a := nuevoArchivo(dir, 0)
+	a.iniciar()
+
+	// La partición es el día del timestamp, no el de llegada.
+	noche := time.Date(2025, 12, 1, 23, 59, 50, 0, time.Local)
+	a.Agregar(lecturaArchivo("A", noche, 1))
+	a.Agregar(lecturaArchivo("B", noche, 2))
+	a.Agregar(lecturaArchivo("A", noche.Add(20*time.Second), 3))
+	a.volcar()
+	// Un segundo volcado agrega otro miembro gzip al mismo archivo.
+	a.Agregar(lecturaArchivo("A", noche.Add(5*time.Second), 4))
+	a.Agregar(lecturaArchivo("A", noche.Add(30*time.Second), 5))
+	a.cerrar()
+
+	casos := []struct {
+		dia, oficina string
+		corrientes   []float64
+	}{
+		{"2025-12-01", "A", []float64{1, 4}},
+		{"2025-12-01", "B", []float64{2}},
+		{"2025-12-02", "A", []float64{3, 5}},
+		{"2025-12-02", "B", nil},
+		{"2025-12-03", "A", nil},
+	}
+	for _, c := range casos {
+		if corrientes := corrientesArchivadas(t, dir, c.dia, c.oficina); !reflect.DeepEqual(corrientes, c.corrientes) {
+			t.Errorf("%s del %s: %v; se esperaba %v", c.oficina, c.dia, corrientes, c.corrientes)
+		}
+	}
+
+	oficinas, err := oficinasArchivadas(dir, []string{"2025-12-01", "2025-12-02", "2025-12-03"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(oficinas, []string{"A", "B"}) {
+		t.Errorf("oficinasArchivadas: %v", oficinas)
+	}
+	if oficinas, _ := oficinasArchivadas(dir, []string{"2025-12-02"}); !reflect.DeepEqual(oficinas, []string{"A"}) {
+		t.Errorf("oficinasArchivadas del 2 de diciembre: %v", oficinas)
+	}
+}
+
+func TestPurgarArchivo(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	dia := func(dias int) string { return time.Now().AddDate(0, 0, -dias).Format(formatoDia) }
+	for _, d := range []string{dia(0), dia(7), dia(8), dia(30), "otros"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Un archivo con nombre de día no es una partición.
+	if err := os.WriteFile(filepath.Join(dir, dia(40)), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := nuevoArchivo(dir, 7)
+	a.Agregar(lecturaArchivo("A", time.Now(), 1))
+	a.volcar()
+
+	casos := []struct {
+		nombre string
+		queda  bool
+	}{
+		{dia(0), true},
+		{dia(7), true},
+		{dia(8), false},
+		{dia(30), false},
+		{"otros", true},
+		{dia(40), true},
+	}
+	for _, c := range casos {
+		_, err := os.Stat(filepath.Join(dir, c.nombre))
+		if queda := err == nil; queda != c.queda {
+			t.Errorf("%s: queda %v; se esperaba %v", c.nombre, queda, c.queda)
+		}
+	}
+
+	// La purga corre a lo sumo una vez por hora.
+	if err := os.MkdirAll(filepath.Join(dir, dia(9)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	a.volcar()
+	if _, err := os.Stat(filepath.Join(dir, dia(9))); err != nil {
+		t.Error("se volvió a purgar antes de una hora")
+	}
+}
+
+func TestLeerArchivoCortado(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	a := nuevoArchivo(dir, 0)
+	inicio := time.Date(2025, 12, 1, 10, 0, 0, 0, time.Local)
+	a.Agregar(lecturaArchivo("A", inicio, 1))
+	a.Agregar(lecturaArchivo("A", inicio.Add(time.Second), 2))
+	a.volcar()
+	ruta := particionArchivo{"2025-12-01", "A"}.ruta(dir)
+	primero, err := os.Stat(ruta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 3; i <= 50; i++ {
+		a.Agregar(lecturaArchivo("A", inicio.Add(time.Duration(i)*time.Second), float64(i)))
+	}
+	a.volcar()
+	completo, err := os.ReadFile(ruta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cortado en cualquier punto del segundo miembro, se leen las lecturas
+	// del primero y las líneas completas que se alcanzaron a descomprimir
+	// (todas, si se cortó en la cola del miembro).
+	for corte := int(primero.Size()) + 1; corte < len(completo); corte++ {
+		if err := os.WriteFile(ruta, completo[:corte], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		corrientes := corrientesArchivadas(t, dir, "2025-12-01", "A")
+		if len(corrientes) < 2 || len(corrientes) > 50 {
+			t.Fatalf("cortado en %d de %d: %d lecturas", corte, len(completo), len(corrientes))
+		}
+		for i, c := range corrientes {
+			if c != float64(i+1) {
+				t.Fatalf("cortado en %d: lectura %d con corriente %v", corte, i, c)
+			}
+		}
+	}
+
+	// Sin siquiera la cabecera del primer miembro no hay nada que leer.
+	if err := os.WriteFile(ruta, completo[:5], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leerArchivo(dir, "2025-12-01", "A"); err == nil {
+		t.Error("se leyó un archivo sin cabecera gzip")
+	}
+}
+
+func TestLeerArchivoLineaInvalida(t *testing.T) {
+	dir := t.TempDir()
+	a := nuevoArchivo(dir, 0)
+	p := particionArchivo{"2025-12-01", "A"}
+	if err := a.escribir(p, []byte("{\"oficina\": \"A\"}\nno es json\n{\"oficina\": \"A\"}\n")); err != nil {
+		t.Fatal(err)
+	}
+	// Una línea inválida que no es la última no es un corte: es un error.
+	if _, err := leerArchivo(dir, p.dia, p.oficina); err == nil {
+		t.Error("se aceptó una línea inválida en medio del archivo")
+	}
+}
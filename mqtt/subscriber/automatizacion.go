@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"monitoreo_consumo/internal/mqttcliente"
+	"monitoreo_consumo/internal/wscliente"
+)
+
+const tamanoColaAcciones = 64
+
+// ComandoDispositivo es el pedido de cambiar un dispositivo de una oficina.
+// Se publica en oficinas/<id>/comandos para el publisher y los actuadores.
+type ComandoDispositivo struct {
+	Oficina     string `json:"oficina"`
+	Dispositivo string `json:"dispositivo"`
+	Estado      bool   `json:"estado"`
+	// Origen es "dashboard", "automatizacion", "programacion" o "deslastre".
+	Origen    string `json:"origen"`
+	Motivo    string `json:"motivo,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+var (
+	clienteMQTT mqtt.Client
+	// clienteDispositivos es la conexión a /ws/dispositivos cuando el hub
+	// lo sirve socket.js.
+	clienteDispositivos *wscliente.Cliente
+	automatizacion      *Automatizacion
+)
+
+func topicoComandos(oficina string) string {
+	return mqttcliente.Topico(cfg.Inquilino, oficina, "comandos")
+}
+
+// accionarDispositivo cambia el estado de un dispositivo por el mismo camino
+// que el toggle del dashboard y publica el comando para los actuadores.
+func accionarDispositivo(ctx context.Context, c ComandoDispositivo) error {
+	switch {
+	case hub != nil:
+		if err := hub.actualizarDispositivo(ctx, c.Oficina, c.Dispositivo, c.Estado); err != nil {
+			return err
+		}
+	case clienteDispositivos != nil:
+		err := clienteDispositivos.Enviar(map[string]interface{}{
+			"tipo":        "actualizar_dispositivo",
+			"oficina":     c.Oficina,
+			"dispositivo": c.Dispositivo,
+			"estado":      c.Estado,
+		})
+		if err != nil {
+			return fmt.Errorf("error enviando a /ws/dispositivos: %v", err)
+		}
+	}
+	publicarComando(c)
+	if deslastre != nil && c.Dispositivo == "aire" && c.Origen != "deslastre" {
+		deslastre.liberar(c.Oficina)
+	}
+	return nil
+}
+
+// publicarComando no retiene el mensaje: un comando viejo no debe pisar un
+// cambio hecho después desde socket.js, que no pasa por MQTT.
+func publicarComando(c ComandoDispositivo) {
+	if clienteMQTT == nil {
+		return
+	}
+	datos, _ := json.Marshal(c)
+	token := clienteMQTT.Publish(topicoComandos(c.Oficina), mqttcliente.QoS, false, datos)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Printf("❌ Error publicando comando para %s.%s: %v", c.Oficina, c.Dispositivo, token.Error())
+		}
+	}()
+}
+
+// condicionAutomatica sigue un dispositivo que está encendido sin hacer
+// falta, con los timestamps de las lecturas.
+type condicionAutomatica struct {
+	desde     int64
+	accionada int64
+}
+
+// Automatizacion apaga las luces y el aire que siguen encendidos sin hacer
+// falta durante el retardo configurado. Los trabajadores de la ingesta
+// evalúan cada lectura y las acciones se ejecutan en una sola goroutine,
+// para no frenarlos con las escrituras.
+type Automatizacion struct {
+	retardo     time.Duration
+	mu          sync.Mutex
+	condiciones map[string]*condicionAutomatica
+	// pausas guarda hasta cuándo no se apaga cada dispositivo que encendió
+	// una acción programada.
+	pausas   map[string]time.Time
+	acciones chan ComandoDispositivo
+}
+
+func nuevaAutomatizacion(retardo time.Duration) *Automatizacion {
+	return &Automatizacion{
+		retardo:     retardo,
+		condiciones: make(map[string]*condicionAutomatica),
+		pausas:      make(map[string]time.Time),
+		acciones:    make(chan ComandoDispositivo, tamanoColaAcciones),
+	}
+}
+
+// dispositivosSobrantes devuelve los dispositivos encendidos que no hacen
+// falta con el motivo. Son las mismas condiciones de los avisos 2 y 5.
+func dispositivosSobrantes(datos DatosSensor, estadoDispositivo map[string]bool, params ParametrosConfig) map[string]string {
+	sobrantes := make(map[string]string)
+	if estadoDispositivo["luces"] && !datos.Presencia {
+		sobrantes["luces"] = "sin presencia"
+	}
+	if estadoDispositivo["aire"] {
+		if !datos.Presencia {
+			sobrantes["aire"] = "sin presencia"
+		} else if datos.Temperatura <= params.UmbralTemperaturaAC {
+			sobrantes["aire"] = fmt.Sprintf("temperatura %.1f°C bajo el umbral de %.1f°C", datos.Temperatura, params.UmbralTemperaturaAC)
+		}
+	}
+	return sobrantes
+}
+
+// evaluar registra la lectura y encola el apagado de los dispositivos que
+// llevan el retardo sin hacer falta. Si el apagado no se refleja en el
+// estado, se repite después de otro retardo.
+func (a *Automatizacion) evaluar(datos DatosSensor) {
+	mu.RLock()
+	estadoDispositivo := dispositivoEstados[datos.Oficina]
+	params := config
+	mu.RUnlock()
+	sobrantes := dispositivosSobrantes(datos, estadoDispositivo, params)
+
+	// Una lectura vieja, como las que el broker entrega tras una caída del
+	// subscriber, no describe la oficina de ahora.
+	vigencia := a.retardo + time.Duration(cfg.RetrasoMax)
+	reciente := time.Now().Unix()-datos.Timestamp <= int64(vigencia.Seconds())
+	retardo := int64(a.retardo.Seconds())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, dispositivo := range []string{"luces", "aire"} {
+		clave := datos.Oficina + "/" + dispositivo
+		motivo, sobra := sobrantes[dispositivo]
+		if hasta, pausado := a.pausas[clave]; pausado {
+			if time.Now().Before(hasta) {
+				sobra = false
+			} else {
+				delete(a.pausas, clave)
+			}
+		}
+		if !sobra {
+			delete(a.condiciones, clave)
+			continue
+		}
+		c, existe := a.condiciones[clave]
+		if !existe {
+			a.condiciones[clave] = &condicionAutomatica{desde: datos.Timestamp}
+			continue
+		}
+		if !reciente || datos.Timestamp-c.desde < retardo || datos.Timestamp-c.accionada < retardo {
+			continue
+		}
+		c.accionada = datos.Timestamp
+
+		comando := ComandoDispositivo{
+			Oficina:     datos.Oficina,
+			Dispositivo: dispositivo,
+			Estado:      false,
+			Origen:      "automatizacion",
+			Motivo:      fmt.Sprintf("%s %s durante %s", dispositivo, motivo, a.retardo),
+			Timestamp:   time.Now().Unix(),
+		}
+		select {
+		case a.acciones <- comando:
+		default:
+			log.Printf("⚠️  Cola de acciones automáticas llena, se omite %s.%s", datos.Oficina, dispositivo)
+		}
+	}
+}
+
+// posponer evita apagar el dispositivo hasta el instante indicado.
+func (a *Automatizacion) posponer(oficina, dispositivo string, hasta time.Time) {
+	a.mu.Lock()
+	a.pausas[oficina+"/"+dispositivo] = hasta
+	a.mu.Unlock()
+}
+
+// olvidar descarta las condiciones de una oficina eliminada.
+func (a *Automatizacion) olvidar(oficina string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, dispositivo := range []string{"luces", "aire"} {
+		delete(a.condiciones, oficina+"/"+dispositivo)
+		delete(a.pausas, oficina+"/"+dispositivo)
+	}
+}
+
+// ejecutar apaga los dispositivos encolados y deja un aviso de cada acción.
+func (a *Automatizacion) ejecutar(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-a.acciones:
+			a.accionar(c)
+		}
+	}
+}
+
+func (a *Automatizacion) accionar(c ComandoDispositivo) {
+	if err := accionarDispositivo(context.Background(), c); err != nil {
+		metricaAccionesAutomaticas.WithLabelValues(c.Dispositivo, "error").Inc()
+		log.Printf("❌ Error en acción automática sobre %s.%s: %v", c.Oficina, c.Dispositivo, err)
+		return
+	}
+	metricaAccionesAutomaticas.WithLabelValues(c.Dispositivo, "ok").Inc()
+	log.Printf("🤖 Acción automática: %s.%s = %v (%s)", c.Oficina, c.Dispositivo, c.Estado, c.Motivo)
+
+	aviso := Aviso{
+		Timestamp: c.Timestamp,
+		IDTipo:    avisoAccionAutomatica,
+		Adicional: "Apagado automático: " + c.Motivo,
+	}
+	metricaAvisos.WithLabelValues(aviso.IDTipo).Inc()
+	if !ingesta.intentarEscribir(escrituraAviso(c.Oficina, aviso)) {
+		log.Printf("⚠️  No se pudo encolar el aviso de la acción automática sobre %s.%s", c.Oficina, c.Dispositivo)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+// conAutomatizacion deja los dispositivos encendidos de la oficina A, un
+// umbral de 24°C y un retraso máximo de un minuto, y devuelve una
+// automatización con un minuto de retardo: las lecturas de más de dos
+// minutos no son recientes.
+func conAutomatizacion(t *testing.T, encendidos ...string) *Automatizacion {
+	t.Helper()
+	anteriorCfg := cfg
+	cfg.RetrasoMax = configuracion.Duracion(time.Minute)
+	mu.Lock()
+	anteriorConfig, anterioresEstados := config, dispositivoEstados
+	config = paramsPorDefecto
+	config.UmbralTemperaturaAC = 24
+	dispositivoEstados = map[string]map[string]bool{"A": {}}
+	for _, d := range encendidos {
+		dispositivoEstados["A"][d] = true
+	}
+	mu.Unlock()
+	t.Cleanup(func() {
+		cfg = anteriorCfg
+		mu.Lock()
+		config, dispositivoEstados = anteriorConfig, anterioresEstados
+		mu.Unlock()
+	})
+	return nuevaAutomatizacion(time.Minute)
+}
+
+// lecturaAutomatizacion es una lectura de la oficina A de hace antiguedad
+// segundos.
+type lecturaAutomatizacion struct {
+	antiguedad  int64
+	presencia   bool
+	temperatura float64
+}
+
+// evaluarLecturas evalúa las lecturas en orden y devuelve lo que llegó a la
+// cola de acciones después de cada una, como "<índice>:<dispositivo>".
+func evaluarLecturas(t *testing.T, a *Automatizacion, lecturas []lecturaAutomatizacion) []string {
+	t.Helper()
+	ahora := time.Now().Unix()
+	var acciones []string
+	for i, l := range lecturas {
+		a.evaluar(DatosSensor{
+			Oficina:     "A",
+			Timestamp:   ahora - l.antiguedad,
+			Presencia:   l.presencia,
+			Temperatura: l.temperatura,
+		})
+		for len(a.acciones) > 0 {
+			c := <-a.acciones
+			if c.Oficina != "A" || c.Estado || c.Origen != "automatizacion" {
+				t.Errorf("comando inesperado: %+v", c)
+			}
+			acciones = append(acciones, fmt.Sprintf("%d:%s", i, c.Dispositivo))
+		}
+	}
+	return acciones
+}
+
+func TestAutomatizacionEvaluar(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	casos := []struct {
+		nombre     string
+		encendidos []string
+		// pausa es hasta cuándo, desde ahora, están pausadas las luces.
+		pausa    time.Duration
+		lecturas []lecturaAutomatizacion
+		acciones []string
+	}{
+		{
+			nombre:     "antes del retardo no apaga",
+			encendidos: []string{"luces"},
+			lecturas:   []lecturaAutomatizacion{{antiguedad: 100}, {antiguedad: 41}},
+		},
+		{
+			nombre:     "apaga al cumplir el retardo",
+			encendidos: []string{"luces"},
+			lecturas:   []lecturaAutomatizacion{{antiguedad: 100}, {antiguedad: 70}, {antiguedad: 40}},
+			acciones:   []string{"2:luces"},
+		},
+		{
+			nombre:     "la presencia reinicia la cuenta",
+			encendidos: []string{"luces"},
+			lecturas: []lecturaAutomatizacion{
+				{antiguedad: 110}, {antiguedad: 80, presencia: true}, {antiguedad: 60}, {antiguedad: 10},
+			},
+		},
+		{
+			nombre:     "repite tras otro retardo si el apagado no se refleja",
+			encendidos: []string{"luces"},
+			lecturas: []lecturaAutomatizacion{
+				{antiguedad: 150}, {antiguedad: 90}, {antiguedad: 40}, {antiguedad: 30},
+			},
+			acciones: []string{"1:luces", "3:luces"},
+		},
+		{
+			// Las lecturas viejas cuentan para el retardo, pero no apagan.
+			nombre:     "las lecturas viejas no apagan",
+			encendidos: []string{"luces"},
+			lecturas: []lecturaAutomatizacion{
+				{antiguedad: 300}, {antiguedad: 240}, {antiguedad: 180}, {antiguedad: 121}, {antiguedad: 10},
+			},
+			acciones: []string{"4:luces"},
+		},
+		{
+			nombre:     "aire con presencia bajo el umbral",
+			encendidos: []string{"aire"},
+			lecturas: []lecturaAutomatizacion{
+				{antiguedad: 100, presencia: true, temperatura: 20}, {antiguedad: 40, presencia: true, temperatura: 24},
+			},
+			acciones: []string{"1:aire"},
+		},
+		{
+			nombre:     "aire con presencia sobre el umbral",
+			encendidos: []string{"aire"},
+			lecturas: []lecturaAutomatizacion{
+				{antiguedad: 100, presencia: true, temperatura: 28}, {antiguedad: 40, presencia: true, temperatura: 28},
+			},
+		},
+		{
+			nombre:     "sin presencia apaga luces y aire",
+			encendidos: []string{"luces", "aire"},
+			lecturas:   []lecturaAutomatizacion{{antiguedad: 100, temperatura: 28}, {antiguedad: 40, temperatura: 28}},
+			acciones:   []string{"1:luces", "1:aire"},
+		},
+		{
+			nombre:   "apagados no se apagan",
+			lecturas: []lecturaAutomatizacion{{antiguedad: 100}, {antiguedad: 40}},
+		},
+		{
+			nombre:     "pausa vigente",
+			encendidos: []string{"luces", "aire"},
+			pausa:      time.Hour,
+			lecturas:   []lecturaAutomatizacion{{antiguedad: 100}, {antiguedad: 40}},
+			acciones:   []string{"1:aire"},
+		},
+		{
+			nombre:     "pausa vencida",
+			encendidos: []string{"luces"},
+			pausa:      -time.Second,
+			lecturas:   []lecturaAutomatizacion{{antiguedad: 100}, {antiguedad: 40}},
+			acciones:   []string{"1:luces"},
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			a := conAutomatizacion(t, c.encendidos...)
+			if c.pausa != 0 {
+				a.posponer("A", "luces", time.Now().Add(c.pausa))
+			}
+			if acciones := evaluarLecturas(t, a, c.lecturas); !reflect.DeepEqual(acciones, c.acciones) {
+				t.Errorf("acciones: %v; se esperaba %v", acciones, c.acciones)
+			}
+			if _, pausado := a.pausas["A/luces"]; c.pausa < 0 && pausado {
+				t.Error("quedó la pausa vencida")
+			}
+		})
+	}
+}
+
+func TestAutomatizacionPosponer(t *testing.T) {
+	a := conAutomatizacion(t, "luces")
+	evaluarLecturas(t, a, []lecturaAutomatizacion{{antiguedad: 100}})
+
+	// La pausa descarta lo que llevaba sin hacer falta: al vencer, el
+	// retardo se cuenta de nuevo. Sin la pausa, la lectura de hace 30
+	// segundos ya apagaría.
+	a.posponer("A", "luces", time.Now().Add(time.Hour))
+	if acciones := evaluarLecturas(t, a, []lecturaAutomatizacion{{antiguedad: 80}}); acciones != nil {
+		t.Errorf("se apagó durante la pausa: %v", acciones)
+	}
+	a.posponer("A", "luces", time.Now().Add(-time.Second))
+	acciones := evaluarLecturas(t, a, []lecturaAutomatizacion{{antiguedad: 70}, {antiguedad: 30}, {antiguedad: 10}})
+	if !reflect.DeepEqual(acciones, []string{"2:luces"}) {
+		t.Errorf("acciones: %v; se esperaba [2:luces]", acciones)
+	}
+}
+
+func TestAutomatizacionMotivo(t *testing.T) {
+	a := conAutomatizacion(t, "luces", "aire")
+	a.evaluar(DatosSensor{Oficina: "A", Timestamp: time.Now().Unix() - 60, Presencia: true, Temperatura: 21})
+	a.evaluar(DatosSensor{Oficina: "A", Timestamp: time.Now().Unix(), Presencia: true, Temperatura: 21.5})
+	if len(a.acciones) != 1 {
+		t.Fatalf("%d acciones; se esperaba el apagado del aire", len(a.acciones))
+	}
+	c := <-a.acciones
+	if c.Dispositivo != "aire" || !strings.Contains(c.Motivo, "temperatura 21.5°C bajo el umbral de 24.0°C") || !strings.HasSuffix(c.Motivo, "durante 1m0s") {
+		t.Errorf("comando: %+v", c)
+	}
+}
+
+func TestAutomatizacionColaLlena(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	a := conAutomatizacion(t, "luces")
+	for i := 0; i < cap(a.acciones); i++ {
+		a.acciones <- ComandoDispositivo{}
+	}
+	// Con la cola llena se omite el apagado sin frenar al trabajador.
+	ahora := time.Now().Unix()
+	a.evaluar(DatosSensor{Oficina: "A", Timestamp: ahora - 100})
+	a.evaluar(DatosSensor{Oficina: "A", Timestamp: ahora - 40})
+	if len(a.acciones) != cap(a.acciones) {
+		t.Errorf("%d acciones en la cola", len(a.acciones))
+	}
+	if c := a.condiciones["A/luces"]; c == nil || c.accionada != ahora-40 {
+		t.Errorf("condición: %+v", c)
+	}
+
+	a.olvidar("A")
+	if len(a.condiciones) != 0 || len(a.pausas) != 0 {
+		t.Errorf("quedaron condiciones %v y pausas %v", a.condiciones, a.pausas)
+	}
+}
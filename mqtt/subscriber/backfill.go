@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"firebase.google.com/go/db"
+)
+
+// Rutas por actualización multi-ruta al reescribir una oficina.
+const maxRutasBackfill = 500
+
+// Avisos que se pueden recalcular solo con las lecturas. Los de luces y aire
+// dependen del estado de los dispositivos, que no se archiva, y el de reloj
+// desfasado, de la corrección que hace la ingesta.
+var avisosRecalculables = map[string]bool{
+	avisoConsumoAnomalo:   true,
+	avisoCorteEnergia:     true,
+	avisoSensorNoResponde: true,
+	avisoAlertaCorriente:  true,
+}
+
+// recalculo es el resultado de volver a procesar las lecturas archivadas de
+// una oficina.
+type recalculo struct {
+	lecturas  int
+	resumenes []Resumen
+	avisos    []Aviso
+}
+
+// comandoBackfill recalcula los resúmenes de un rango a partir del archivo de
+// lecturas, con los parámetros indicados, y reemplaza los guardados:
+//
+//	go run . backfill -desde 2025-03-01 -hasta 2025-04-01 -params voltaje.json
+func comandoBackfill(args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	desdeTxt := fs.String("desde", "", "inicio del rango (unix, RFC3339 o AAAA-MM-DD)")
+	hastaTxt := fs.String("hasta", "", "fin del rango, excluido (unix, RFC3339 o AAAA-MM-DD)")
+	listaOficinas := fs.String("oficina", "", "oficinas separadas por comas (por defecto, todas las archivadas)")
+	rutaParams := fs.String("params", "", "JSON con los parámetros que reemplazan a los guardados en Firebase")
+	conAvisos := fs.Bool("avisos", false, "reemplazar también los avisos que se pueden recalcular")
+	simular := fs.Bool("simular", false, "mostrar el resultado sin escribir en Firebase")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.Archivo == "" {
+		return errors.New("archivo no está configurado")
+	}
+	if *desdeTxt == "" || *hastaTxt == "" {
+		return errors.New("uso: backfill -desde T -hasta T [-oficina X,Y] [-params archivo.json] [-avisos] [-simular]")
+	}
+	desde, err := parsearInstante(*desdeTxt, 0)
+	if err != nil {
+		return err
+	}
+	hasta, err := parsearInstante(*hastaTxt, 0)
+	if err != nil {
+		return err
+	}
+	if desde >= hasta {
+		return fmt.Errorf("desde (%d) debe ser anterior a hasta (%d)", desde, hasta)
+	}
+	if err := cfg.ValidarFirebase(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if err := conectarFirebase(ctx); err != nil {
+		return err
+	}
+	params, err := leerParametros(ctx, *rutaParams)
+	if err != nil {
+		return err
+	}
+
+	dias := diasDelRango(desde, hasta)
+	var lista []string
+	if *listaOficinas != "" {
+		lista = strings.Split(*listaOficinas, ",")
+	} else if lista, err = oficinasArchivadas(cfg.Archivo, dias); err != nil {
+		return err
+	}
+
+	fmt.Printf("🔁 Backfill de %d oficinas entre %s y %s\n", len(lista),
+		time.Unix(desde, 0).Format(time.RFC3339), time.Unix(hasta, 0).Format(time.RFC3339))
+	fmt.Printf("   voltaje %.1f V, costo %.3f/kWh, umbral de corriente %.1f A\n",
+		params.Voltaje, params.CostoKwh, params.UmbralCorriente)
+
+	for _, oficina := range lista {
+		if !oficinaValida(oficina) {
+			return fmt.Errorf("oficina inválida: %q", oficina)
+		}
+		lecturas, err := lecturasArchivadas(oficina, dias, desde, hasta)
+		if err != nil {
+			return err
+		}
+		base := clienteFirebase.NewRef(rutaFirebase("oficinas/") + oficina)
+		var previos map[string]Resumen
+		err = base.Child("resumenes").OrderByChild("timestamp").EndAt(desde-1).LimitToLast(1).Get(ctx, &previos)
+		if err != nil {
+			return fmt.Errorf("error leyendo el resumen previo de %s: %v", oficina, err)
+		}
+		var previo *Resumen
+		for _, r := range previos {
+			previo = &r
+		}
+
+		r := recalcularOficina(lecturas, previo, params, hasta)
+		if err := reemplazarOficina(ctx, base, oficina, r, previo, desde, hasta, *conAvisos, *simular); err != nil {
+			return err
+		}
+	}
+	if *simular {
+		fmt.Println("Simulación: no se escribió nada")
+	}
+	return nil
+}
+
+// leerParametros lee los parámetros guardados en Firebase y, si se indica,
+// les aplica los de un archivo JSON.
+func leerParametros(ctx context.Context, ruta string) (ParametrosConfig, error) {
+	params := paramsPorDefecto
+	if err := clienteFirebase.NewRef(rutaFirebase("configuracion")).Get(ctx, &params); err != nil {
+		return params, fmt.Errorf("error leyendo configuración: %v", err)
+	}
+	if params.Voltaje == 0 {
+		params = paramsPorDefecto
+	}
+	if ruta == "" {
+		return params, validarFactoresHora(params)
+	}
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return params, err
+	}
+	// Los campos ausentes conservan el valor guardado.
+	if err := json.Unmarshal(datos, &params); err != nil {
+		return params, fmt.Errorf("%s: %v", ruta, err)
+	}
+	return params, validarFactoresHora(params)
+}
+
+// diasDelRango lista las particiones del archivo que cubren [desde, hasta).
+func diasDelRango(desde, hasta int64) []string {
+	var dias []string
+	fin := time.Unix(hasta-1, 0).Format(formatoDia)
+	for t := time.Unix(desde, 0); ; t = t.AddDate(0, 0, 1) {
+		dia := t.Format(formatoDia)
+		dias = append(dias, dia)
+		if dia >= fin {
+			return dias
+		}
+	}
+}
+
+// lecturasArchivadas devuelve las lecturas de la oficina en [desde, hasta),
+// ordenadas y sin repetidas.
+func lecturasArchivadas(oficina string, dias []string, desde, hasta int64) ([]DatosSensor, error) {
+	var lecturas []DatosSensor
+	vistas := make(map[claveLectura]bool)
+	for _, dia := range dias {
+		archivadas, err := leerArchivo(cfg.Archivo, dia, oficina)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range archivadas {
+			clave := claveLectura{l.Timestamp, l.Secuencia}
+			if l.Timestamp < desde || l.Timestamp >= hasta || vistas[clave] {
+				continue
+			}
+			vistas[clave] = true
+			lecturas = append(lecturas, l.DatosSensor)
+		}
+	}
+	sort.Sort(lecturasPorTiempo(lecturas))
+	return lecturas, nil
+}
+
+// recalcularOficina repite lo que hace procesarLectura con cada lectura. Los
+// acumulados continúan los del último resumen anterior al rango.
+func recalcularOficina(lecturas []DatosSensor, previo *Resumen, params ParametrosConfig, hasta int64) recalculo {
+	r := recalculo{lecturas: len(lecturas)}
+	estado := &EstadoOficina{}
+	if previo != nil {
+		estado.Consumos = []float64{previo.ConsumoTotalKvh}
+		estado.EmisionesTotalKg = previo.EmisionesTotalKg
+		estado.EmisionesEvitadasKg = previo.EmisionesEvitadasTotalKg
+	}
+	cerrar := func(ahora int64) {
+		r.resumenes = append(r.resumenes, calcularResumen(ahora, estado, params))
+		estado.Corrientes = nil
+		estado.Temperaturas = nil
+		estado.TiempoPresente = 0
+	}
+
+	for _, datos := range lecturas {
+		if len(estado.Corrientes) > 0 && datos.Timestamp-estado.InicioVentana >= 60 {
+			cerrar(datos.Timestamp)
+		}
+		if len(estado.Corrientes) == 0 {
+			estado.InicioVentana = datos.Timestamp
+		}
+		acumularLectura(estado, datos)
+		estado.UltimaLectura = datos.Timestamp
+
+		for _, av := range evaluarAvisos(datos, estado, params, nil, datos.Timestamp) {
+			if avisosRecalculables[av.IDTipo] {
+				r.avisos = append(r.avisos, av)
+			}
+		}
+		if llegada := datos.llegada(); llegada > estado.UltimaRecepcion {
+			estado.UltimaRecepcion = llegada
+		}
+	}
+	if len(estado.Corrientes) > 0 {
+		// El último resumen queda dentro del rango para que otra pasada lo
+		// encuentre y lo reemplace.
+		cerrar(min(estado.UltimaLectura+int64(segundosPorLectura()), hasta-1))
+	}
+	return r
+}
+
+// reemplazarOficina borra los resúmenes y correcciones del rango (y, con
+// conAvisos, los avisos recalculables) y guarda los recalculados con claves
+// deterministas, así repetir el backfill deja el mismo resultado. Los
+// resúmenes posteriores al rango se reacumulan desde el último recalculado.
+func reemplazarOficina(ctx context.Context, base *db.Ref, oficina string, r recalculo, previo *Resumen, desde, hasta int64, conAvisos, simular bool) error {
+	var resumenes map[string]Resumen
+	if err := leerRango(ctx, base.Child("resumenes"), desde, hasta, &resumenes); err != nil {
+		return fmt.Errorf("error leyendo resúmenes de %s: %v", oficina, err)
+	}
+	var correcciones map[string]json.RawMessage
+	if err := leerRango(ctx, base.Child("correcciones"), desde, hasta, &correcciones); err != nil {
+		return fmt.Errorf("error leyendo correcciones de %s: %v", oficina, err)
+	}
+	var posteriores map[string]Resumen
+	if err := base.Child("resumenes").OrderByChild("timestamp").StartAt(hasta).Get(ctx, &posteriores); err != nil {
+		return fmt.Errorf("error leyendo resúmenes posteriores de %s: %v", oficina, err)
+	}
+	var avisos map[string]Aviso
+	if conAvisos {
+		if err := leerRango(ctx, base.Child("avisos"), desde, hasta, &avisos); err != nil {
+			return fmt.Errorf("error leyendo avisos de %s: %v", oficina, err)
+		}
+	}
+
+	cambios := make(map[string]interface{})
+	for clave := range resumenes {
+		cambios["resumenes/"+clave] = nil
+	}
+	viejos := ordenarResumenes(resumenes)
+	antes := sumarVentanas(previo, viejos)
+	for clave := range correcciones {
+		cambios["correcciones/"+clave] = nil
+	}
+	avisosAntes := 0
+	for clave, av := range avisos {
+		if avisosRecalculables[av.IDTipo] {
+			cambios["avisos/"+clave] = nil
+			avisosAntes++
+		}
+	}
+
+	for i, res := range r.resumenes {
+		clave := claveDeterminista(res.Timestamp, fmt.Sprintf("%s/resumen/%d/%d", oficina, res.Timestamp, i))
+		cambios["resumenes/"+clave] = res
+	}
+	despues := sumarVentanas(previo, r.resumenes)
+	if conAvisos {
+		for i, av := range r.avisos {
+			clave := claveDeterminista(av.Timestamp, fmt.Sprintf("%s/aviso/%d/%d", oficina, av.Timestamp, i))
+			cambios["avisos/"+clave] = av
+		}
+	}
+
+	ultimo := previo
+	if n := len(r.resumenes); n > 0 {
+		ultimo = &r.resumenes[n-1]
+	}
+	// Los posteriores seguían al último resumen viejo del rango.
+	anterior := previo
+	if n := len(viejos); n > 0 {
+		anterior = &viejos[n-1]
+	}
+	reacumulados := reacumular(ultimo, anterior, posteriores)
+
+	fmt.Printf("  %s: %d lecturas, %d resúmenes (antes %d), %.2f kWh (antes %.2f), monto %.2f (antes %.2f), %d avisos",
+		oficina, r.lecturas, len(r.resumenes), len(resumenes),
+		math.Round(despues.consumo*100)/100, math.Round(antes.consumo*100)/100,
+		math.Round(despues.monto*100)/100, math.Round(antes.monto*100)/100, len(r.avisos))
+	if conAvisos {
+		fmt.Printf(" (antes %d)", avisosAntes)
+	}
+	if len(correcciones) > 0 {
+		fmt.Printf(", %d correcciones incorporadas", len(correcciones))
+	}
+	if len(posteriores) > 0 {
+		fmt.Printf(", %d resúmenes posteriores reacumulados", len(posteriores))
+	}
+	fmt.Println()
+	if simular {
+		return nil
+	}
+
+	lote := make(map[string]interface{}, maxRutasBackfill)
+	for ruta, valor := range cambios {
+		lote[ruta] = valor
+		if len(lote) == maxRutasBackfill {
+			if err := actualizarBackfill(ctx, base, oficina, lote); err != nil {
+				return err
+			}
+			lote = make(map[string]interface{}, maxRutasBackfill)
+		}
+	}
+	if len(lote) > 0 {
+		if err := actualizarBackfill(ctx, base, oficina, lote); err != nil {
+			return err
+		}
+	}
+	// Los posteriores van al final: si algo falla antes, repetir el backfill
+	// los vuelve a calcular desde el rango ya reemplazado.
+	lote = make(map[string]interface{}, maxRutasBackfill)
+	for ruta, valor := range reacumulados {
+		lote[ruta] = valor
+		if len(lote) == maxRutasBackfill {
+			if err := actualizarBackfill(ctx, base, oficina, lote); err != nil {
+				return err
+			}
+			lote = make(map[string]interface{}, maxRutasBackfill)
+		}
+	}
+	if len(lote) > 0 {
+		return actualizarBackfill(ctx, base, oficina, lote)
+	}
+	return nil
+}
+
+// reacumular recalcula los acumulados de los resúmenes posteriores al rango
+// para que continúen los del último recalculado, sumando a cada uno los
+// valores propios de los anteriores. anterior es el resumen que precedía a
+// los posteriores antes del backfill: con él, el valor propio de cada uno
+// sale de la diferencia de sus acumulados viejos, sin el redondeo de cada
+// ventana. Devuelve las rutas que cambian.
+func reacumular(ultimo, anterior *Resumen, posteriores map[string]Resumen) map[string]interface{} {
+	claves := make([]string, 0, len(posteriores))
+	for clave := range posteriores {
+		claves = append(claves, clave)
+	}
+	ordenarClaves(claves, posteriores)
+	lista := make([]Resumen, len(claves))
+	for i, clave := range claves {
+		lista[i] = posteriores[clave]
+	}
+	valores := ventanasDesde(anterior, lista)
+
+	var consumo, monto, emisiones, evitadas float64
+	if ultimo != nil {
+		consumo, monto = ultimo.ConsumoTotalKvh, ultimo.MontoTotal
+		emisiones, evitadas = ultimo.EmisionesTotalKg, ultimo.EmisionesEvitadasTotalKg
+	}
+	cambios := make(map[string]interface{})
+	for i, clave := range claves {
+		consumo += valores[i].consumo
+		monto += valores[i].monto
+		emisiones += valores[i].emisiones
+		evitadas += valores[i].evitadas
+		ruta := "resumenes/" + clave + "/"
+		cambios[ruta+"consumo_total_kvh"] = math.Round(consumo*100) / 100
+		cambios[ruta+"monto_total"] = math.Round(monto*100) / 100
+		cambios[ruta+"emisiones_total_kg"] = math.Round(emisiones*1000) / 1000
+		cambios[ruta+"emisiones_evitadas_total_kg"] = math.Round(evitadas*1000) / 1000
+	}
+	return cambios
+}
+
+// ordenarClaves ordena las claves de los resúmenes por timestamp.
+func ordenarClaves(claves []string, resumenes map[string]Resumen) {
+	sort.Slice(claves, func(i, j int) bool {
+		a, b := resumenes[claves[i]], resumenes[claves[j]]
+		if a.Timestamp != b.Timestamp {
+			return a.Timestamp < b.Timestamp
+		}
+		return claves[i] < claves[j]
+	})
+}
+
+func ordenarResumenes(resumenes map[string]Resumen) []Resumen {
+	claves := make([]string, 0, len(resumenes))
+	for clave := range resumenes {
+		claves = append(claves, clave)
+	}
+	ordenarClaves(claves, resumenes)
+	lista := make([]Resumen, len(claves))
+	for i, clave := range claves {
+		lista[i] = resumenes[clave]
+	}
+	return lista
+}
+
+// ventanasDesde devuelve los valores propios de los resúmenes, ordenados,
+// que siguen a previo.
+func ventanasDesde(previo *Resumen, resumenes []Resumen) []valoresVentana {
+	if previo == nil {
+		return valoresVentanas(resumenes)
+	}
+	return valoresVentanas(append([]Resumen{*previo}, resumenes...))[1:]
+}
+
+func sumarVentanas(previo *Resumen, resumenes []Resumen) valoresVentana {
+	var suma valoresVentana
+	for _, v := range ventanasDesde(previo, resumenes) {
+		suma.consumo += v.consumo
+		suma.monto += v.monto
+		suma.emisiones += v.emisiones
+		suma.evitadas += v.evitadas
+	}
+	return suma
+}
+
+func actualizarBackfill(ctx context.Context, base *db.Ref, oficina string, lote map[string]interface{}) error {
+	if err := medirFirebase("backfill", func() error { return base.Update(ctx, lote) }); err != nil {
+		return fmt.Errorf("error reescribiendo %s (se puede repetir el backfill): %v", oficina, err)
+	}
+	return nil
+}
+
+// leerRango lee los hijos con timestamp en [desde, hasta).
+func leerRango(ctx context.Context, ref *db.Ref, desde, hasta int64, v interface{}) error {
+	return ref.OrderByChild("timestamp").StartAt(desde).EndAt(hasta-1).Get(ctx, v)
+}
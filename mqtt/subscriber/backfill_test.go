@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReacumular(t *testing.T) {
+	posteriores := map[string]Resumen{
+		"b": {Timestamp: 200, ConsumoKvh: 0.5, MontoEstimado: 0.1, EmisionesKg: 0.25, EmisionesEvitadasKg: -0.01, ConsumoTotalKvh: 99},
+		"a": {Timestamp: 100, ConsumoKvh: 1, MontoEstimado: 0.2, EmisionesKg: 0.5, EmisionesEvitadasKg: 0.1, ConsumoTotalKvh: 98},
+	}
+	casos := []struct {
+		nombre   string
+		ultimo   *Resumen
+		anterior *Resumen
+		quiere   map[string]interface{}
+	}{
+		{
+			nombre: "continúa el último recalculado",
+			ultimo: &Resumen{ConsumoTotalKvh: 10, MontoTotal: 2, EmisionesTotalKg: 5, EmisionesEvitadasTotalKg: 1},
+			quiere: map[string]interface{}{
+				"resumenes/a/consumo_total_kvh":           11.0,
+				"resumenes/a/monto_total":                 2.2,
+				"resumenes/a/emisiones_total_kg":          5.5,
+				"resumenes/a/emisiones_evitadas_total_kg": 1.1,
+				"resumenes/b/consumo_total_kvh":           11.5,
+				"resumenes/b/monto_total":                 2.3,
+				"resumenes/b/emisiones_total_kg":          5.75,
+				"resumenes/b/emisiones_evitadas_total_kg": 1.09,
+			},
+		},
+		{
+			// Los acumulados viejos de a y b no coinciden con sus valores
+			// propios: no se usan sus diferencias.
+			nombre:   "acumulados viejos que no coinciden",
+			ultimo:   &Resumen{ConsumoTotalKvh: 10, MontoTotal: 2, EmisionesTotalKg: 5, EmisionesEvitadasTotalKg: 1},
+			anterior: &Resumen{ConsumoTotalKvh: 50},
+			quiere: map[string]interface{}{
+				"resumenes/a/consumo_total_kvh":           11.0,
+				"resumenes/a/monto_total":                 2.2,
+				"resumenes/a/emisiones_total_kg":          5.5,
+				"resumenes/a/emisiones_evitadas_total_kg": 1.1,
+				"resumenes/b/consumo_total_kvh":           11.5,
+				"resumenes/b/monto_total":                 2.3,
+				"resumenes/b/emisiones_total_kg":          5.75,
+				"resumenes/b/emisiones_evitadas_total_kg": 1.09,
+			},
+		},
+		{
+			nombre: "sin resumen anterior empieza de cero",
+			quiere: map[string]interface{}{
+				"resumenes/a/consumo_total_kvh":           1.0,
+				"resumenes/a/monto_total":                 0.2,
+				"resumenes/a/emisiones_total_kg":          0.5,
+				"resumenes/a/emisiones_evitadas_total_kg": 0.1,
+				"resumenes/b/consumo_total_kvh":           1.5,
+				"resumenes/b/monto_total":                 0.3,
+				"resumenes/b/emisiones_total_kg":          0.75,
+				"resumenes/b/emisiones_evitadas_total_kg": 0.09,
+			},
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if got := reacumular(c.ultimo, c.anterior, posteriores); !reflect.DeepEqual(got, c.quiere) {
+				t.Errorf("reacumular = %v; se esperaba %v", got, c.quiere)
+			}
+		})
+	}
+}
+
+func TestReacumularSinRedondeo(t *testing.T) {
+	// Una hora de ventanas de un minuto de 0,0036 kWh: cada consumo_kvh
+	// redondeado es 0, pero sus acumulados viejos suben 0,216 kWh.
+	anterior := &Resumen{Timestamp: 0, ConsumoTotalKvh: 5}
+	posteriores := make(map[string]Resumen)
+	for i, r := range resumenesDePrueba(time.Unix(60, 0), time.Minute, 60, 0.0036) {
+		r.ConsumoTotalKvh = math.Round((5+0.0036*float64(i+1))*100) / 100
+		posteriores[fmt.Sprintf("r%02d", i)] = r
+	}
+	cambios := reacumular(&Resumen{ConsumoTotalKvh: 7.5}, anterior, posteriores)
+	if total := cambios["resumenes/r59/consumo_total_kvh"].(float64); math.Abs(total-7.716) > 0.011 {
+		t.Errorf("acumulado del último posterior: %v; se esperaba 7,716", total)
+	}
+}
+
+// TestReemplazarOficinaHasta recalcula un rango con resúmenes posteriores,
+// el primero justo en hasta, y revisa que sus acumulados sigan a los del
+// rango recalculado.
+func TestReemplazarOficinaHasta(t *testing.T) {
+	anteriorCfg := cfg
+	cfg = cfgPorDefecto
+	t.Cleanup(func() { cfg = anteriorCfg })
+	salida := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	t.Cleanup(func() { os.Stdout = salida })
+
+	desde := time.Date(2025, 12, 1, 10, 0, 0, 0, time.Local).Unix()
+	hasta := desde + 600
+	previo := Resumen{Timestamp: desde - 60, ConsumoKvh: 0.05, ConsumoTotalKvh: 10, MontoTotal: 2.5}
+	resumenes := map[string]Resumen{
+		"previo": previo,
+		// Resumen viejo del rango, al que seguían los posteriores.
+		"viejo": {Timestamp: desde + 300, ConsumoKvh: 0.05, ConsumoTotalKvh: 10.05, MontoTotal: 2.51},
+	}
+	// Posteriores de 0,0036 kWh, del primero en hasta en adelante.
+	for i, r := range resumenesDePrueba(time.Unix(hasta, 0), time.Minute, 30, 0.0036) {
+		r.ConsumoTotalKvh = math.Round((10.05+0.0036*float64(i+1))*100) / 100
+		r.MontoTotal = math.Round((2.51+0.0009*float64(i+1))*100) / 100
+		resumenes[fmt.Sprintf("posterior%02d", i)] = r
+	}
+	datos, _ := json.Marshal(map[string]interface{}{"monitoreo_consumo": map[string]interface{}{
+		"oficinas": map[string]interface{}{"A": map[string]interface{}{"resumenes": resumenes}},
+	}})
+	f := usarFirebaseDePrueba(t, string(datos))
+
+	// 10 A a 220 V durante el rango: 0,0061 kWh por lectura de 10 s.
+	var lecturas []DatosSensor
+	for ts := desde; ts < hasta; ts += int64(segundosPorLectura()) {
+		lecturas = append(lecturas, DatosSensor{Oficina: "A", Timestamp: ts, CorrienteA: 10, Temperatura: 22})
+	}
+	r := recalcularOficina(lecturas, &previo, paramsPorDefecto, hasta)
+	base := clienteFirebase.NewRef(rutaFirebase("oficinas/A"))
+	if err := reemplazarOficina(context.Background(), base, "A", r, &previo, desde, hasta, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var guardados map[string]Resumen
+	f.leer(t, "monitoreo_consumo/oficinas/A/resumenes", &guardados)
+	if _, existe := guardados["viejo"]; existe {
+		t.Error("quedó el resumen viejo del rango")
+	}
+	if guardados["previo"] != previo {
+		t.Errorf("cambió el resumen previo: %+v", guardados["previo"])
+	}
+	recalculado := r.resumenes[len(r.resumenes)-1]
+	if recalculado.Timestamp >= hasta || math.Abs(recalculado.ConsumoTotalKvh-10.37) > 0.011 {
+		t.Fatalf("último recalculado: %+v; se esperaban 10,37 kWh antes de hasta", recalculado)
+	}
+	for i := 0; i < 30; i++ {
+		p := guardados[fmt.Sprintf("posterior%02d", i)]
+		consumo := recalculado.ConsumoTotalKvh + 0.0036*float64(i+1)
+		monto := recalculado.MontoTotal + 0.0009*float64(i+1)
+		if math.Abs(p.ConsumoTotalKvh-consumo) > 0.011 || math.Abs(p.MontoTotal-monto) > 0.011 {
+			t.Errorf("posterior %d en %d: %v kWh y %v; se esperaban %.4f y %.4f", i, p.Timestamp, p.ConsumoTotalKvh, p.MontoTotal, consumo, monto)
+		}
+	}
+	if p := guardados["posterior00"]; p.Timestamp != hasta || p.ConsumoKvh != 0 {
+		t.Errorf("primer posterior: %+v; sus valores propios no deben cambiar", p)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+// comandoBench mide la ingesta con muchas oficinas simulando la latencia de
+// Firebase, sin broker ni base de datos:
+//
+//	go run . bench -oficinas 5000 -lecturas 20 -latencia 5ms
+func comandoBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	numOficinas := fs.Int("oficinas", 2000, "oficinas simuladas")
+	lecturas := fs.Int("lecturas", 20, "lecturas por oficina")
+	latencia := fs.Duration("latencia", 2*time.Millisecond, "latencia simulada de cada llamada a Firebase")
+	cola := fs.Int("cola", cfg.ColaOficina, "lecturas en espera por oficina")
+	retraso := fs.Duration("retraso", time.Duration(cfg.RetrasoMax), "espera por lecturas fuera de orden")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *numOficinas <= 0 || *lecturas <= 0 {
+		return fmt.Errorf("oficinas y lecturas deben ser positivas")
+	}
+
+	mu.Lock()
+	config = paramsPorDefecto
+	tiposAvisos = map[string]TipoAviso{avisoSensorNoResponde: {Motivo: "Sensor no responde"}}
+	mu.Unlock()
+
+	var escrituras, llamadas atomic.Int64
+	c := cfg
+	c.ColaOficina = *cola
+	c.RetrasoMax = configuracion.Duracion(*retraso)
+	ingesta = nuevaIngesta(c, func(_ context.Context, lote []escritura) []error {
+		llamadas.Add(1)
+		escrituras.Add(int64(len(lote)))
+		time.Sleep(*latencia)
+		return make([]error, len(lote))
+	})
+	ingesta.iniciar(context.Background())
+
+	// Los logs por resumen y por aviso falsearían la medición.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ids := make([]string, *numOficinas)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("bench-%05d", i)
+	}
+
+	// Los timestamps avanzan un intervalo por ronda, como con sensores reales,
+	// para que se cierren ventanas y se liberen las lecturas retenidas.
+	intervalo := int64(segundosPorLectura())
+	base := time.Now().Unix() - int64(*lecturas)*intervalo
+
+	var encoladas, descartadas int
+	var maxEncolado time.Duration
+	inicio := time.Now()
+	for r := 0; r < *lecturas; r++ {
+		for _, oficina := range ids {
+			datos := DatosSensor{
+				Oficina:     oficina,
+				Timestamp:   base + int64(r)*intervalo,
+				Secuencia:   uint64(r + 1),
+				Presencia:   rand.Intn(2) == 0,
+				CorrienteA:  rand.Float64() * 25,
+				Temperatura: 18 + rand.Float64()*12,
+			}
+			t := time.Now()
+			if ingesta.Encolar(datos) {
+				encoladas++
+			} else {
+				descartadas++
+			}
+			if d := time.Since(t); d > maxEncolado {
+				maxEncolado = d
+			}
+		}
+	}
+	recepcion := time.Since(inicio)
+
+	ctx, cancelar := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelar()
+	ingesta.cerrar(ctx)
+	total := time.Since(inicio)
+
+	enviadas := *numOficinas * *lecturas
+	fmt.Printf("📊 Benchmark de ingesta: %d oficinas × %d lecturas, latencia %s\n", *numOficinas, *lecturas, *latencia)
+	fmt.Printf("   Recepción:    %s (%.0f lecturas/s, máximo por lectura %s)\n",
+		recepcion, float64(enviadas)/recepcion.Seconds(), maxEncolado)
+	fmt.Printf("   Encoladas:    %d, descartadas: %d\n", encoladas, descartadas)
+	fmt.Printf("   Procesamiento completo: %s (%.0f lecturas/s)\n", total, float64(encoladas)/total.Seconds())
+	fmt.Printf("   Escrituras:   %d en %d llamadas\n", escrituras.Load(), llamadas.Load())
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Alfabeto de las claves de Firebase, ordenado por ASCII.
+const caracteresPush = "-0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz"
+
+var (
+	muPush         sync.Mutex
+	ultimoPush     int64
+	aleatoriosPush [12]int
+)
+
+// clavePush genera una clave como las de Push sin ir a la red: 8 caracteres
+// con el instante en milisegundos y 12 aleatorios. Dentro del mismo
+// milisegundo incrementa la parte aleatoria para mantener el orden.
+func clavePush() string {
+	muPush.Lock()
+	defer muPush.Unlock()
+
+	ahora := time.Now().UnixMilli()
+	if ahora == ultimoPush {
+		i := len(aleatoriosPush) - 1
+		for ; i >= 0 && aleatoriosPush[i] == len(caracteresPush)-1; i-- {
+			aleatoriosPush[i] = 0
+		}
+		if i >= 0 {
+			aleatoriosPush[i]++
+		}
+	} else {
+		for i := range aleatoriosPush {
+			aleatoriosPush[i] = rand.Intn(len(caracteresPush))
+		}
+	}
+	ultimoPush = ahora
+
+	return armarClavePush(ahora, aleatoriosPush)
+}
+
+// claveDeterminista genera una clave con el formato de Push para el instante
+// (en segundos), cuya parte aleatoria sale de la semilla: la misma semilla da
+// siempre la misma clave, así reescribir un dato lo reemplaza.
+func claveDeterminista(instante int64, semilla string) string {
+	suma := sha256.Sum256([]byte(semilla))
+	var partes [12]int
+	for i := range partes {
+		partes[i] = int(suma[i]) % len(caracteresPush)
+	}
+	return armarClavePush(instante*1000, partes)
+}
+
+func armarClavePush(milisegundos int64, aleatorios [12]int) string {
+	var clave [20]byte
+	for i := 7; i >= 0; i-- {
+		clave[i] = caracteresPush[milisegundos%int64(len(caracteresPush))]
+		milisegundos /= int64(len(caracteresPush))
+	}
+	for i, n := range aleatorios {
+		clave[8+i] = caracteresPush[n]
+	}
+	return string(clave[:])
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestClavePushOrdenada(t *testing.T) {
+	// Muchas claves caen en el mismo milisegundo: deben seguir siendo
+	// distintas y ordenarse como se generaron.
+	claves := make([]string, 5000)
+	for i := range claves {
+		claves[i] = clavePush()
+	}
+	if !sort.StringsAreSorted(claves) {
+		t.Fatal("las claves no están en orden de generación")
+	}
+	vistas := make(map[string]bool, len(claves))
+	for _, c := range claves {
+		if len(c) != 20 {
+			t.Fatalf("clave de %d caracteres: %q", len(c), c)
+		}
+		for _, r := range c {
+			if !strings.ContainsRune(caracteresPush, r) {
+				t.Fatalf("carácter inválido %q en %q", r, c)
+			}
+		}
+		if vistas[c] {
+			t.Fatalf("clave repetida: %q", c)
+		}
+		vistas[c] = true
+	}
+}
+
+func TestClaveDeterminista(t *testing.T) {
+	casos := []struct {
+		nombre        string
+		instanteA     int64
+		semillaA      string
+		instanteB     int64
+		semillaB      string
+		iguales       bool
+		aAntesQueB    bool
+		compararOrden bool
+	}{
+		{"misma semilla e instante", 1700000000, "A/123", 1700000000, "A/123", true, false, false},
+		{"otra semilla", 1700000000, "A/123", 1700000000, "B/123", false, false, false},
+		{"instante anterior ordena antes", 1700000000, "Z", 1700000060, "A", false, true, true},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			a := claveDeterminista(c.instanteA, c.semillaA)
+			b := claveDeterminista(c.instanteB, c.semillaB)
+			if (a == b) != c.iguales {
+				t.Errorf("%q == %q: %v; se esperaba %v", a, b, a == b, c.iguales)
+			}
+			if c.compararOrden && (a < b) != c.aAntesQueB {
+				t.Errorf("%q < %q: %v; se esperaba %v", a, b, a < b, c.aAntesQueB)
+			}
+		})
+	}
+}
+
+func TestArmarClavePushTiempo(t *testing.T) {
+	// Las claves de Firebase comparan el instante en sus 8 primeros
+	// caracteres.
+	var aleatorios [12]int
+	anterior := armarClavePush(0, aleatorios)
+	for _, ms := range []int64{1, 63, 64, 1700000000000, 1700000000001} {
+		clave := armarClavePush(ms, aleatorios)
+		if clave[:8] <= anterior[:8] {
+			t.Errorf("armarClavePush(%d) = %q no es posterior a %q", ms, clave, anterior)
+		}
+		anterior = clave
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+// ejecutarComando atiende los subcomandos del subscriber, que van después de
+// las opciones de configuración: subscriber -config x.json bench -oficinas 5000
+func ejecutarComando(args []string) error {
+	switch args[0] {
+	case "bench":
+		return comandoBench(args[1:])
+	case "deadletter":
+		return comandoDeadLetter(args[1:])
+	case "backfill":
+		return comandoBackfill(args[1:])
+	case "informe":
+		return comandoInforme(args[1:])
+	case "notificaciones":
+		return comandoNotificaciones(args[1:])
+	case "escalamiento":
+		return comandoEscalamiento(args[1:])
+	}
+	return fmt.Errorf("comando desconocido: %s", args[0])
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// AvisoOficina es un aviso junto con la oficina que lo generó.
+type AvisoOficina struct {
+	Oficina string `json:"oficina"`
+	Aviso
+}
+
+// EstadoSnapshot es una copia de solo lectura de EstadoOficina.
+type EstadoSnapshot struct {
+	Oficina               string  `json:"oficina"`
+	UltimaLectura         int64   `json:"ultima_lectura"`
+	UltimoResumen         int64   `json:"ultimo_resumen"`
+	InicioVentana         int64   `json:"inicio_ventana"`
+	LecturasPendientes    int     `json:"lecturas_pendientes"`
+	TiempoPresente        int     `json:"tiempo_presente"`
+	LuzEncendida          bool    `json:"luz_encendida"`
+	AireEncendido         bool    `json:"aire_encendido"`
+	SinCorrienteDesde     *int64  `json:"sin_corriente_desde,omitempty"`
+	SensorFueraDeServicio bool    `json:"sensor_fuera_de_servicio"`
+	ConsumoElevado        bool    `json:"consumo_elevado"`
+	DesfaseReloj          int64   `json:"desfase_reloj"`
+	RelojDesfasado        bool    `json:"reloj_desfasado"`
+	EmisionesTotalKg      float64 `json:"emisiones_total_kg"`
+	EmisionesEvitadasKg   float64 `json:"emisiones_evitadas_kg"`
+}
+
+func (e *EstadoOficina) snapshot(oficina string) EstadoSnapshot {
+	e.Mutex.Lock()
+	defer e.Mutex.Unlock()
+
+	s := EstadoSnapshot{
+		Oficina:               oficina,
+		UltimaLectura:         e.UltimaLectura,
+		UltimoResumen:         e.UltimoResumen,
+		InicioVentana:         e.InicioVentana,
+		LecturasPendientes:    len(e.Corrientes),
+		TiempoPresente:        e.TiempoPresente,
+		LuzEncendida:          e.LuzEncendida,
+		AireEncendido:         e.AireEncendido,
+		SensorFueraDeServicio: e.SensorFueraDeServicio,
+		ConsumoElevado:        e.ConsumoElevado,
+		DesfaseReloj:          e.DesfaseReloj,
+		RelojDesfasado:        e.RelojDesfasado,
+		EmisionesTotalKg:      e.EmisionesTotalKg,
+		EmisionesEvitadasKg:   e.EmisionesEvitadasKg,
+	}
+	if e.SinCorrienteDesde != nil {
+		desde := *e.SinCorrienteDesde
+		s.SinCorrienteDesde = &desde
+	}
+	return s
+}
+
+func listarOficinas() []string {
+	mu.RLock()
+	lista := make([]string, len(oficinas))
+	copy(lista, oficinas)
+	mu.RUnlock()
+	sort.Strings(lista)
+	return lista
+}
+
+func snapshotsEstados() []EstadoSnapshot {
+	mu.RLock()
+	estados := make(map[string]*EstadoOficina, len(mapaEstados))
+	for oficina, est := range mapaEstados {
+		estados[oficina] = est
+	}
+	mu.RUnlock()
+
+	snapshots := make([]EstadoSnapshot, 0, len(estados))
+	for oficina, est := range estados {
+		snapshots = append(snapshots, est.snapshot(oficina))
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Oficina < snapshots[j].Oficina
+	})
+	return snapshots
+}
+
+// leerResumenes devuelve los resúmenes de la oficina con timestamp dentro de
+// [desde, hasta], ordenados. Requiere ".indexOn": ["timestamp"] en las reglas.
+func leerResumenes(ctx context.Context, oficina string, desde, hasta int64) ([]Resumen, error) {
+	var datos map[string]Resumen
+	ref := clienteFirebase.NewRef(rutaFirebase("oficinas/%s/resumenes", oficina))
+	if err := ref.OrderByChild("timestamp").StartAt(desde).EndAt(hasta).Get(ctx, &datos); err != nil {
+		return nil, fmt.Errorf("error leyendo resúmenes de %s: %v", oficina, err)
+	}
+
+	resumenes := make([]Resumen, 0, len(datos))
+	for _, r := range datos {
+		resumenes = append(resumenes, r)
+	}
+	sort.Slice(resumenes, func(i, j int) bool {
+		return resumenes[i].Timestamp < resumenes[j].Timestamp
+	})
+	return resumenes, nil
+}
+
+func leerAvisos(ctx context.Context, oficina string, desde, hasta int64) ([]AvisoOficina, error) {
+	var datos map[string]Aviso
+	ref := clienteFirebase.NewRef(rutaFirebase("oficinas/%s/avisos", oficina))
+	if err := ref.OrderByChild("timestamp").StartAt(desde).EndAt(hasta).Get(ctx, &datos); err != nil {
+		return nil, fmt.Errorf("error leyendo avisos de %s: %v", oficina, err)
+	}
+
+	avisos := make([]AvisoOficina, 0, len(datos))
+	for _, a := range datos {
+		avisos = append(avisos, AvisoOficina{Oficina: oficina, Aviso: a})
+	}
+	return avisos, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"monitoreo_consumo/internal/mqttcliente"
+)
+
+const (
+	maxLineaDeadLetter = 1 << 20
+	// Espera por la confirmación del broker de cada mensaje reenviado.
+	esperaReenvio = 5 * time.Second
+)
+
+// MensajeDeadLetter es un mensaje MQTT que no se pudo decodificar. Payload
+// se guarda tal como llegó (en base64 dentro del JSON) para reenviarlo.
+type MensajeDeadLetter struct {
+	Topico   string `json:"topico"`
+	Error    string `json:"error"`
+	Recibida int64  `json:"recibida"`
+	Payload  []byte `json:"payload"`
+}
+
+func (m MensajeDeadLetter) oficina() string {
+	return mqttcliente.OficinaDelTopico(m.Topico)
+}
+
+// topicoDeadLetter es oficinas/<id>/deadletter, en el espacio del
+// inquilino, para un tópico de sensores.
+func topicoDeadLetter(topico string) string {
+	oficina := mqttcliente.OficinaDelTopico(topico)
+	if oficina == "" {
+		oficina = "desconocida"
+	}
+	return mqttcliente.Topico(cfg.Inquilino, oficina, "deadletter")
+}
+
+var muDeadLetter sync.Mutex
+
+// guardarDeadLetter agrega el mensaje al archivo JSONL. El archivo se abre
+// en cada escritura para que reenviar pueda renombrarlo mientras el
+// subscriber sigue en marcha.
+func guardarDeadLetter(ruta string, m MensajeDeadLetter) error {
+	linea, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	muDeadLetter.Lock()
+	defer muDeadLetter.Unlock()
+	if err := os.MkdirAll(filepath.Dir(ruta), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ruta, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(linea, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func leerDeadLetter(ruta string) ([]MensajeDeadLetter, error) {
+	f, err := os.Open(ruta)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mensajes []MensajeDeadLetter
+	lector := bufio.NewScanner(f)
+	lector.Buffer(make([]byte, 64*1024), maxLineaDeadLetter)
+	for n := 1; lector.Scan(); n++ {
+		var m MensajeDeadLetter
+		if err := json.Unmarshal(lector.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", ruta, n, err)
+		}
+		mensajes = append(mensajes, m)
+	}
+	return mensajes, lector.Err()
+}
+
+// enviarADeadLetter publica el mensaje fallido en oficinas/<id>/deadletter y
+// lo guarda en el archivo local. No espera la confirmación del broker
+// porque corre dentro del callback de paho.
+func enviarADeadLetter(cliente mqtt.Client, topico string, payload []byte, recibida int64, causa error) {
+	metricaDeadLetter.Inc()
+	m := MensajeDeadLetter{
+		Topico:   topico,
+		Error:    causa.Error(),
+		Recibida: recibida,
+		Payload:  payload,
+	}
+	log.Printf("📭 Mensaje inválido en %s enviado a dead-letter: %v", topico, causa)
+
+	if cfg.DeadLetter != "" {
+		if err := guardarDeadLetter(cfg.DeadLetter, m); err != nil {
+			log.Printf("❌ Error guardando dead-letter: %v", err)
+		}
+	}
+	if cliente != nil {
+		datos, _ := json.Marshal(m)
+		token := cliente.Publish(topicoDeadLetter(topico), mqttcliente.QoS, false, datos)
+		go func() {
+			if token.Wait() && token.Error() != nil {
+				log.Printf("❌ Error publicando dead-letter: %v", token.Error())
+			}
+		}()
+	}
+}
+
+// filtroDeadLetter selecciona mensajes por oficina y por hora de llegada.
+type filtroDeadLetter struct {
+	oficina      string
+	desde, hasta int64
+}
+
+func (f *filtroDeadLetter) registrar(fs *flag.FlagSet) (desde, hasta *string) {
+	fs.StringVar(&f.oficina, "oficina", "", "solo los mensajes de esta oficina")
+	return fs.String("desde", "", "recibidos desde (unix, RFC3339 o AAAA-MM-DD)"),
+		fs.String("hasta", "", "recibidos hasta (unix, RFC3339 o AAAA-MM-DD)")
+}
+
+func (f *filtroDeadLetter) parsear(desde, hasta string) (err error) {
+	if f.desde, err = parsearInstante(desde, 0); err != nil {
+		return err
+	}
+	f.hasta, err = parsearInstante(hasta, 1<<62)
+	return err
+}
+
+func (f filtroDeadLetter) incluye(m MensajeDeadLetter) bool {
+	if f.oficina != "" && m.oficina() != f.oficina {
+		return false
+	}
+	return m.Recibida >= f.desde && m.Recibida <= f.hasta
+}
+
+// comandoDeadLetter atiende "deadletter listar" y "deadletter reenviar".
+func comandoDeadLetter(args []string) error {
+	if cfg.DeadLetter == "" {
+		return errors.New("dead_letter no está configurado")
+	}
+	if len(args) == 0 {
+		return errors.New("uso: deadletter listar|reenviar [-oficina X] [-desde T] [-hasta T]")
+	}
+
+	fs := flag.NewFlagSet("deadletter "+args[0], flag.ContinueOnError)
+	var filtro filtroDeadLetter
+	desde, hasta := filtro.registrar(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := filtro.parsear(*desde, *hasta); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "listar":
+		return listarDeadLetter(filtro)
+	case "reenviar":
+		return reenviarDeadLetter(filtro)
+	}
+	return fmt.Errorf("subcomando desconocido: deadletter %s", args[0])
+}
+
+func listarDeadLetter(filtro filtroDeadLetter) error {
+	mensajes, err := leerDeadLetter(cfg.DeadLetter)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, m := range mensajes {
+		if !filtro.incluye(m) {
+			continue
+		}
+		n++
+		payload := string(m.Payload)
+		if len(payload) > 200 {
+			payload = payload[:200] + "..."
+		}
+		fmt.Printf("%s  %-28s %s\n    payload: %q\n",
+			time.Unix(m.Recibida, 0).Format("2006-01-02 15:04:05"), m.Topico, m.Error, payload)
+	}
+	fmt.Printf("%d mensajes (de %d en %s)\n", n, len(mensajes), cfg.DeadLetter)
+	return nil
+}
+
+// reenviarDeadLetter vuelve a publicar los mensajes en su tópico original,
+// para que el subscriber corregido los procese. El archivo se renombra antes
+// de leerlo, así lo que llegue mientras tanto va a uno nuevo; lo que no se
+// reenvía (filtrado o fallido) se vuelve a agregar.
+func reenviarDeadLetter(filtro filtroDeadLetter) error {
+	opciones, err := mqttcliente.Opciones(cfg, nil)
+	if err != nil {
+		return err
+	}
+	opciones.SetClientID(cfg.ClienteID + "-reenvio").
+		SetCleanSession(true).
+		SetConnectRetry(false).
+		SetStore(mqtt.NewMemoryStore())
+	cliente := mqtt.NewClient(opciones)
+	token := cliente.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("tiempo agotado conectando al broker %s", cfg.Broker)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("no se pudo conectar al broker: %v", token.Error())
+	}
+	defer cliente.Disconnect(250)
+
+	enProceso := fmt.Sprintf("%s.%d.reenviando", cfg.DeadLetter, time.Now().Unix())
+	if err := os.Rename(cfg.DeadLetter, enProceso); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Println("No hay mensajes en dead-letter")
+			return nil
+		}
+		return err
+	}
+	mensajes, err := leerDeadLetter(enProceso)
+	if err != nil {
+		return fmt.Errorf("%v (el archivo quedó en %s)", err, enProceso)
+	}
+
+	reenviados, fallidos, err := reenviarMensajes(cliente, mensajes, filtro, cfg.DeadLetter)
+	if err != nil {
+		return fmt.Errorf("%v (el archivo quedó en %s)", err, enProceso)
+	}
+	if err := os.Remove(enProceso); err != nil {
+		return err
+	}
+	fmt.Printf("📤 %d mensajes reenviados, %d fallidos, %d conservados\n",
+		reenviados, fallidos, len(mensajes)-reenviados)
+	return nil
+}
+
+// reenviarMensajes publica los mensajes que pasan el filtro y vuelve a
+// guardar en destino los demás y los que no se pudieron reenviar.
+func reenviarMensajes(cliente mqtt.Client, mensajes []MensajeDeadLetter, filtro filtroDeadLetter, destino string) (reenviados, fallidos int, err error) {
+	for _, m := range mensajes {
+		if filtro.incluye(m) {
+			err := reenviarMensaje(cliente, m)
+			if err == nil {
+				reenviados++
+				continue
+			}
+			fallidos++
+			log.Printf("❌ Error reenviando a %s: %v", m.Topico, err)
+		}
+		if err := guardarDeadLetter(destino, m); err != nil {
+			return reenviados, fallidos, err
+		}
+	}
+	return reenviados, fallidos, nil
+}
+
+// reenviarMensaje publica el mensaje en su tópico original y espera la
+// confirmación del broker.
+func reenviarMensaje(cliente mqtt.Client, m MensajeDeadLetter) error {
+	token := cliente.Publish(m.Topico, mqttcliente.QoS, false, m.Payload)
+	if !token.WaitTimeout(esperaReenvio) {
+		return fmt.Errorf("tiempo agotado esperando la confirmación del broker (%s)", esperaReenvio)
+	}
+	return token.Error()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// tokenDePrueba termina enseguida con err, o nunca si no se confirma.
+type tokenDePrueba struct {
+	confirmado bool
+	err        error
+}
+
+func (t tokenDePrueba) Wait() bool                     { return t.confirmado }
+func (t tokenDePrueba) WaitTimeout(time.Duration) bool { return t.confirmado }
+func (t tokenDePrueba) Error() error                   { return t.err }
+func (t tokenDePrueba) Done() <-chan struct{}          { return make(chan struct{}) }
+
+// clienteDePrueba responde cada Publish con el token de su tópico.
+type clienteDePrueba struct {
+	mqtt.Client
+	tokens     map[string]tokenDePrueba
+	publicados []string
+}
+
+func (c *clienteDePrueba) Publish(topico string, _ byte, _ bool, _ interface{}) mqtt.Token {
+	c.publicados = append(c.publicados, topico)
+	return c.tokens[topico]
+}
+
+func TestReenviarMensajes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	mensajes := []MensajeDeadLetter{
+		{Topico: "oficinas/A/sensores", Recibida: 100, Payload: []byte("a")},
+		{Topico: "oficinas/B/sensores", Recibida: 100, Payload: []byte("b")},
+		{Topico: "oficinas/C/sensores", Recibida: 100, Payload: []byte("c")},
+		// Fuera del filtro por hora de llegada.
+		{Topico: "oficinas/D/sensores", Recibida: 50, Payload: []byte("d")},
+	}
+	cliente := &clienteDePrueba{tokens: map[string]tokenDePrueba{
+		"oficinas/A/sensores": {confirmado: true},
+		"oficinas/B/sensores": {confirmado: false},
+		"oficinas/C/sensores": {confirmado: true, err: errors.New("rechazado")},
+	}}
+	destino := filepath.Join(t.TempDir(), "deadletter.jsonl")
+	filtro := filtroDeadLetter{desde: 100, hasta: 1 << 62}
+
+	reenviados, fallidos, err := reenviarMensajes(cliente, mensajes, filtro, destino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reenviados != 1 || fallidos != 2 {
+		t.Errorf("%d reenviados y %d fallidos; se esperaban 1 y 2", reenviados, fallidos)
+	}
+	if len(cliente.publicados) != 3 {
+		t.Errorf("publicados: %v", cliente.publicados)
+	}
+	conservados, err := leerDeadLetter(destino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var topicos []string
+	for _, m := range conservados {
+		topicos = append(topicos, m.Topico)
+	}
+	if strings.Join(topicos, ",") != "oficinas/B/sensores,oficinas/C/sensores,oficinas/D/sensores" {
+		t.Errorf("conservados: %v", topicos)
+	}
+}
+
+func TestReenviarMensaje(t *testing.T) {
+	casos := []struct {
+		nombre string
+		token  tokenDePrueba
+		error  string
+	}{
+		{"confirmado", tokenDePrueba{confirmado: true}, ""},
+		{"sin confirmación", tokenDePrueba{}, "tiempo agotado"},
+		{"rechazado", tokenDePrueba{confirmado: true, err: errors.New("rechazado")}, "rechazado"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			cliente := &clienteDePrueba{tokens: map[string]tokenDePrueba{"oficinas/A/sensores": c.token}}
+			err := reenviarMensaje(cliente, MensajeDeadLetter{Topico: "oficinas/A/sensores"})
+			if c.error == "" && err != nil {
+				t.Errorf("reenviarMensaje: %v", err)
+			}
+			if c.error != "" && (err == nil || !strings.Contains(err.Error(), c.error)) {
+				t.Errorf("reenviarMensaje: %v; se esperaba un error con %q", err, c.error)
+			}
+		})
+	}
+}
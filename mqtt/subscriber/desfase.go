@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	muestrasDesfase        = 20
+	muestrasMinimasDesfase = 5
+)
+
+// estimadorDesfase estima cuánto difiere el reloj de un sensor del reloj del
+// subscriber. Usa la menor diferencia entre llegada y timestamp de las
+// últimas lecturas: la demora de la red y los reenvíos desde el buffer del
+// publisher solo la agrandan. Como ordenLecturas, es de un solo trabajador.
+type estimadorDesfase struct {
+	muestras [muestrasDesfase]int64
+	n, i     int
+}
+
+// agregar suma una muestra y devuelve el desfase estimado, en segundos
+// (positivo si el sensor atrasa), y si ya hay muestras suficientes.
+func (e *estimadorDesfase) agregar(llegada, timestamp int64) (int64, bool) {
+	e.muestras[e.i] = llegada - timestamp
+	e.i = (e.i + 1) % muestrasDesfase
+	if e.n < muestrasDesfase {
+		e.n++
+	}
+	minimo := e.muestras[0]
+	for _, m := range e.muestras[1:e.n] {
+		if m < minimo {
+			minimo = m
+		}
+	}
+	return minimo, e.n >= muestrasMinimasDesfase
+}
+
+// corregirReloj actualiza el desfase de la oficina y, si supera el máximo y
+// está activado reestampar, reemplaza el timestamp por la hora de llegada.
+func (in *Ingesta) corregirReloj(reloj *estimadorDesfase, datos DatosSensor) DatosSensor {
+	if datos.recibida == 0 {
+		return datos
+	}
+	desfase, fiable := reloj.agregar(datos.recibida, datos.Timestamp)
+	if !fiable {
+		return datos
+	}
+	if registrarDesfase(datos.Oficina, desfase, in.desfaseMax) && in.reestampar {
+		datos.original = datos.Timestamp
+		datos.Timestamp = datos.recibida
+	}
+	return datos
+}
+
+// registrarDesfase guarda el desfase en el estado de la oficina y genera un
+// aviso cuando pasa a superar el máximo. Devuelve si lo supera.
+func registrarDesfase(oficina string, desfase, maximo int64) bool {
+	metricaDesfaseReloj.WithLabelValues(oficina).Set(float64(desfase))
+	fuera := maximo > 0 && (desfase > maximo || desfase < -maximo)
+
+	estado := obtenerEstado(oficina)
+	estado.Mutex.Lock()
+	defer estado.Mutex.Unlock()
+	estado.DesfaseReloj = desfase
+	if fuera && !estado.RelojDesfasado {
+		mu.RLock()
+		hayCatalogo := len(tiposAvisos) > 0
+		mu.RUnlock()
+		if hayCatalogo {
+			av := Aviso{
+				Timestamp: time.Now().Unix(),
+				IDTipo:    avisoRelojDesfasado,
+				Adicional: fmt.Sprintf("Desfase: %d segundos", desfase),
+			}
+			metricaAvisos.WithLabelValues(av.IDTipo).Inc()
+			ingesta.escribir(escrituraAviso(oficina, av))
+		}
+	}
+	estado.RelojDesfasado = fuera
+	return fuera
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestEstimadorDesfase(t *testing.T) {
+	casos := []struct {
+		nombre string
+		// Diferencias llegada - timestamp de cada lectura, en orden.
+		diferencias []int64
+		desfase     int64
+		fiable      bool
+	}{
+		{"pocas muestras", []int64{30, 30, 30}, 30, false},
+		{"sensor atrasado", []int64{32, 30, 31, 35, 30}, 30, true},
+		{"sensor adelantado", []int64{-58, -60, -55, -60, -59}, -60, true},
+		{"la demora de red no suma", []int64{2, 9, 15, 3, 2, 40}, 2, true},
+		// Las muestras viejas salen de la ventana: tras un ajuste del reloj
+		// del sensor, el mínimo pasa a ser el nuevo desfase.
+		{"ventana de muestras", append(repetir(0, muestrasDesfase), repetir(120, muestrasDesfase)...), 120, true},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			var e estimadorDesfase
+			var desfase int64
+			var fiable bool
+			for i, d := range c.diferencias {
+				llegada := int64(1700000000 + 10*i)
+				desfase, fiable = e.agregar(llegada, llegada-d)
+			}
+			if desfase != c.desfase || fiable != c.fiable {
+				t.Errorf("desfase %d fiable %v; se esperaba %d %v", desfase, fiable, c.desfase, c.fiable)
+			}
+		})
+	}
+}
+
+func repetir(v int64, n int) []int64 {
+	r := make([]int64, n)
+	for i := range r {
+		r[i] = v
+	}
+	return r
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+// LimiteEdificio es la corriente máxima de un edificio, sumando la última
+// lectura de sus oficinas. MargenA es cuánto por debajo del límite tiene que
+// quedar para restablecer un aire; conviene que sea al menos lo que consume
+// uno.
+type LimiteEdificio struct {
+	Sitio    string  `json:"sitio"`
+	Edificio string  `json:"edificio"`
+	LimiteA  float64 `json:"limite_a"`
+	MargenA  float64 `json:"margen_a"`
+}
+
+func (l LimiteEdificio) nombre() string {
+	return l.Sitio + " / " + l.Edificio
+}
+
+// ConfigDeslastre es el archivo de la opción deslastre. Las oficinas de
+// menor prioridad (0 si no se indica) apagan su aire primero y lo recuperan
+// al final. Un aire apagado más de Rotacion le cede el turno a otra oficina
+// de igual o menor prioridad; 0 no rota.
+type ConfigDeslastre struct {
+	Edificios     []LimiteEdificio       `json:"edificios"`
+	Prioridades   map[string]int         `json:"prioridades,omitempty"`
+	ApagadoMinimo configuracion.Duracion `json:"apagado_minimo"`
+	Rotacion      configuracion.Duracion `json:"rotacion,omitempty"`
+}
+
+func cargarConfigDeslastre(ruta string) (ConfigDeslastre, error) {
+	var c ConfigDeslastre
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(datos, &c); err != nil {
+		return c, fmt.Errorf("%s: %v", ruta, err)
+	}
+	if err := c.validar(); err != nil {
+		return c, fmt.Errorf("%s: %v", ruta, err)
+	}
+	return c, nil
+}
+
+func (c ConfigDeslastre) validar() error {
+	var errs []error
+	if len(c.Edificios) == 0 {
+		errs = append(errs, errors.New("no hay edificios con límite"))
+	}
+	vistos := make(map[string]bool)
+	for _, l := range c.Edificios {
+		if l.Sitio == "" || l.Edificio == "" {
+			errs = append(errs, fmt.Errorf("edificio sin sitio o nombre: %+v", l))
+		}
+		if vistos[l.nombre()] {
+			errs = append(errs, fmt.Errorf("edificio repetido: %s", l.nombre()))
+		}
+		vistos[l.nombre()] = true
+		if l.LimiteA <= 0 || l.MargenA < 0 || l.MargenA >= l.LimiteA {
+			errs = append(errs, fmt.Errorf("%s: limite_a debe ser positivo y margen_a estar entre 0 y el límite", l.nombre()))
+		}
+	}
+	if c.ApagadoMinimo < 0 {
+		errs = append(errs, fmt.Errorf("apagado_minimo negativo: %s", c.ApagadoMinimo))
+	}
+	if c.Rotacion != 0 && c.Rotacion < c.ApagadoMinimo {
+		errs = append(errs, fmt.Errorf("rotacion (%s) no puede ser menor que apagado_minimo (%s)", c.Rotacion, c.ApagadoMinimo))
+	}
+	return errors.Join(errs...)
+}
+
+// corrienteOficina es la última lectura de una oficina. enUso indica si con
+// esa lectura el aire estaría funcionando: apagar uno que no consume no
+// baja la corriente.
+type corrienteOficina struct {
+	corrienteA float64
+	enUso      bool
+	recibida   time.Time
+}
+
+// Deslastre mantiene cada edificio bajo su límite apagando aires de a uno y
+// los restablece cuando sobra margen. Solo restablece los que apagó él: si
+// alguien cambia el aire de una oficina apagada, la oficina deja de estar a
+// su cargo.
+type Deslastre struct {
+	cfg ConfigDeslastre
+	// espera es lo que tarda un cambio en verse en las lecturas y vigencia,
+	// la antigüedad máxima de una lectura para sumarla.
+	espera, vigencia time.Duration
+
+	mu            sync.Mutex
+	corrientes    map[string]corrienteOficina
+	apagados      map[string]time.Time
+	ultimoApagado map[string]time.Time
+	ultimaAccion  map[string]time.Time
+}
+
+var deslastre *Deslastre
+
+func nuevoDeslastre(c ConfigDeslastre, intervalo, retrasoMax time.Duration) *Deslastre {
+	return &Deslastre{
+		cfg:           c,
+		espera:        2*intervalo + retrasoMax,
+		vigencia:      3*intervalo + retrasoMax,
+		corrientes:    make(map[string]corrienteOficina),
+		apagados:      make(map[string]time.Time),
+		ultimoApagado: make(map[string]time.Time),
+		ultimaAccion:  make(map[string]time.Time),
+	}
+}
+
+func (d *Deslastre) registrar(datos DatosSensor) {
+	mu.RLock()
+	umbral := config.UmbralTemperaturaAC
+	mu.RUnlock()
+	d.mu.Lock()
+	d.corrientes[datos.Oficina] = corrienteOficina{
+		corrienteA: datos.CorrienteA,
+		enUso:      datos.Presencia && datos.Temperatura >= umbral,
+		recibida:   time.Unix(datos.llegada(), 0),
+	}
+	d.mu.Unlock()
+}
+
+// liberar deja de controlar el aire de la oficina, que cambió otro.
+func (d *Deslastre) liberar(oficina string) {
+	d.mu.Lock()
+	if _, apagado := d.apagados[oficina]; apagado {
+		delete(d.apagados, oficina)
+		log.Printf("⚡ El aire de %s cambió fuera del deslastre, deja de estar a su cargo", oficina)
+	}
+	d.mu.Unlock()
+}
+
+func (d *Deslastre) olvidar(oficina string) {
+	d.mu.Lock()
+	delete(d.corrientes, oficina)
+	delete(d.apagados, oficina)
+	delete(d.ultimoApagado, oficina)
+	d.mu.Unlock()
+}
+
+func (d *Deslastre) ejecutar(ctx context.Context, intervalo time.Duration) {
+	t := time.NewTicker(intervalo)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			for _, l := range d.cfg.Edificios {
+				d.evaluar(l, time.Now())
+			}
+		}
+	}
+}
+
+// pasoDeslastre es lo que decide evaluar para un edificio: apagar y
+// restablecer pueden venir juntos cuando se rota.
+type pasoDeslastre struct {
+	totalA      float64
+	apagar      string
+	restablecer string
+}
+
+// decidir elige a lo sumo un aire para apagar y uno para restablecer. Se
+// llama con d.mu tomado.
+func (d *Deslastre) decidir(l LimiteEdificio, oficinas []string, aireEncendido map[string]bool, ahora time.Time) pasoDeslastre {
+	var paso pasoDeslastre
+	var candidatas, apagadas []string
+	for _, oficina := range oficinas {
+		c, existe := d.corrientes[oficina]
+		vigente := existe && ahora.Sub(c.recibida) <= d.vigencia
+		if vigente {
+			paso.totalA += c.corrienteA
+		}
+		if _, apagado := d.apagados[oficina]; apagado {
+			apagadas = append(apagadas, oficina)
+		} else if aireEncendido[oficina] && vigente && c.enUso {
+			candidatas = append(candidatas, oficina)
+		}
+	}
+	paso.totalA = math.Round(paso.totalA*100) / 100
+
+	// Primero la menor prioridad y, entre iguales, la que hace más que no
+	// se apaga.
+	prioridad := func(oficina string) int { return d.cfg.Prioridades[oficina] }
+	sort.Slice(candidatas, func(i, j int) bool {
+		a, b := candidatas[i], candidatas[j]
+		if prioridad(a) != prioridad(b) {
+			return prioridad(a) < prioridad(b)
+		}
+		if !d.ultimoApagado[a].Equal(d.ultimoApagado[b]) {
+			return d.ultimoApagado[a].Before(d.ultimoApagado[b])
+		}
+		return a < b
+	})
+	// Primero la mayor prioridad y, entre iguales, la que lleva más tiempo
+	// apagada.
+	sort.Slice(apagadas, func(i, j int) bool {
+		a, b := apagadas[i], apagadas[j]
+		if prioridad(a) != prioridad(b) {
+			return prioridad(a) > prioridad(b)
+		}
+		if !d.apagados[a].Equal(d.apagados[b]) {
+			return d.apagados[a].Before(d.apagados[b])
+		}
+		return a < b
+	})
+
+	cumplido := func(oficina string, plazo configuracion.Duracion) bool {
+		return ahora.Sub(d.apagados[oficina]) >= time.Duration(plazo)
+	}
+	switch {
+	case paso.totalA > l.LimiteA:
+		if len(candidatas) > 0 {
+			paso.apagar = candidatas[0]
+		}
+	case paso.totalA < l.LimiteA-l.MargenA:
+		for _, oficina := range apagadas {
+			if cumplido(oficina, d.cfg.ApagadoMinimo) {
+				paso.restablecer = oficina
+				break
+			}
+		}
+	case d.cfg.Rotacion > 0 && len(candidatas) > 0:
+		// La que más tiempo lleva apagada le cede el turno a la primera
+		// candidata, si no tiene más prioridad que ella.
+		var vencida string
+		for _, oficina := range apagadas {
+			if cumplido(oficina, d.cfg.Rotacion) && (vencida == "" || d.apagados[oficina].Before(d.apagados[vencida])) {
+				vencida = oficina
+			}
+		}
+		if vencida != "" && prioridad(candidatas[0]) <= prioridad(vencida) {
+			paso.apagar, paso.restablecer = candidatas[0], vencida
+		}
+	}
+	return paso
+}
+
+func (d *Deslastre) evaluar(l LimiteEdificio, ahora time.Time) {
+	oficinas := oficinasDe(filtroJerarquia{Sitio: l.Sitio, Edificio: l.Edificio})
+	aireEncendido := make(map[string]bool, len(oficinas))
+	mu.RLock()
+	for _, oficina := range oficinas {
+		aireEncendido[oficina] = dispositivoEstados[oficina]["aire"]
+	}
+	mu.RUnlock()
+
+	d.mu.Lock()
+	// Un aire apagado que aparece encendido lo prendió alguien desde
+	// socket.js, que no pasa por accionarDispositivo.
+	for _, oficina := range oficinas {
+		if desde, apagado := d.apagados[oficina]; apagado && aireEncendido[oficina] && ahora.Sub(desde) > d.espera {
+			delete(d.apagados, oficina)
+			log.Printf("⚡ El aire de %s cambió fuera del deslastre, deja de estar a su cargo", oficina)
+		}
+	}
+	paso := d.decidir(l, oficinas, aireEncendido, ahora)
+	apagados := 0
+	for _, oficina := range oficinas {
+		if _, apagado := d.apagados[oficina]; apagado {
+			apagados++
+		}
+	}
+	// Hasta que las lecturas reflejen el último cambio no se decide otro.
+	esperando := ahora.Sub(d.ultimaAccion[l.nombre()]) < d.espera
+	d.mu.Unlock()
+
+	metricaCorrienteEdificio.WithLabelValues(l.nombre()).Set(paso.totalA)
+	metricaAiresDeslastrados.WithLabelValues(l.nombre()).Set(float64(apagados))
+	if esperando || (paso.apagar == "" && paso.restablecer == "") {
+		return
+	}
+
+	motivo := fmt.Sprintf("%s: %.2f A con límite de %g A", l.nombre(), paso.totalA, l.LimiteA)
+	rotacion := paso.apagar != "" && paso.restablecer != ""
+	if paso.apagar != "" {
+		if rotacion {
+			motivo = fmt.Sprintf("%s: rotación con %s", l.nombre(), paso.restablecer)
+		}
+		if d.accionar(paso.apagar, false, motivo, ahora) != nil {
+			return
+		}
+	}
+	if paso.restablecer != "" {
+		if rotacion {
+			motivo = fmt.Sprintf("%s: rotación con %s", l.nombre(), paso.apagar)
+		}
+		d.accionar(paso.restablecer, true, motivo, ahora)
+	}
+	d.mu.Lock()
+	d.ultimaAccion[l.nombre()] = ahora
+	d.mu.Unlock()
+}
+
+func (d *Deslastre) accionar(oficina string, estado bool, motivo string, ahora time.Time) error {
+	accion, adicional := "apagar", "Aire apagado por "+motivo
+	if estado {
+		accion, adicional = "restablecer", "Aire restablecido por "+motivo
+	}
+	c := ComandoDispositivo{
+		Oficina:     oficina,
+		Dispositivo: "aire",
+		Estado:      estado,
+		Origen:      "deslastre",
+		Motivo:      adicional,
+		Timestamp:   ahora.Unix(),
+	}
+	if err := accionarDispositivo(context.Background(), c); err != nil {
+		metricaAccionesDeslastre.WithLabelValues(accion, "error").Inc()
+		log.Printf("❌ Error en el deslastre de %s: %v", oficina, err)
+		return err
+	}
+	metricaAccionesDeslastre.WithLabelValues(accion, "ok").Inc()
+	log.Printf("⚡ %s en %s", adicional, oficina)
+
+	d.mu.Lock()
+	if estado {
+		delete(d.apagados, oficina)
+	} else {
+		d.apagados[oficina] = ahora
+		d.ultimoApagado[oficina] = ahora
+	}
+	d.mu.Unlock()
+
+	aviso := Aviso{Timestamp: ahora.Unix(), IDTipo: avisoDeslastre, Adicional: adicional}
+	metricaAvisos.WithLabelValues(aviso.IDTipo).Inc()
+	if !ingesta.intentarEscribir(escrituraAviso(oficina, aviso)) {
+		log.Printf("⚠️  No se pudo encolar el aviso de deslastre de %s", oficina)
+	}
+	return nil
+}
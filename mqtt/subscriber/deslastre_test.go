@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+func TestDeslastreDecidir(t *testing.T) {
+	ahora := time.Unix(1700000000, 0)
+	hace := func(d time.Duration) time.Time { return ahora.Add(-d) }
+	limite := LimiteEdificio{Sitio: "Central", Edificio: "Norte", LimiteA: 30, MargenA: 8}
+
+	// lectura es la última corriente de una oficina y hace cuánto llegó.
+	type lectura struct {
+		corrienteA float64
+		enUso      bool
+		antiguedad time.Duration
+	}
+	casos := []struct {
+		nombre        string
+		prioridades   map[string]int
+		rotacion      time.Duration
+		lecturas      map[string]lectura
+		apagados      map[string]time.Duration
+		ultimoApagado map[string]time.Duration
+		aireApagado   []string
+		esperado      pasoDeslastre
+	}{
+		{
+			nombre:   "bajo el límite no hace nada",
+			lecturas: map[string]lectura{"A": {10, true, 0}, "B": {10, true, 0}},
+			esperado: pasoDeslastre{totalA: 20},
+		},
+		{
+			nombre:      "sobre el límite apaga la de menor prioridad",
+			prioridades: map[string]int{"A": 2, "C": 1},
+			lecturas:    map[string]lectura{"A": {12, true, 0}, "B": {12, true, 0}, "C": {12, true, 0}},
+			esperado:    pasoDeslastre{totalA: 36, apagar: "B"},
+		},
+		{
+			nombre:        "entre iguales apaga la que hace más que no se apaga",
+			lecturas:      map[string]lectura{"A": {12, true, 0}, "B": {12, true, 0}, "C": {12, true, 0}},
+			ultimoApagado: map[string]time.Duration{"A": time.Hour, "B": 10 * time.Minute, "C": 2 * time.Hour},
+			esperado:      pasoDeslastre{totalA: 36, apagar: "C"},
+		},
+		{
+			nombre:      "no apaga aires sin uso ni ya apagados",
+			lecturas:    map[string]lectura{"A": {16, false, 0}, "B": {16, true, 0}, "C": {2, true, 0}},
+			aireApagado: []string{"B"},
+			esperado:    pasoDeslastre{totalA: 34, apagar: "C"},
+		},
+		{
+			nombre:   "las lecturas vencidas no suman ni se apagan",
+			lecturas: map[string]lectura{"A": {20, true, time.Minute}, "B": {20, true, 0}},
+			esperado: pasoDeslastre{totalA: 20},
+		},
+		{
+			nombre:      "restablece la de mayor prioridad tras el apagado mínimo",
+			prioridades: map[string]int{"B": 3},
+			lecturas:    map[string]lectura{"C": {10, true, 0}},
+			apagados:    map[string]time.Duration{"A": 20 * time.Minute, "B": 6 * time.Minute},
+			esperado:    pasoDeslastre{totalA: 10, restablecer: "B"},
+		},
+		{
+			nombre:   "no restablece antes del apagado mínimo",
+			lecturas: map[string]lectura{"C": {10, true, 0}},
+			apagados: map[string]time.Duration{"A": 4 * time.Minute},
+			esperado: pasoDeslastre{totalA: 10},
+		},
+		{
+			nombre:   "dentro del margen no restablece",
+			lecturas: map[string]lectura{"C": {25, true, 0}},
+			apagados: map[string]time.Duration{"A": time.Hour},
+			esperado: pasoDeslastre{totalA: 25},
+		},
+		{
+			nombre:   "rota la que más tiempo lleva apagada",
+			rotacion: 15 * time.Minute,
+			lecturas: map[string]lectura{"C": {25, true, 0}},
+			apagados: map[string]time.Duration{"A": 20 * time.Minute, "B": 30 * time.Minute},
+			esperado: pasoDeslastre{totalA: 25, apagar: "C", restablecer: "B"},
+		},
+		{
+			nombre:      "no rota hacia una oficina de más prioridad",
+			rotacion:    15 * time.Minute,
+			prioridades: map[string]int{"C": 2},
+			lecturas:    map[string]lectura{"C": {25, true, 0}},
+			apagados:    map[string]time.Duration{"A": 30 * time.Minute},
+			esperado:    pasoDeslastre{totalA: 25},
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			d := nuevoDeslastre(ConfigDeslastre{
+				Edificios:     []LimiteEdificio{limite},
+				Prioridades:   c.prioridades,
+				ApagadoMinimo: configuracion.Duracion(5 * time.Minute),
+				Rotacion:      configuracion.Duracion(c.rotacion),
+			}, 10*time.Second, 0)
+			var oficinas []string
+			aireEncendido := make(map[string]bool)
+			for oficina, l := range c.lecturas {
+				d.corrientes[oficina] = corrienteOficina{corrienteA: l.corrienteA, enUso: l.enUso, recibida: hace(l.antiguedad)}
+				oficinas = append(oficinas, oficina)
+				aireEncendido[oficina] = true
+			}
+			for oficina, antiguedad := range c.apagados {
+				d.apagados[oficina] = hace(antiguedad)
+				oficinas = append(oficinas, oficina)
+			}
+			for oficina, antiguedad := range c.ultimoApagado {
+				d.ultimoApagado[oficina] = hace(antiguedad)
+			}
+			for _, oficina := range c.aireApagado {
+				aireEncendido[oficina] = false
+			}
+			if paso := d.decidir(limite, oficinas, aireEncendido, ahora); paso != c.esperado {
+				t.Errorf("decidir: %+v; se esperaba %+v", paso, c.esperado)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Factor usado cuando la configuración no define uno (mismo valor que el
+// análisis MPI).
+const factorEmisionPorDefecto = 0.5
+
+type RollupEmisiones struct {
+	EmisionesKg         float64 `json:"emisiones_kg"`
+	EmisionesEvitadasKg float64 `json:"emisiones_evitadas_kg"`
+	ConsumoKvh          float64 `json:"consumo_kvh"`
+	Timestamp           int64   `json:"timestamp"`
+}
+
+var emisionesPorOficina = make(map[string]RollupEmisiones)
+
+// factorEmision devuelve los kgCO2/kWh vigentes en el instante indicado.
+func factorEmision(cfg ParametrosConfig, instante int64) float64 {
+	if len(cfg.FactoresEmisionHora) == 24 {
+		hora := time.Unix(instante, 0).Hour()
+		return cfg.FactoresEmisionHora[hora]
+	}
+	if cfg.FactorEmision > 0 {
+		return cfg.FactorEmision
+	}
+	return factorEmisionPorDefecto
+}
+
+// validarFactoresHora rechaza factores por hora incompletos, que
+// factorEmision ignoraría.
+func validarFactoresHora(p ParametrosConfig) error {
+	if n := len(p.FactoresEmisionHora); n != 0 && n != 24 {
+		return fmt.Errorf("factores_emision_hora debe tener 24 valores, tiene %d", n)
+	}
+	return nil
+}
+
+// descartarFactoresHora deja el factor general si los parámetros leídos
+// traen factores por hora inválidos.
+func descartarFactoresHora(p *ParametrosConfig) {
+	if err := validarFactoresHora(*p); err != nil {
+		log.Printf("❌ %v; se usa factor_emision", err)
+		p.FactoresEmisionHora = nil
+	}
+}
+
+// calcularEmisiones devuelve las emisiones del período y las evitadas respecto
+// de la línea base. Las evitadas son negativas si se consumió por encima de ella.
+func calcularEmisiones(cfg ParametrosConfig, instante int64, consumoKwh, duracionHoras float64) (float64, float64) {
+	factor := factorEmision(cfg, instante)
+	emisiones := consumoKwh * factor
+
+	evitadas := 0.0
+	if cfg.CorrienteBaseA > 0 {
+		consumoBaseKwh := cfg.CorrienteBaseA * cfg.Voltaje * duracionHoras / 1000.0
+		evitadas = (consumoBaseKwh - consumoKwh) * factor
+	}
+	return emisiones, evitadas
+}
+
+func registrarEmisiones(oficina string, resumen Resumen) {
+	mu.Lock()
+	r := emisionesPorOficina[oficina]
+	r.EmisionesKg = resumen.EmisionesTotalKg
+	r.EmisionesEvitadasKg = resumen.EmisionesEvitadasTotalKg
+	r.ConsumoKvh = resumen.ConsumoTotalKvh
+	r.Timestamp = resumen.Timestamp
+	emisionesPorOficina[oficina] = r
+	mu.Unlock()
+}
+
+// cargarEmisiones recupera los acumulados guardados de las oficinas que
+// siguen existiendo, para que el total no baje tras un reinicio mientras
+// cada oficina vuelve a cerrar su primera ventana.
+func cargarEmisiones(ctx context.Context) error {
+	var guardadas map[string]RollupEmisiones
+	if err := clienteFirebase.NewRef(rutaFirebase("emisiones/oficinas")).Get(ctx, &guardadas); err != nil {
+		return fmt.Errorf("error leyendo emisiones: %v", err)
+	}
+	var ids map[string]interface{}
+	if err := clienteFirebase.NewRef(rutaFirebase("oficinas")).GetShallow(ctx, &ids); err != nil {
+		return fmt.Errorf("error leyendo oficinas: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for oficina, r := range guardadas {
+		if _, existe := ids[oficina]; !existe {
+			continue
+		}
+		if actual, ok := emisionesPorOficina[oficina]; !ok || actual.Timestamp < r.Timestamp {
+			emisionesPorOficina[oficina] = r
+		}
+	}
+	return nil
+}
+
+// rollupEmisiones suma los acumulados de todas las oficinas.
+func rollupEmisiones() (RollupEmisiones, map[string]RollupEmisiones) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var total RollupEmisiones
+	porOficina := make(map[string]RollupEmisiones, len(emisionesPorOficina))
+	for oficina, r := range emisionesPorOficina {
+		porOficina[oficina] = r
+		total.EmisionesKg += r.EmisionesKg
+		total.EmisionesEvitadasKg += r.EmisionesEvitadasKg
+		total.ConsumoKvh += r.ConsumoKvh
+		if r.Timestamp > total.Timestamp {
+			total.Timestamp = r.Timestamp
+		}
+	}
+	total.EmisionesKg = math.Round(total.EmisionesKg*1000) / 1000
+	total.EmisionesEvitadasKg = math.Round(total.EmisionesEvitadasKg*1000) / 1000
+	total.ConsumoKvh = math.Round(total.ConsumoKvh*100) / 100
+	return total, porOficina
+}
+
+// escriturasEmisiones actualiza en monitoreo_consumo/emisiones el acumulado
+// de la oficina y el total. El escritor deja un solo Set del total por lote.
+func escriturasEmisiones(oficina string) []escritura {
+	total, porOficina := rollupEmisiones()
+	return []escritura{
+		{
+			operacion: "emisiones",
+			ruta:      rutaFirebase("emisiones/oficinas/%s", oficina),
+			valor:     porOficina[oficina],
+		},
+		{
+			operacion: "emisiones",
+			ruta:      rutaFirebase("emisiones/total"),
+			valor:     total,
+		},
+	}
+}
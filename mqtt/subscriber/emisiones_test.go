@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func factoresPorHora() []float64 {
+	f := make([]float64, 24)
+	for h := range f {
+		f[h] = 0.3
+	}
+	f[19] = 0.8
+	return f
+}
+
+func TestFactorEmision(t *testing.T) {
+	a := func(hora int) int64 { return time.Date(2025, 3, 10, hora, 30, 0, 0, time.Local).Unix() }
+	casos := []struct {
+		nombre   string
+		p        ParametrosConfig
+		instante int64
+		factor   float64
+	}{
+		{"por defecto", ParametrosConfig{}, a(12), factorEmisionPorDefecto},
+		{"factor general", ParametrosConfig{FactorEmision: 0.42}, a(12), 0.42},
+		{"por hora, hora pico", ParametrosConfig{FactorEmision: 0.42, FactoresEmisionHora: factoresPorHora()}, a(19), 0.8},
+		{"por hora, resto del día", ParametrosConfig{FactorEmision: 0.42, FactoresEmisionHora: factoresPorHora()}, a(3), 0.3},
+		{"por hora incompletos usa el general", ParametrosConfig{FactorEmision: 0.42, FactoresEmisionHora: []float64{0.1, 0.2}}, a(1), 0.42},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if f := factorEmision(c.p, c.instante); f != c.factor {
+				t.Errorf("factorEmision: %v; se esperaba %v", f, c.factor)
+			}
+		})
+	}
+}
+
+func TestCalcularEmisiones(t *testing.T) {
+	pico := time.Date(2025, 3, 10, 19, 0, 0, 0, time.Local).Unix()
+	casos := []struct {
+		nombre    string
+		p         ParametrosConfig
+		consumo   float64
+		horas     float64
+		emisiones float64
+		evitadas  float64
+	}{
+		{"sin línea base", ParametrosConfig{FactorEmision: 0.5, Voltaje: 220}, 2, 1, 1, 0},
+		// Línea base de 10 A a 220 V durante una hora: 2,2 kWh.
+		{"por debajo de la línea base", ParametrosConfig{FactorEmision: 0.5, Voltaje: 220, CorrienteBaseA: 10}, 1.2, 1, 0.6, 0.5},
+		{"por encima de la línea base", ParametrosConfig{FactorEmision: 0.5, Voltaje: 220, CorrienteBaseA: 10}, 3.2, 1, 1.6, -0.5},
+		{"factor de la hora", ParametrosConfig{FactorEmision: 0.5, FactoresEmisionHora: factoresPorHora(), Voltaje: 220, CorrienteBaseA: 10}, 1.2, 1, 0.96, 0.8},
+		{"ventana de un minuto", ParametrosConfig{FactorEmision: 0.5, Voltaje: 220, CorrienteBaseA: 10}, 0.0022, 1.0 / 60, 0.0011, (2.2/60 - 0.0022) * 0.5},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			emisiones, evitadas := calcularEmisiones(c.p, pico, c.consumo, c.horas)
+			if math.Abs(emisiones-c.emisiones) > 1e-9 || math.Abs(evitadas-c.evitadas) > 1e-9 {
+				t.Errorf("calcularEmisiones: %v, %v; se esperaba %v, %v", emisiones, evitadas, c.emisiones, c.evitadas)
+			}
+		})
+	}
+}
+
+func TestValidarFactoresHora(t *testing.T) {
+	casos := []struct {
+		nombre   string
+		factores []float64
+		valido   bool
+	}{
+		{"sin factores por hora", nil, true},
+		{"24 factores", factoresPorHora(), true},
+		{"menos de 24", make([]float64, 23), false},
+		{"más de 24", make([]float64, 25), false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			p := ParametrosConfig{FactorEmision: 0.42, FactoresEmisionHora: c.factores}
+			err := validarFactoresHora(p)
+			if (err == nil) != c.valido {
+				t.Fatalf("validarFactoresHora: %v; se esperaba válido %v", err, c.valido)
+			}
+			if err != nil && !strings.Contains(err.Error(), "24") {
+				t.Errorf("el error no indica cuántos valores hacen falta: %v", err)
+			}
+			descartarFactoresHora(&p)
+			if c.valido && len(p.FactoresEmisionHora) != len(c.factores) {
+				t.Error("descartarFactoresHora quitó factores válidos")
+			}
+			if !c.valido && (p.FactoresEmisionHora != nil || p.FactorEmision != 0.42) {
+				t.Errorf("descartarFactoresHora dejó %+v; se esperaba solo el factor general", p)
+			}
+		})
+	}
+}
+
+// conEmisiones reemplaza los acumulados por oficina durante la prueba.
+func conEmisiones(t *testing.T, acumulados map[string]RollupEmisiones) {
+	t.Helper()
+	mu.Lock()
+	anteriores := emisionesPorOficina
+	emisionesPorOficina = acumulados
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		emisionesPorOficina = anteriores
+		mu.Unlock()
+	})
+}
+
+func TestRollupEmisiones(t *testing.T) {
+	conEmisiones(t, map[string]RollupEmisiones{})
+	registrarEmisiones("A", Resumen{Timestamp: 100, ConsumoTotalKvh: 1.005, EmisionesTotalKg: 0.5004, EmisionesEvitadasTotalKg: 0.2})
+	registrarEmisiones("B", Resumen{Timestamp: 160, ConsumoTotalKvh: 2.001, EmisionesTotalKg: 1.0004, EmisionesEvitadasTotalKg: -0.05})
+	// Un resumen posterior reemplaza el acumulado de la oficina.
+	registrarEmisiones("A", Resumen{Timestamp: 130, ConsumoTotalKvh: 1.5, EmisionesTotalKg: 0.75, EmisionesEvitadasTotalKg: 0.3})
+
+	total, porOficina := rollupEmisiones()
+	esperado := RollupEmisiones{EmisionesKg: 1.75, EmisionesEvitadasKg: 0.25, ConsumoKvh: 3.5, Timestamp: 160}
+	if total != esperado {
+		t.Errorf("total: %+v; se esperaba %+v", total, esperado)
+	}
+	if len(porOficina) != 2 || porOficina["A"].Timestamp != 130 {
+		t.Errorf("por oficina: %+v", porOficina)
+	}
+
+	escrituras := escriturasEmisiones("B")
+	if len(escrituras) != 2 || escrituras[0].ruta != rutaFirebase("emisiones/oficinas/B") || escrituras[1].valor != esperado {
+		t.Errorf("escrituras: %+v", escrituras)
+	}
+}
+
+func TestCargarEmisiones(t *testing.T) {
+	usarFirebaseDePrueba(t, `{"monitoreo_consumo": {
+		"oficinas": {"A": {"activa": true}, "B": {"activa": true}},
+		"emisiones": {"oficinas": {
+			"A": {"emisiones_kg": 5, "consumo_kvh": 10, "timestamp": 100},
+			"B": {"emisiones_kg": 7, "consumo_kvh": 14, "timestamp": 100},
+			"eliminada": {"emisiones_kg": 99, "consumo_kvh": 99, "timestamp": 100}
+		}}
+	}}`)
+	// B ya cerró una ventana después de lo guardado.
+	conEmisiones(t, map[string]RollupEmisiones{"B": {EmisionesKg: 8, ConsumoKvh: 16, Timestamp: 160}})
+
+	if err := cargarEmisiones(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	total, porOficina := rollupEmisiones()
+	if _, existe := porOficina["eliminada"]; existe {
+		t.Error("se cargó el acumulado de una oficina eliminada")
+	}
+	if porOficina["B"].Timestamp != 160 {
+		t.Errorf("B: %+v; se esperaba el acumulado más nuevo", porOficina["B"])
+	}
+	if total.EmisionesKg != 13 || total.ConsumoKvh != 26 {
+		t.Errorf("total: %+v", total)
+	}
+}
@@ -0,0 +1,742 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+const (
+	intervaloEscalamiento = 30 * time.Second
+	rutaIncidentes        = "escalamiento/incidentes"
+	formatoTurno          = "2006-01-02 15:04"
+)
+
+// NivelEscalamiento avisa a sus contactos cuando el incidente lleva Espera
+// sin reconocer desde el nivel anterior (desde la apertura, el primero).
+type NivelEscalamiento struct {
+	Espera configuracion.Duracion `json:"espera"`
+	// Canales son nombres de canales de notificación.
+	Canales []string `json:"canales,omitempty"`
+	// Guardia suma el canal de quien esté de guardia en ese calendario.
+	Guardia string `json:"guardia,omitempty"`
+	// IgnorarSilencio notifica también dentro de las horas de silencio.
+	IgnorarSilencio bool `json:"ignorar_silencio,omitempty"`
+}
+
+// HorasSilencio es una franja diaria ("HH:MM"); si Hasta es anterior a
+// Desde, cruza la medianoche.
+type HorasSilencio struct {
+	Desde        string `json:"desde"`
+	Hasta        string `json:"hasta"`
+	desde, hasta int
+}
+
+func (h *HorasSilencio) incluye(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if h.desde <= h.hasta {
+		return m >= h.desde && m < h.hasta
+	}
+	return m >= h.desde || m < h.hasta
+}
+
+// PoliticaEscalamiento abre un incidente para los avisos con impacto desde
+// ImpactoMin y, si se indican, solo de Tipos.
+type PoliticaEscalamiento struct {
+	Nombre     string              `json:"nombre"`
+	ImpactoMin int64               `json:"impacto_min,omitempty"`
+	Tipos      []string            `json:"tipos,omitempty"`
+	Niveles    []NivelEscalamiento `json:"niveles"`
+	Silencio   *HorasSilencio      `json:"silencio,omitempty"`
+}
+
+func (p PoliticaEscalamiento) aplica(idTipo string, impacto int64) bool {
+	return impacto >= p.ImpactoMin && (len(p.Tipos) == 0 || contiene(p.Tipos, idTipo))
+}
+
+// ReemplazoGuardia cubre un turno con otro canal entre Desde y Hasta
+// ("AAAA-MM-DD HH:MM").
+type ReemplazoGuardia struct {
+	Desde        string `json:"desde"`
+	Hasta        string `json:"hasta"`
+	Canal        string `json:"canal"`
+	desde, hasta time.Time
+}
+
+// Guardia es un calendario de guardias: los Canales se turnan cada Turno a
+// partir de Inicio ("AAAA-MM-DD HH:MM"), salvo los reemplazos.
+type Guardia struct {
+	Nombre     string                 `json:"nombre"`
+	Canales    []string               `json:"canales"`
+	Inicio     string                 `json:"inicio"`
+	Turno      configuracion.Duracion `json:"turno"`
+	Reemplazos []ReemplazoGuardia     `json:"reemplazos,omitempty"`
+	inicio     time.Time
+}
+
+// deTurno devuelve el canal de guardia en el instante t.
+func (g Guardia) deTurno(t time.Time) string {
+	for _, r := range g.Reemplazos {
+		if !t.Before(r.desde) && t.Before(r.hasta) {
+			return r.Canal
+		}
+	}
+	turno := time.Duration(g.Turno)
+	d := t.Sub(g.inicio)
+	i := int64(d / turno)
+	if d < 0 && d%turno != 0 {
+		i--
+	}
+	n := int64(len(g.Canales))
+	return g.Canales[(i%n+n)%n]
+}
+
+// ConfigEscalamiento es el archivo de la opción escalamiento.
+type ConfigEscalamiento struct {
+	ZonaHoraria string `json:"zona_horaria,omitempty"`
+	// SensorSilencioso abre un incidente de "Sensor no responde" para las
+	// oficinas que llevan ese tiempo sin enviar lecturas; 0 para no
+	// controlarlo.
+	SensorSilencioso configuracion.Duracion `json:"sensor_silencioso,omitempty"`
+	Guardias         []Guardia              `json:"guardias,omitempty"`
+	Politicas        []PoliticaEscalamiento `json:"politicas"`
+	zona             *time.Location
+}
+
+func cargarConfigEscalamiento(ruta string) (*ConfigEscalamiento, error) {
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return nil, err
+	}
+	var c ConfigEscalamiento
+	if err := json.Unmarshal(datos, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", ruta, err)
+	}
+	return &c, nil
+}
+
+// validar comprueba la configuración contra los canales de notificación
+// existentes.
+func (c *ConfigEscalamiento) validar(n *Notificador) error {
+	c.zona = time.Local
+	if c.ZonaHoraria != "" {
+		zona, err := time.LoadLocation(c.ZonaHoraria)
+		if err != nil {
+			return fmt.Errorf("zona_horaria inválida: %v", err)
+		}
+		c.zona = zona
+	}
+	if c.SensorSilencioso < 0 {
+		return errors.New("sensor_silencioso no puede ser negativo")
+	}
+	existeCanal := func(nombre string) error {
+		if n.canal(nombre) == nil {
+			return fmt.Errorf("canal de notificación desconocido %q", nombre)
+		}
+		return nil
+	}
+
+	guardias := make(map[string]bool)
+	for i := range c.Guardias {
+		g := &c.Guardias[i]
+		if g.Nombre == "" || guardias[g.Nombre] {
+			return fmt.Errorf("guardia %d: el nombre falta o está repetido", i)
+		}
+		guardias[g.Nombre] = true
+		if len(g.Canales) == 0 || g.Turno <= 0 {
+			return fmt.Errorf("guardia %s: faltan canales o turno", g.Nombre)
+		}
+		for _, canal := range g.Canales {
+			if err := existeCanal(canal); err != nil {
+				return fmt.Errorf("guardia %s: %v", g.Nombre, err)
+			}
+		}
+		var err error
+		if g.inicio, err = time.ParseInLocation(formatoTurno, g.Inicio, c.zona); err != nil {
+			return fmt.Errorf("guardia %s: inicio inválido %q, use AAAA-MM-DD HH:MM", g.Nombre, g.Inicio)
+		}
+		for j := range g.Reemplazos {
+			r := &g.Reemplazos[j]
+			r.desde, err = time.ParseInLocation(formatoTurno, r.Desde, c.zona)
+			if err == nil {
+				r.hasta, err = time.ParseInLocation(formatoTurno, r.Hasta, c.zona)
+			}
+			if err != nil || !r.hasta.After(r.desde) {
+				return fmt.Errorf("guardia %s: reemplazo %d inválido, use AAAA-MM-DD HH:MM con hasta posterior a desde", g.Nombre, j)
+			}
+			if err := existeCanal(r.Canal); err != nil {
+				return fmt.Errorf("guardia %s: %v", g.Nombre, err)
+			}
+		}
+	}
+
+	if len(c.Politicas) == 0 {
+		return errors.New("no hay políticas de escalamiento")
+	}
+	politicas := make(map[string]bool)
+	for i := range c.Politicas {
+		p := &c.Politicas[i]
+		if p.Nombre == "" || politicas[p.Nombre] {
+			return fmt.Errorf("política %d: el nombre falta o está repetido", i)
+		}
+		politicas[p.Nombre] = true
+		if len(p.Niveles) == 0 {
+			return fmt.Errorf("política %s: no tiene niveles", p.Nombre)
+		}
+		for j, nivel := range p.Niveles {
+			if nivel.Espera < 0 {
+				return fmt.Errorf("política %s: nivel %d con espera negativa", p.Nombre, j+1)
+			}
+			if len(nivel.Canales) == 0 && nivel.Guardia == "" {
+				return fmt.Errorf("política %s: nivel %d sin canales ni guardia", p.Nombre, j+1)
+			}
+			for _, canal := range nivel.Canales {
+				if err := existeCanal(canal); err != nil {
+					return fmt.Errorf("política %s: %v", p.Nombre, err)
+				}
+			}
+			if nivel.Guardia != "" && !guardias[nivel.Guardia] {
+				return fmt.Errorf("política %s: guardia desconocida %q", p.Nombre, nivel.Guardia)
+			}
+		}
+		if s := p.Silencio; s != nil {
+			desde, err1 := time.Parse("15:04", s.Desde)
+			hasta, err2 := time.Parse("15:04", s.Hasta)
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("política %s: horas de silencio inválidas, use HH:MM", p.Nombre)
+			}
+			s.desde, s.hasta = desde.Hour()*60+desde.Minute(), hasta.Hour()*60+hasta.Minute()
+		}
+	}
+	return nil
+}
+
+func (c *ConfigEscalamiento) politica(nombre string) *PoliticaEscalamiento {
+	for i := range c.Politicas {
+		if c.Politicas[i].Nombre == nombre {
+			return &c.Politicas[i]
+		}
+	}
+	return nil
+}
+
+func (c *ConfigEscalamiento) guardia(nombre string) *Guardia {
+	for i := range c.Guardias {
+		if c.Guardias[i].Nombre == nombre {
+			return &c.Guardias[i]
+		}
+	}
+	return nil
+}
+
+// Incidente es un aviso que sigue escalando hasta que se resuelve o alguien
+// lo reconoce. Se guarda en monitoreo_consumo/escalamiento/incidentes para
+// retomarlo si el subscriber se reinicia.
+type Incidente struct {
+	Oficina      string `json:"oficina"`
+	IDTipo       string `json:"id_tipo"`
+	Politica     string `json:"politica"`
+	Abierto      int64  `json:"abierto"`
+	Ultimo       int64  `json:"ultimo"`
+	Repeticiones int    `json:"repeticiones"`
+	Adicional    string `json:"adicional,omitempty"`
+	// Notificados marca los niveles ya avisados. Un nivel demorado por las
+	// horas de silencio puede quedar detrás de uno posterior.
+	Notificados   []bool `json:"notificados"`
+	ReconocidoPor string `json:"reconocido_por,omitempty"`
+	Reconocido    int64  `json:"reconocido,omitempty"`
+	Cerrado       int64  `json:"cerrado,omitempty"`
+	MotivoCierre  string `json:"motivo_cierre,omitempty"`
+}
+
+// Reconocimiento es lo que escribe un cliente de Firebase o el comando escalamiento
+// reconocer en incidentes/<clave>/reconocido.
+type Reconocimiento struct {
+	Por       string `json:"por"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type nodoIncidente struct {
+	Estado     *Incidente      `json:"estado"`
+	Reconocido *Reconocimiento `json:"reconocido"`
+}
+
+func claveIncidente(oficina, idTipo string) string {
+	return oficina + "_" + idTipo
+}
+
+// resoluciones son los tipos de aviso cuyo incidente se cierra solo cuando
+// una lectura posterior muestra que la condición pasó. Los demás se cierran
+// al reconocerlos.
+var resoluciones = map[string]struct {
+	motivo   string
+	resuelve func(DatosSensor, ParametrosConfig) bool
+}{
+	avisoConsumoAnomalo: {"el consumo volvió a lo normal", func(d DatosSensor, _ ParametrosConfig) bool {
+		return d.Presencia || d.CorrienteA <= 10.0
+	}},
+	avisoCorteEnergia: {"volvió la corriente", func(d DatosSensor, _ ParametrosConfig) bool {
+		return d.CorrienteA > 0
+	}},
+	avisoSensorNoResponde: {"el sensor volvió a enviar lecturas", func(DatosSensor, ParametrosConfig) bool {
+		return true
+	}},
+	avisoAlertaCorriente: {"el consumo bajó del umbral", func(d DatosSensor, p ParametrosConfig) bool {
+		return d.CorrienteA <= p.UmbralCorriente
+	}},
+}
+
+// Escalamiento sigue los incidentes abiertos y avisa a los niveles de su
+// política a medida que vencen las esperas.
+type Escalamiento struct {
+	cfg         *ConfigEscalamiento
+	notificador *Notificador
+	inicio      time.Time
+
+	mu            sync.Mutex
+	incidentes    map[string]*Incidente
+	ultimaLlegada map[string]int64
+	porGuardar    map[string]incidentePorGuardar
+
+	// hayPorGuardar avisa a ejecutar que hay incidentes por escribir y
+	// guardando evita que dos escrituras de un mismo incidente se crucen.
+	hayPorGuardar chan struct{}
+	guardando     sync.Mutex
+}
+
+// incidentePorGuardar es el último estado de un incidente que todavía no se
+// escribió; nuevo indica que hay que reemplazar el nodo entero.
+type incidentePorGuardar struct {
+	estado Incidente
+	nuevo  bool
+}
+
+var escalamiento *Escalamiento
+
+func nuevoEscalamiento(c *ConfigEscalamiento, n *Notificador) (*Escalamiento, error) {
+	if n == nil {
+		return nil, errors.New("el escalamiento necesita la opción notificaciones")
+	}
+	if err := c.validar(n); err != nil {
+		return nil, err
+	}
+	return &Escalamiento{
+		cfg:           c,
+		notificador:   n,
+		inicio:        time.Now(),
+		incidentes:    make(map[string]*Incidente),
+		ultimaLlegada: make(map[string]int64),
+		porGuardar:    make(map[string]incidentePorGuardar),
+		hayPorGuardar: make(chan struct{}, 1),
+	}, nil
+}
+
+func leerIncidentes(ctx context.Context) (map[string]nodoIncidente, error) {
+	var nodos map[string]nodoIncidente
+	if err := clienteFirebase.NewRef(rutaFirebase(rutaIncidentes)).Get(ctx, &nodos); err != nil {
+		return nil, fmt.Errorf("error leyendo los incidentes: %v", err)
+	}
+	return nodos, nil
+}
+
+// cargar retoma los incidentes abiertos que quedaron en Firebase. Se llama
+// antes de iniciar la ingesta para no duplicar los que sigan llegando.
+func (e *Escalamiento) cargar(ctx context.Context) error {
+	nodos, err := leerIncidentes(ctx)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for clave, nodo := range nodos {
+		inc := nodo.Estado
+		if inc == nil || inc.Cerrado != 0 {
+			continue
+		}
+		if p := e.cfg.politica(inc.Politica); p != nil && len(inc.Notificados) != len(p.Niveles) {
+			notificados := make([]bool, len(p.Niveles))
+			copy(notificados, inc.Notificados)
+			inc.Notificados = notificados
+		}
+		e.incidentes[clave] = inc
+	}
+	if len(e.incidentes) > 0 {
+		log.Printf("📟 %d incidentes abiertos recuperados", len(e.incidentes))
+	}
+	return nil
+}
+
+// guardar anota el estado del incidente para que lo escriba
+// guardarPendientes; al abrirlo reemplaza el nodo entero para borrar el
+// reconocimiento de un incidente anterior. Se llama con e.mu tomado, también
+// desde el escritor de la ingesta, así que no escribe en Firebase ni espera
+// lugar en su cola: de cada incidente queda pendiente solo el último estado.
+func (e *Escalamiento) guardar(inc *Incidente, nuevo bool) {
+	copia := *inc
+	copia.Notificados = append([]bool(nil), inc.Notificados...)
+	clave := claveIncidente(inc.Oficina, inc.IDTipo)
+	anterior, pendiente := e.porGuardar[clave]
+	e.porGuardar[clave] = incidentePorGuardar{estado: copia, nuevo: nuevo || (pendiente && anterior.nuevo)}
+	select {
+	case e.hayPorGuardar <- struct{}{}:
+	default:
+	}
+}
+
+// guardarPendientes escribe los incidentes anotados por guardar en una sola
+// actualización. Si falla, los vuelve a dejar pendientes, salvo los que ya
+// tengan un estado más nuevo, para el próximo intento.
+func (e *Escalamiento) guardarPendientes(ctx context.Context) error {
+	e.guardando.Lock()
+	defer e.guardando.Unlock()
+	e.mu.Lock()
+	pendientes := e.porGuardar
+	e.porGuardar = make(map[string]incidentePorGuardar)
+	e.mu.Unlock()
+	if len(pendientes) == 0 {
+		return nil
+	}
+
+	rutas := make(map[string]interface{}, len(pendientes))
+	for clave, p := range pendientes {
+		estado := p.estado
+		if p.nuevo {
+			rutas[clave] = nodoIncidente{Estado: &estado}
+		} else {
+			rutas[clave+"/estado"] = estado
+		}
+	}
+	err := clienteFirebase.NewRef(rutaFirebase(rutaIncidentes)).Update(ctx, rutas)
+	if err == nil {
+		return nil
+	}
+	e.mu.Lock()
+	for clave, p := range pendientes {
+		if nuevo, existe := e.porGuardar[clave]; existe {
+			p.estado = nuevo.estado
+			p.nuevo = p.nuevo || nuevo.nuevo
+		}
+		e.porGuardar[clave] = p
+	}
+	e.mu.Unlock()
+	return fmt.Errorf("error guardando %d incidentes: %v", len(pendientes), err)
+}
+
+// avisar abre un incidente si el aviso tiene una política, o suma la
+// repetición al incidente abierto. El aviso de sensor sin respuesta se
+// genera cuando el sensor ya volvió, así que esos incidentes los abre el
+// control de sensor_silencioso.
+func (e *Escalamiento) avisar(oficina string, aviso Aviso) {
+	if aviso.IDTipo == avisoSensorNoResponde {
+		return
+	}
+	mu.RLock()
+	impacto := tiposAvisos[aviso.IDTipo].Impacto
+	mu.RUnlock()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.abrir(oficina, aviso, impacto)
+}
+
+func (e *Escalamiento) abrir(oficina string, aviso Aviso, impacto int64) {
+	clave := claveIncidente(oficina, aviso.IDTipo)
+	if inc, abierto := e.incidentes[clave]; abierto {
+		inc.Repeticiones++
+		inc.Ultimo = aviso.Timestamp
+		inc.Adicional = aviso.Adicional
+		e.guardar(inc, false)
+		return
+	}
+	var politica *PoliticaEscalamiento
+	for i := range e.cfg.Politicas {
+		if e.cfg.Politicas[i].aplica(aviso.IDTipo, impacto) {
+			politica = &e.cfg.Politicas[i]
+			break
+		}
+	}
+	if politica == nil {
+		return
+	}
+
+	inc := &Incidente{
+		Oficina:      oficina,
+		IDTipo:       aviso.IDTipo,
+		Politica:     politica.Nombre,
+		Abierto:      aviso.Timestamp,
+		Ultimo:       aviso.Timestamp,
+		Repeticiones: 1,
+		Adicional:    aviso.Adicional,
+		Notificados:  make([]bool, len(politica.Niveles)),
+	}
+	e.incidentes[clave] = inc
+	log.Printf("📟 Incidente abierto: %s (política %s)", clave, politica.Nombre)
+	e.guardar(inc, true)
+	e.escalar(inc, time.Now())
+}
+
+// registrar cierra los incidentes de la oficina que la lectura resuelve y
+// anota su llegada para el control de sensores silenciosos.
+func (e *Escalamiento) registrar(datos DatosSensor) {
+	mu.RLock()
+	params := config
+	mu.RUnlock()
+	llegada := datos.llegada()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if llegada > e.ultimaLlegada[datos.Oficina] {
+		e.ultimaLlegada[datos.Oficina] = llegada
+	}
+	for idTipo, r := range resoluciones {
+		inc, abierto := e.incidentes[claveIncidente(datos.Oficina, idTipo)]
+		// La lectura que generó el aviso llegó antes de abrirse el
+		// incidente y no lo resuelve.
+		if abierto && llegada > inc.Abierto && r.resuelve(datos, params) {
+			e.cerrar(inc, r.motivo, time.Now())
+		}
+	}
+}
+
+func (e *Escalamiento) cerrar(inc *Incidente, motivo string, ahora time.Time) {
+	inc.Cerrado = ahora.Unix()
+	inc.MotivoCierre = motivo
+	clave := claveIncidente(inc.Oficina, inc.IDTipo)
+	delete(e.incidentes, clave)
+	log.Printf("✅ Incidente cerrado: %s (%s)", clave, motivo)
+	e.guardar(inc, false)
+}
+
+// olvidar cierra los incidentes de una oficina eliminada.
+func (e *Escalamiento) olvidar(oficina string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.ultimaLlegada, oficina)
+	for _, inc := range e.incidentes {
+		if inc.Oficina == oficina {
+			e.cerrar(inc, "oficina eliminada", time.Now())
+		}
+	}
+}
+
+// escalar avisa a los niveles cuya espera venció. Fuera de las horas de
+// silencio se avisa a los niveles que quedaron demorados.
+func (e *Escalamiento) escalar(inc *Incidente, ahora time.Time) {
+	politica := e.cfg.politica(inc.Politica)
+	if politica == nil || inc.Reconocido != 0 {
+		return
+	}
+	mu.RLock()
+	tipo := tiposAvisos[inc.IDTipo]
+	mu.RUnlock()
+
+	vence := time.Unix(inc.Abierto, 0)
+	cambios := false
+	for i, nivel := range politica.Niveles {
+		vence = vence.Add(time.Duration(nivel.Espera))
+		if ahora.Before(vence) {
+			break
+		}
+		if inc.Notificados[i] {
+			continue
+		}
+		if politica.Silencio != nil && !nivel.IgnorarSilencio && politica.Silencio.incluye(ahora.In(e.cfg.zona)) {
+			continue
+		}
+
+		canales := nivel.Canales
+		if nivel.Guardia != "" {
+			canales = append(append([]string(nil), canales...), e.cfg.guardia(nivel.Guardia).deTurno(ahora))
+		}
+		n := nuevaNotificacion(inc.Oficina, Aviso{Timestamp: inc.Abierto, IDTipo: inc.IDTipo, Adicional: inc.Adicional}, tipo)
+		n.Nivel = i + 1
+		for _, canal := range canales {
+			e.notificador.enviarA(canal, n)
+		}
+		inc.Notificados[i] = true
+		cambios = true
+		metricaEscalamientos.WithLabelValues(strconv.Itoa(i + 1)).Inc()
+		log.Printf("📟 Incidente %s escalado al nivel %d: %v", claveIncidente(inc.Oficina, inc.IDTipo), i+1, canales)
+	}
+	if cambios {
+		e.guardar(inc, false)
+	}
+}
+
+// revisar aplica los reconocimientos, abre los incidentes de sensores
+// silenciosos y escala los incidentes abiertos.
+func (e *Escalamiento) revisar(ctx context.Context, ahora time.Time) {
+	nodos, err := leerIncidentes(ctx)
+	if err != nil {
+		log.Printf("❌ %v", err)
+	}
+	oficinas := listarOficinas()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for clave, inc := range e.incidentes {
+		r := nodos[clave].Reconocido
+		if inc.Reconocido != 0 || r == nil || r.Timestamp < inc.Abierto {
+			continue
+		}
+		inc.Reconocido, inc.ReconocidoPor = r.Timestamp, r.Por
+		if _, resoluble := resoluciones[inc.IDTipo]; !resoluble {
+			e.cerrar(inc, "reconocido por "+r.Por, ahora)
+			continue
+		}
+		log.Printf("👍 Incidente %s reconocido por %s", clave, r.Por)
+		e.guardar(inc, false)
+	}
+
+	if silencio := time.Duration(e.cfg.SensorSilencioso); silencio > 0 {
+		for _, oficina := range oficinas {
+			ultima := e.ultimaLlegada[oficina]
+			if ultima == 0 {
+				ultima = e.inicio.Unix()
+			}
+			sinLecturas := ahora.Sub(time.Unix(ultima, 0))
+			if sinLecturas < silencio {
+				continue
+			}
+			if _, abierto := e.incidentes[claveIncidente(oficina, avisoSensorNoResponde)]; abierto {
+				continue
+			}
+			aviso := Aviso{
+				Timestamp: ahora.Unix(),
+				IDTipo:    avisoSensorNoResponde,
+				Adicional: fmt.Sprintf("Sin lecturas desde hace %s", sinLecturas.Round(time.Second)),
+			}
+			metricaAvisos.WithLabelValues(aviso.IDTipo).Inc()
+			if !ingesta.intentarEscribir(escrituraAviso(oficina, aviso)) {
+				log.Printf("⚠️  No se pudo encolar el aviso de sensor silencioso de %s", oficina)
+			}
+			mu.RLock()
+			impacto := tiposAvisos[avisoSensorNoResponde].Impacto
+			mu.RUnlock()
+			e.abrir(oficina, aviso, impacto)
+		}
+	}
+
+	for _, inc := range e.incidentes {
+		e.escalar(inc, ahora)
+	}
+	metricaIncidentesAbiertos.Set(float64(len(e.incidentes)))
+}
+
+func (e *Escalamiento) ejecutar(ctx context.Context) {
+	t := time.NewTicker(intervaloEscalamiento)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ahora := <-t.C:
+			e.revisar(ctx, ahora)
+			// También reintenta los que no se pudieron guardar antes.
+			if err := e.guardarPendientes(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("❌ %v", err)
+			}
+		case <-e.hayPorGuardar:
+			if err := e.guardarPendientes(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("❌ %v", err)
+			}
+		}
+	}
+}
+
+func comandoEscalamiento(args []string) error {
+	if len(args) == 0 {
+		return errors.New("uso: escalamiento listar [-todos] | reconocer -oficina X -tipo ID -por NOMBRE")
+	}
+	fs := flag.NewFlagSet("escalamiento "+args[0], flag.ContinueOnError)
+	todos := fs.Bool("todos", false, "incluir los incidentes cerrados")
+	oficina := fs.String("oficina", "", "oficina del incidente")
+	idTipo := fs.String("tipo", "", "ID de tipo de aviso del incidente")
+	por := fs.String("por", os.Getenv("USER"), "quién reconoce el incidente")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := cfg.ValidarFirebase(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := conectarFirebase(ctx); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "listar":
+		return listarIncidentes(ctx, *todos)
+	case "reconocer":
+		if *oficina == "" || *idTipo == "" || *por == "" {
+			return errors.New("reconocer necesita -oficina, -tipo y -por")
+		}
+		return reconocerIncidente(ctx, *oficina, *idTipo, *por)
+	}
+	return fmt.Errorf("subcomando desconocido: escalamiento %s", args[0])
+}
+
+func listarIncidentes(ctx context.Context, todos bool) error {
+	nodos, err := leerIncidentes(ctx)
+	if err != nil {
+		return err
+	}
+	claves := make([]string, 0, len(nodos))
+	for clave, nodo := range nodos {
+		if nodo.Estado != nil && (todos || nodo.Estado.Cerrado == 0) {
+			claves = append(claves, clave)
+		}
+	}
+	sort.Strings(claves)
+	for _, clave := range claves {
+		inc := nodos[clave].Estado
+		niveles := 0
+		for _, notificado := range inc.Notificados {
+			if notificado {
+				niveles++
+			}
+		}
+		estado := "abierto"
+		switch {
+		case inc.Cerrado != 0:
+			estado = "cerrado: " + inc.MotivoCierre
+		case inc.Reconocido != 0:
+			estado = "reconocido por " + inc.ReconocidoPor
+		case nodos[clave].Reconocido != nil:
+			estado = "reconocimiento pendiente"
+		}
+		fmt.Printf("%-24s %s  política %-12s niveles %d/%d  repeticiones %-4d %s\n",
+			clave, time.Unix(inc.Abierto, 0).Format("2006-01-02 15:04:05"), inc.Politica,
+			niveles, len(inc.Notificados), inc.Repeticiones, estado)
+	}
+	fmt.Printf("%d incidentes\n", len(claves))
+	return nil
+}
+
+// reconocerIncidente escribe el reconocimiento en Firebase; el subscriber lo
+// aplica en su próxima revisión.
+func reconocerIncidente(ctx context.Context, oficina, idTipo, por string) error {
+	clave := claveIncidente(oficina, idTipo)
+	nodos, err := leerIncidentes(ctx)
+	if err != nil {
+		return err
+	}
+	if inc := nodos[clave].Estado; inc == nil || inc.Cerrado != 0 {
+		return fmt.Errorf("no hay un incidente abierto %s", clave)
+	}
+	r := Reconocimiento{Por: por, Timestamp: time.Now().Unix()}
+	if err := clienteFirebase.NewRef(rutaFirebase(rutaIncidentes)+"/"+clave+"/reconocido").Set(ctx, r); err != nil {
+		return fmt.Errorf("error guardando el reconocimiento: %v", err)
+	}
+	fmt.Printf("Incidente %s reconocido por %s\n", clave, por)
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+func TestHorasSilencioIncluye(t *testing.T) {
+	nocturno := &HorasSilencio{desde: 22 * 60, hasta: 7 * 60}
+	almuerzo := &HorasSilencio{desde: 13 * 60, hasta: 14*60 + 30}
+	casos := []struct {
+		nombre   string
+		h        *HorasSilencio
+		hora     string
+		incluido bool
+	}{
+		{"antes de la franja", almuerzo, "12:59", false},
+		{"desde es inclusivo", almuerzo, "13:00", true},
+		{"dentro de la franja", almuerzo, "14:29", true},
+		{"hasta es exclusivo", almuerzo, "14:30", false},
+		{"cruza medianoche, antes", nocturno, "21:59", false},
+		{"cruza medianoche, desde", nocturno, "22:00", true},
+		{"cruza medianoche, a las 0", nocturno, "00:00", true},
+		{"cruza medianoche, de madrugada", nocturno, "06:59", true},
+		{"cruza medianoche, hasta", nocturno, "07:00", false},
+		{"cruza medianoche, de día", nocturno, "12:00", false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			hora, err := time.Parse("15:04", c.hora)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if incluido := c.h.incluye(hora); incluido != c.incluido {
+				t.Errorf("incluye(%s): %v; se esperaba %v", c.hora, incluido, c.incluido)
+			}
+		})
+	}
+}
+
+func TestGuardiaDeTurno(t *testing.T) {
+	instante := func(s string) time.Time {
+		t.Helper()
+		v, err := time.ParseInLocation(formatoTurno, s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	g := Guardia{
+		Nombre:  "soporte",
+		Canales: []string{"ana", "beto", "carla"},
+		Turno:   configuracion.Duracion(24 * time.Hour),
+		inicio:  instante("2025-03-03 08:00"),
+		Reemplazos: []ReemplazoGuardia{
+			{Canal: "dario", desde: instante("2025-03-05 12:00"), hasta: instante("2025-03-05 18:00")},
+		},
+	}
+	casos := []struct {
+		nombre string
+		en     string
+		canal  string
+	}{
+		{"primer turno", "2025-03-03 08:00", "ana"},
+		{"fin del primer turno", "2025-03-04 07:59", "ana"},
+		{"segundo turno", "2025-03-04 08:00", "beto"},
+		{"vuelve a empezar", "2025-03-06 08:00", "ana"},
+		{"antes del inicio", "2025-03-03 07:59", "carla"},
+		{"dos turnos antes del inicio", "2025-03-02 07:59", "beto"},
+		{"reemplazo", "2025-03-05 12:00", "dario"},
+		{"hasta del reemplazo es exclusivo", "2025-03-05 18:00", "carla"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if canal := g.deTurno(instante(c.en)); canal != c.canal {
+				t.Errorf("deTurno(%s): %s; se esperaba %s", c.en, canal, c.canal)
+			}
+		})
+	}
+}
+
+func TestEscalamientoGuardarSoloUltimoEstado(t *testing.T) {
+	e := &Escalamiento{
+		porGuardar:    make(map[string]incidentePorGuardar),
+		hayPorGuardar: make(chan struct{}, 1),
+	}
+	inc := &Incidente{Oficina: "A", IDTipo: "7", Repeticiones: 1, Notificados: []bool{false}}
+	e.guardar(inc, true)
+	inc.Repeticiones = 2
+	inc.Notificados[0] = true
+	e.guardar(inc, false)
+
+	p := e.porGuardar[claveIncidente("A", "7")]
+	if len(e.porGuardar) != 1 || !p.nuevo || p.estado.Repeticiones != 2 || !p.estado.Notificados[0] {
+		t.Errorf("pendiente: %+v; se esperaba el último estado reemplazando el nodo", e.porGuardar)
+	}
+	// La copia no comparte los niveles con el incidente en memoria.
+	inc.Notificados[0] = false
+	if !e.porGuardar[claveIncidente("A", "7")].estado.Notificados[0] {
+		t.Error("el estado pendiente cambió con el incidente")
+	}
+	if len(e.hayPorGuardar) != 1 {
+		t.Error("guardar no avisó que hay incidentes por escribir")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	firebase "firebase.google.com/go"
+	"google.golang.org/api/option"
+)
+
+// firebaseDePrueba atiende en memoria la API REST de Realtime Database, con
+// lo justo de consultas que usa el subscriber: orderBy de un hijo, startAt,
+// endAt, limitToLast y shallow.
+type firebaseDePrueba struct {
+	mu    sync.Mutex
+	datos interface{}
+	// fallas responde con ese status a "MÉTODO ruta" en lugar de atender.
+	fallas map[string]int
+}
+
+// usarFirebaseDePrueba reemplaza clienteFirebase durante la prueba por uno
+// que lee y escribe en datos (JSON).
+func usarFirebaseDePrueba(t *testing.T, datos string) *firebaseDePrueba {
+	t.Helper()
+	f := &firebaseDePrueba{fallas: make(map[string]int)}
+	if err := json.Unmarshal([]byte(datos), &f.datos); err != nil {
+		t.Fatal(err)
+	}
+	app, err := firebase.NewApp(context.Background(),
+		&firebase.Config{DatabaseURL: "https://prueba.firebaseio.com", ProjectID: "prueba"},
+		option.WithHTTPClient(&http.Client{Transport: f}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anterior := clienteFirebase
+	t.Cleanup(func() { clienteFirebase = anterior })
+	if clienteFirebase, err = app.Database(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// leer devuelve el nodo de la ruta ("a/b/c") decodificado en v.
+func (f *firebaseDePrueba) leer(t *testing.T, ruta string, v interface{}) {
+	t.Helper()
+	f.mu.Lock()
+	datos, _ := json.Marshal(nodo(f.datos, partes(ruta)))
+	f.mu.Unlock()
+	if err := json.Unmarshal(datos, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *firebaseDePrueba) existe(ruta string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return nodo(f.datos, partes(ruta)) != nil
+}
+
+func partes(ruta string) []string {
+	var p []string
+	for _, s := range strings.Split(ruta, "/") {
+		if s != "" {
+			p = append(p, s)
+		}
+	}
+	return p
+}
+
+func nodo(raiz interface{}, ruta []string) interface{} {
+	for _, p := range ruta {
+		m, ok := raiz.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		raiz = m[p]
+	}
+	return raiz
+}
+
+// poner escribe v en la ruta; nil borra el nodo.
+func poner(raiz interface{}, ruta []string, v interface{}) interface{} {
+	if len(ruta) == 0 {
+		return v
+	}
+	m, _ := raiz.(map[string]interface{})
+	if m == nil {
+		m = make(map[string]interface{})
+	}
+	if hijo := poner(m[ruta[0]], ruta[1:], v); hijo == nil {
+		delete(m, ruta[0])
+	} else {
+		m[ruta[0]] = hijo
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+func (f *firebaseDePrueba) RoundTrip(req *http.Request) (*http.Response, error) {
+	ruta := partes(strings.TrimSuffix(req.URL.Path, ".json"))
+	var cuerpo interface{}
+	if req.Body != nil {
+		datos, _ := io.ReadAll(req.Body)
+		if len(datos) > 0 {
+			if err := json.Unmarshal(datos, &cuerpo); err != nil {
+				return responder(http.StatusBadRequest, map[string]string{"error": err.Error()}), nil
+			}
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if status, falla := f.fallas[req.Method+" "+strings.Join(ruta, "/")]; falla {
+		return responder(status, map[string]string{"error": "falla de prueba"}), nil
+	}
+	switch req.Method {
+	case http.MethodGet:
+		return responder(http.StatusOK, consultar(nodo(f.datos, ruta), req.URL.Query())), nil
+	case http.MethodPut:
+		f.datos = poner(f.datos, ruta, cuerpo)
+		return responder(http.StatusNoContent, nil), nil
+	case http.MethodPatch:
+		for clave, v := range cuerpo.(map[string]interface{}) {
+			f.datos = poner(f.datos, append(append([]string(nil), ruta...), partes(clave)...), v)
+		}
+		return responder(http.StatusNoContent, nil), nil
+	case http.MethodPost:
+		clave := clavePush()
+		f.datos = poner(f.datos, append(ruta, clave), cuerpo)
+		return responder(http.StatusOK, map[string]string{"name": clave}), nil
+	case http.MethodDelete:
+		f.datos = poner(f.datos, ruta, nil)
+		return responder(http.StatusOK, nil), nil
+	}
+	return responder(http.StatusMethodNotAllowed, nil), nil
+}
+
+func consultar(n interface{}, q map[string][]string) interface{} {
+	valor := func(clave string) string {
+		if v := q[clave]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	hijos, ok := n.(map[string]interface{})
+	if !ok {
+		return n
+	}
+	if valor("shallow") == "true" {
+		claves := make(map[string]interface{}, len(hijos))
+		for k := range hijos {
+			claves[k] = true
+		}
+		return claves
+	}
+	orden := valor("orderBy")
+	if orden == "" {
+		return n
+	}
+	campo, _ := strconv.Unquote(orden)
+	numero := func(clave string) interface{} {
+		m, _ := hijos[clave].(map[string]interface{})
+		return m[campo]
+	}
+	claves := make([]string, 0, len(hijos))
+	for k := range hijos {
+		v, _ := numero(k).(float64)
+		if s := valor("startAt"); s != "" {
+			if d, _ := strconv.ParseFloat(s, 64); v < d {
+				continue
+			}
+		}
+		if s := valor("endAt"); s != "" {
+			if d, _ := strconv.ParseFloat(s, 64); v > d {
+				continue
+			}
+		}
+		claves = append(claves, k)
+	}
+	sort.Slice(claves, func(i, j int) bool {
+		a, _ := numero(claves[i]).(float64)
+		b, _ := numero(claves[j]).(float64)
+		if a != b {
+			return a < b
+		}
+		return claves[i] < claves[j]
+	})
+	if s := valor("limitToLast"); s != "" {
+		if n, _ := strconv.Atoi(s); n < len(claves) {
+			claves = claves[len(claves)-n:]
+		}
+	}
+	filtrados := make(map[string]interface{}, len(claves))
+	for _, k := range claves {
+		filtrados[k] = hijos[k]
+	}
+	return filtrados
+}
+
+func responder(status int, v interface{}) *http.Response {
+	var cuerpo []byte
+	if status != http.StatusNoContent {
+		cuerpo, _ = json.Marshal(v)
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(cuerpo)),
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"monitoreo_consumo/internal/servidorhttp"
+)
+
+// Canales que ofrece el hub, uno por endpoint /ws/<canal>.
+var canalesHub = []string{"resumenes", "avisos", "dispositivos", "params", "oficinas", "tipos_avisos"}
+
+const (
+	maxAvisosRecientes = 50
+	tamanoBufferEnvio  = 64
+	esperaEscrituraWS  = 10 * time.Second
+)
+
+var paramsPorDefecto = ParametrosConfig{
+	HoraInicio:          8.0,
+	HoraFin:             20.0,
+	UmbralTemperaturaAC: 25.0,
+	UmbralCorriente:     21.5,
+	Voltaje:             220.0,
+	CostoKwh:            0.25,
+	FactorEmision:       factorEmisionPorDefecto,
+	CorrienteBaseA:      8.0,
+}
+
+type Mensaje struct {
+	Tipo string      `json:"tipo"`
+	Data interface{} `json:"data"`
+}
+
+type clienteWS struct {
+	conn  *websocket.Conn
+	envio chan []byte
+}
+
+// Hub reemplaza al servidor socket.js: mantiene los clientes WebSocket de cada
+// canal y les difunde los datos reales del subscriber. Los manejadores locales
+// reciben los mismos mensajes que los clientes remotos.
+type Hub struct {
+	mu       sync.RWMutex
+	clientes map[string]map[*clienteWS]bool
+	locales  map[string][]func([]byte)
+	upgrader websocket.Upgrader
+}
+
+var (
+	hub              *Hub
+	ultimosResumenes = make(map[string]Resumen)
+	avisosRecientes  []AvisoOficina
+)
+
+func nuevoHub() *Hub {
+	h := &Hub{
+		clientes: make(map[string]map[*clienteWS]bool),
+		locales:  make(map[string][]func([]byte)),
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+	for _, canal := range canalesHub {
+		h.clientes[canal] = make(map[*clienteWS]bool)
+	}
+	return h
+}
+
+func (h *Hub) suscribirLocal(canal string, fn func([]byte)) {
+	h.mu.Lock()
+	h.locales[canal] = append(h.locales[canal], fn)
+	h.mu.Unlock()
+}
+
+// difundir envía {tipo, data} a los clientes del canal y a los manejadores
+// locales. No bloquea: un cliente con el buffer lleno se desconecta.
+func (h *Hub) difundir(canal, tipo string, data interface{}) {
+	msg, err := json.Marshal(Mensaje{Tipo: tipo, Data: data})
+	if err != nil {
+		log.Printf("❌ Error serializando mensaje %s: %v", tipo, err)
+		return
+	}
+
+	h.mu.RLock()
+	locales := h.locales[canal]
+	var lentos []*clienteWS
+	for c := range h.clientes[canal] {
+		select {
+		case c.envio <- msg:
+		default:
+			lentos = append(lentos, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range lentos {
+		log.Printf("⚠️  Cliente de %s demasiado lento, desconectando", canal)
+		h.quitar(canal, c)
+	}
+	for _, fn := range locales {
+		fn(msg)
+	}
+}
+
+func (h *Hub) quitar(canal string, c *clienteWS) {
+	h.mu.Lock()
+	if h.clientes[canal][c] {
+		delete(h.clientes[canal], c)
+		close(c.envio)
+	}
+	h.mu.Unlock()
+}
+
+func (h *Hub) iniciar(ctx context.Context, direccion string, plazo time.Duration) {
+	mux := http.NewServeMux()
+	for _, canal := range canalesHub {
+		mux.HandleFunc("/ws/"+canal, h.manejarConexion(canal))
+	}
+	servidorhttp.Servir(ctx, "Hub WebSocket", direccion, mux, plazo)
+}
+
+// cerrar envía el cierre a todos los clientes. Las conexiones WebSocket no
+// las cierra el Shutdown del servidor HTTP.
+func (h *Hub) cerrar() {
+	h.mu.RLock()
+	var todos [][2]interface{}
+	for canal, clientes := range h.clientes {
+		for c := range clientes {
+			todos = append(todos, [2]interface{}{canal, c})
+		}
+	}
+	h.mu.RUnlock()
+	for _, t := range todos {
+		h.quitar(t[0].(string), t[1].(*clienteWS))
+	}
+}
+
+func (h *Hub) manejarConexion(canal string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := h.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("❌ Error aceptando conexión %s: %v", canal, err)
+			return
+		}
+		c := &clienteWS{conn: conn, envio: make(chan []byte, tamanoBufferEnvio)}
+
+		tipo, data := mensajeInicial(canal)
+		if inicial, err := json.Marshal(Mensaje{Tipo: tipo, Data: data}); err == nil {
+			c.envio <- inicial
+		}
+
+		h.mu.Lock()
+		h.clientes[canal][c] = true
+		h.mu.Unlock()
+		log.Printf("🔌 Cliente conectado a %s", canal)
+
+		go c.escribir()
+		c.leer(func(msg []byte) { h.procesarMensaje(canal, msg) })
+
+		h.quitar(canal, c)
+		log.Printf("🔌 Cliente de %s desconectado", canal)
+	}
+}
+
+func (c *clienteWS) escribir() {
+	defer c.conn.Close()
+	for msg := range c.envio {
+		c.conn.SetWriteDeadline(time.Now().Add(esperaEscrituraWS))
+		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return
+		}
+	}
+	c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+}
+
+func (c *clienteWS) leer(procesar func([]byte)) {
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		procesar(msg)
+	}
+}
+
+func datosOficinas() map[string]interface{} {
+	ahora := time.Now().Unix()
+	datos := make(map[string]interface{})
+	for _, oficina := range listarOficinas() {
+		datos[oficina] = map[string]interface{}{
+			"nombre":    oficina,
+			"activa":    true,
+			"timestamp": ahora,
+		}
+	}
+	return datos
+}
+
+func datosResumenes() map[string]Resumen {
+	mu.RLock()
+	defer mu.RUnlock()
+	datos := make(map[string]Resumen, len(oficinas))
+	for _, oficina := range oficinas {
+		datos[oficina] = ultimosResumenes[oficina]
+	}
+	return datos
+}
+
+func datosDispositivos() map[string]map[string]bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	datos := make(map[string]map[string]bool, len(dispositivoEstados))
+	for oficina, estados := range dispositivoEstados {
+		copia := make(map[string]bool, len(estados))
+		for d, v := range estados {
+			copia[d] = v
+		}
+		datos[oficina] = copia
+	}
+	return datos
+}
+
+func mensajeInicial(canal string) (string, interface{}) {
+	switch canal {
+	case "resumenes":
+		return "resumenes", datosResumenes()
+	case "avisos":
+		mu.RLock()
+		recientes := append([]AvisoOficina{}, avisosRecientes...)
+		mu.RUnlock()
+		return "avisos", recientes
+	case "dispositivos":
+		return "dispositivos", datosDispositivos()
+	case "params":
+		mu.RLock()
+		defer mu.RUnlock()
+		return "params", config
+	case "oficinas":
+		return "oficinas", datosOficinas()
+	case "tipos_avisos":
+		mu.RLock()
+		defer mu.RUnlock()
+		return "tipos_avisos", tiposAvisos
+	}
+	return canal, nil
+}
+
+// procesarMensaje atiende los mensajes que envía el dashboard.
+func (h *Hub) procesarMensaje(canal string, data []byte) {
+	var m struct {
+		Tipo        string          `json:"tipo"`
+		Data        json.RawMessage `json:"data"`
+		Oficina     string          `json:"oficina"`
+		Dispositivo string          `json:"dispositivo"`
+		Estado      bool            `json:"estado"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Printf("❌ Error parseando mensaje de %s: %v", canal, err)
+		return
+	}
+	ctx := context.Background()
+
+	switch {
+	case canal == "dispositivos" && m.Tipo == "actualizar_dispositivo":
+		comando := ComandoDispositivo{
+			Oficina:     m.Oficina,
+			Dispositivo: m.Dispositivo,
+			Estado:      m.Estado,
+			Origen:      "dashboard",
+			Timestamp:   time.Now().Unix(),
+		}
+		if err := accionarDispositivo(ctx, comando); err != nil {
+			log.Printf("❌ Error actualizando dispositivo: %v", err)
+		}
+	case canal == "params" && m.Tipo == "actualizar_params":
+		// Los campos que el dashboard no envía conservan su valor.
+		mu.RLock()
+		nuevos := config
+		// Copia para que Unmarshal no escriba sobre el arreglo de config.
+		nuevos.FactoresEmisionHora = append([]float64(nil), config.FactoresEmisionHora...)
+		mu.RUnlock()
+		if err := json.Unmarshal(m.Data, &nuevos); err != nil {
+			log.Printf("❌ Error parseando parámetros: %v", err)
+			return
+		}
+		if err := validarFactoresHora(nuevos); err != nil {
+			log.Printf("❌ Parámetros rechazados: %v", err)
+			return
+		}
+		ref := clienteFirebase.NewRef(rutaFirebase("configuracion"))
+		if err := ref.Set(ctx, nuevos); err != nil {
+			log.Printf("❌ Error guardando configuración en Firebase: %v", err)
+		}
+		h.difundir("params", "params", nuevos)
+	case canal == "oficinas" && m.Tipo == "actualizar_oficinas":
+		var nuevas map[string]interface{}
+		if err := json.Unmarshal(m.Data, &nuevas); err != nil {
+			log.Printf("❌ Error parseando oficinas: %v", err)
+			return
+		}
+		h.agregarOficinas(ctx, nuevas)
+	case canal == "oficinas" && m.Tipo == "eliminar_oficina":
+		var datos struct {
+			Oficina string `json:"oficina"`
+		}
+		if err := json.Unmarshal(m.Data, &datos); err != nil || datos.Oficina == "" {
+			log.Printf("❌ Error parseando eliminación de oficina: %v", err)
+			return
+		}
+		mu.Lock()
+		delete(dispositivoEstados, datos.Oficina)
+		delete(ultimosResumenes, datos.Oficina)
+		mu.Unlock()
+		h.difundir("oficinas", "eliminar_oficina", datos)
+		h.difundir("oficinas", "oficinas", datosOficinas())
+		h.difundir("dispositivos", "dispositivos", datosDispositivos())
+	default:
+		log.Printf("⚠️  Mensaje ignorado en %s: %s", canal, m.Tipo)
+	}
+}
+
+func (h *Hub) actualizarDispositivo(ctx context.Context, oficina, dispositivo string, estado bool) error {
+	if dispositivo != "luces" && dispositivo != "aire" {
+		return fmt.Errorf("dispositivo desconocido: %s", dispositivo)
+	}
+	if !existeOficina(oficina) {
+		return fmt.Errorf("oficina desconocida: %s", oficina)
+	}
+
+	estados := datosDispositivos()
+	if estados[oficina] == nil {
+		estados[oficina] = map[string]bool{"aire": true, "luces": true}
+	}
+	estados[oficina][dispositivo] = estado
+
+	ref := clienteFirebase.NewRef(rutaFirebase("oficinas/%s/estados_dispositivos", oficina))
+	if err := ref.Set(ctx, estados[oficina]); err != nil {
+		log.Printf("❌ Error guardando dispositivos de %s en Firebase: %v", oficina, err)
+	}
+
+	log.Printf("💡 Dispositivo actualizado: %s.%s = %v", oficina, dispositivo, estado)
+	h.difundir("dispositivos", "dispositivos", estados)
+	return nil
+}
+
+func (h *Hub) agregarOficinas(ctx context.Context, nuevas map[string]interface{}) {
+	estados := datosDispositivos()
+	datos := datosOficinas()
+	for oficina := range nuevas {
+		if _, existe := datos[oficina]; existe {
+			continue
+		}
+		datos[oficina] = map[string]interface{}{
+			"nombre":    oficina,
+			"activa":    true,
+			"timestamp": time.Now().Unix(),
+		}
+		estados[oficina] = map[string]bool{"aire": true, "luces": true}
+
+		ref := clienteFirebase.NewRef(rutaFirebase("oficinas/%s", oficina))
+		if err := ref.Update(ctx, map[string]interface{}{
+			"nombre":               fmt.Sprintf("Oficina %s", oficina),
+			"baja":                 false,
+			"estados_dispositivos": estados[oficina],
+		}); err != nil {
+			log.Printf("❌ Error creando oficina %s en Firebase: %v", oficina, err)
+		}
+		log.Printf("✅ Nueva oficina creada: %s", oficina)
+	}
+	h.difundir("dispositivos", "dispositivos", estados)
+	h.difundir("oficinas", "oficinas", datos)
+}
+
+// publicarResumen y publicarAviso registran lo último procesado y lo difunden
+// por el hub, si está activo.
+func publicarResumen(oficina string, resumen Resumen) {
+	mu.Lock()
+	ultimosResumenes[oficina] = resumen
+	mu.Unlock()
+	if hub != nil {
+		hub.difundir("resumenes", "resumenes", datosResumenes())
+	}
+}
+
+func publicarAviso(oficina string, aviso Aviso) {
+	a := AvisoOficina{Oficina: oficina, Aviso: aviso}
+	mu.Lock()
+	avisosRecientes = append(avisosRecientes, a)
+	if len(avisosRecientes) > maxAvisosRecientes {
+		avisosRecientes = avisosRecientes[len(avisosRecientes)-maxAvisosRecientes:]
+	}
+	mu.Unlock()
+	if hub != nil {
+		hub.difundir("avisos", "avisos", []AvisoOficina{a})
+	}
+	if notificador != nil {
+		notificador.notificar(oficina, aviso)
+	}
+	if escalamiento != nil {
+		escalamiento.avisar(oficina, aviso)
+	}
+}
+
+// decodificarTiposAvisos acepta el catálogo como objeto o como arreglo:
+// Firebase devuelve un arreglo cuando las claves son "0", "1", "2"...
+func decodificarTiposAvisos(raw json.RawMessage) (map[string]TipoAviso, error) {
+	catalogo := make(map[string]TipoAviso)
+	if len(raw) == 0 || string(raw) == "null" {
+		return catalogo, nil
+	}
+	if err := json.Unmarshal(raw, &catalogo); err == nil {
+		return catalogo, nil
+	}
+	var lista []*TipoAviso
+	if err := json.Unmarshal(raw, &lista); err != nil {
+		return nil, fmt.Errorf("catálogo de avisos inválido: %v", err)
+	}
+	for i, t := range lista {
+		if t != nil {
+			catalogo[fmt.Sprint(i)] = *t
+		}
+	}
+	return catalogo, nil
+}
+
+// cargarEstadoInicial lee de Firebase lo que antes enviaba socket.js al
+// conectarse y lo difunde a los manejadores locales.
+func cargarEstadoInicial(ctx context.Context, h *Hub) error {
+	params := paramsPorDefecto
+	if err := clienteFirebase.NewRef(rutaFirebase("configuracion")).Get(ctx, &params); err != nil {
+		return fmt.Errorf("error leyendo configuración: %v", err)
+	}
+	if params.Voltaje == 0 {
+		params = paramsPorDefecto
+	}
+	descartarFactoresHora(&params)
+
+	var raw json.RawMessage
+	if err := clienteFirebase.NewRef(rutaFirebase("tipos_avisos")).Get(ctx, &raw); err != nil {
+		return fmt.Errorf("error leyendo tipos de avisos: %v", err)
+	}
+	catalogo, err := decodificarTiposAvisos(raw)
+	if err != nil {
+		return err
+	}
+
+	var ids map[string]interface{}
+	if err := clienteFirebase.NewRef(rutaFirebase("oficinas")).GetShallow(ctx, &ids); err != nil {
+		return fmt.Errorf("error leyendo oficinas: %v", err)
+	}
+	lista := make([]string, 0, len(ids))
+	for id := range ids {
+		lista = append(lista, id)
+	}
+	sort.Strings(lista)
+
+	estados := make(map[string]map[string]bool)
+	datos := make(map[string]interface{})
+	for _, oficina := range lista {
+		var est map[string]bool
+		ref := clienteFirebase.NewRef(rutaFirebase("oficinas/%s/estados_dispositivos", oficina))
+		if err := ref.Get(ctx, &est); err != nil || est == nil {
+			est = map[string]bool{"aire": true, "luces": true}
+		}
+		estados[oficina] = est
+		datos[oficina] = map[string]interface{}{"nombre": oficina, "activa": true, "timestamp": time.Now().Unix()}
+
+		var ultimos map[string]Resumen
+		refRes := clienteFirebase.NewRef(rutaFirebase("oficinas/%s/resumenes", oficina))
+		if err := refRes.OrderByChild("timestamp").LimitToLast(1).Get(ctx, &ultimos); err == nil {
+			for _, r := range ultimos {
+				mu.Lock()
+				ultimosResumenes[oficina] = r
+				mu.Unlock()
+			}
+		}
+	}
+
+	h.difundir("params", "params", params)
+	h.difundir("tipos_avisos", "tipos_avisos", catalogo)
+	h.difundir("oficinas", "oficinas", datos)
+	h.difundir("dispositivos", "dispositivos", estados)
+	log.Printf("✅ Estado inicial cargado: %d oficinas, %d tipos de avisos", len(lista), len(catalogo))
+	return nil
+}
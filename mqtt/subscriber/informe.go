@@ -0,0 +1,546 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	formatoMes      = "2006-01"
+	avisosEnInforme = 5
+)
+
+// PeriodoTarifa es una franja horaria con su propio costo del kWh. Desde y
+// Hasta son horas del día (Hasta puede ser menor que Desde para cruzar la
+// medianoche) y Dias, los días de la semana en que rige (0 es domingo);
+// vacío para todos.
+type PeriodoTarifa struct {
+	Nombre   string  `json:"nombre"`
+	Desde    float64 `json:"desde"`
+	Hasta    float64 `json:"hasta"`
+	Dias     []int   `json:"dias,omitempty"`
+	CostoKwh float64 `json:"costo_kwh"`
+}
+
+func (p PeriodoTarifa) incluye(t time.Time) bool {
+	if len(p.Dias) > 0 {
+		rige := false
+		for _, d := range p.Dias {
+			rige = rige || time.Weekday(d) == t.Weekday()
+		}
+		if !rige {
+			return false
+		}
+	}
+	hora := float64(t.Hour()) + float64(t.Minute())/60
+	if p.Desde <= p.Hasta {
+		return hora >= p.Desde && hora < p.Hasta
+	}
+	return hora >= p.Desde || hora < p.Hasta
+}
+
+// validarTarifas revisa los períodos tarifarios leídos del JSON.
+func validarTarifas(periodos []PeriodoTarifa) error {
+	nombres := map[string]bool{"General": true}
+	for _, p := range periodos {
+		switch {
+		case p.Nombre == "":
+			return fmt.Errorf("período tarifario sin nombre: %+v", p)
+		case nombres[p.Nombre]:
+			return fmt.Errorf("período tarifario %q repetido", p.Nombre)
+		case p.Desde < 0 || p.Desde >= 24 || p.Hasta <= 0 || p.Hasta > 24 || p.Desde == p.Hasta:
+			return fmt.Errorf("período tarifario %q: desde y hasta deben ser horas distintas entre 0 y 24", p.Nombre)
+		case p.CostoKwh < 0:
+			return fmt.Errorf("período tarifario %q: costo_kwh negativo", p.Nombre)
+		}
+		for _, d := range p.Dias {
+			if d < 0 || d > 6 {
+				return fmt.Errorf("período tarifario %q: día %d fuera de 0 (domingo) a 6", p.Nombre, d)
+			}
+		}
+		nombres[p.Nombre] = true
+	}
+	return nil
+}
+
+// ConsumoPeriodo es el consumo y el costo de un período tarifario.
+type ConsumoPeriodo struct {
+	Nombre     string
+	ConsumoKwh float64
+	Costo      float64
+}
+
+type ConteoAviso struct {
+	IDTipo   string `json:"id_tipo"`
+	Motivo   string `json:"motivo"`
+	Cantidad int    `json:"cantidad"`
+}
+
+// EstadoCuenta es el informe mensual de una oficina, un edificio o un
+// sector.
+type EstadoCuenta struct {
+	Oficina        string
+	Nombre         string
+	Sector         string
+	Edificio       string
+	ConsumoKwh     float64
+	Costo          float64
+	Periodos       []ConsumoPeriodo
+	DemandaPicoKw  float64
+	InstantePico   int64
+	HorasPresencia float64
+	Avisos         []ConteoAviso
+
+	ConsumoAnteriorKwh float64
+	CostoAnterior      float64
+
+	// Demanda por minuto, para calcular el pico coincidente del sector.
+	demanda map[int64]float64
+}
+
+// Variacion es el cambio porcentual del consumo respecto del mes anterior;
+// NaN si no hubo consumo ese mes.
+func (e EstadoCuenta) Variacion() float64 {
+	if e.ConsumoAnteriorKwh == 0 {
+		return math.NaN()
+	}
+	return (e.ConsumoKwh - e.ConsumoAnteriorKwh) / e.ConsumoAnteriorKwh * 100
+}
+
+// facturador reparte el consumo de los resúmenes entre los períodos
+// tarifarios. Lo que no cae en ninguno se cobra al costo_kwh general.
+type facturador struct {
+	periodos []PeriodoTarifa
+	params   ParametrosConfig
+}
+
+func (f facturador) nombres() []string {
+	nombres := make([]string, 0, len(f.periodos)+1)
+	for _, p := range f.periodos {
+		nombres = append(nombres, p.Nombre)
+	}
+	return append(nombres, "General")
+}
+
+func (f facturador) periodo(instante int64) (int, float64) {
+	t := time.Unix(instante, 0)
+	for i, p := range f.periodos {
+		if p.incluye(t) {
+			return i, p.CostoKwh
+		}
+	}
+	return len(f.periodos), f.params.CostoKwh
+}
+
+// facturar calcula el consumo, el costo por período, la demanda y la
+// presencia de los resúmenes, ordenados por timestamp. El consumo de cada
+// ventana sale de sus acumulados (ver porVentana): su consumo_kvh redondeado
+// subestima o sobreestima el mes entero.
+func (f facturador) facturar(e *EstadoCuenta, resumenes []Resumen) {
+	e.Periodos = make([]ConsumoPeriodo, len(f.periodos)+1)
+	for i, nombre := range f.nombres() {
+		e.Periodos[i].Nombre = nombre
+	}
+	e.demanda = make(map[int64]float64)
+	presencia := 0
+	ventanas := valoresVentanas(resumenes)
+	for j, r := range resumenes {
+		i, costo := f.periodo(r.Timestamp)
+		consumo := ventanas[j].consumo
+		e.Periodos[i].ConsumoKwh += consumo
+		e.Periodos[i].Costo += consumo * costo
+		e.ConsumoKwh += consumo
+		e.Costo += consumo * costo
+		presencia += r.TiempoPresente
+
+		kw := r.CorrienteA * f.params.Voltaje / 1000
+		e.demanda[r.Timestamp/60] += kw
+		if kw > e.DemandaPicoKw {
+			e.DemandaPicoKw = kw
+			e.InstantePico = r.Timestamp
+		}
+	}
+	e.HorasPresencia = float64(presencia) / 3600
+}
+
+// costo devuelve el consumo y el costo de los resúmenes, sin el detalle.
+func (f facturador) costo(resumenes []Resumen) (float64, float64) {
+	var e EstadoCuenta
+	f.facturar(&e, resumenes)
+	return e.ConsumoKwh, e.Costo
+}
+
+// contarAvisos cuenta los avisos por tipo, de más a menos frecuente, hasta
+// limite tipos (0 para todos).
+func contarAvisos(avisos []AvisoOficina, catalogo map[string]TipoAviso, limite int) []ConteoAviso {
+	cantidades := make(map[string]int)
+	for _, a := range avisos {
+		cantidades[a.IDTipo]++
+	}
+	return ordenarAvisos(cantidades, catalogo, limite)
+}
+
+func ordenarAvisos(cantidades map[string]int, catalogo map[string]TipoAviso, limite int) []ConteoAviso {
+	conteos := make([]ConteoAviso, 0, len(cantidades))
+	for id, n := range cantidades {
+		motivo := catalogo[id].Motivo
+		if motivo == "" {
+			motivo = "Tipo " + id
+		}
+		conteos = append(conteos, ConteoAviso{IDTipo: id, Motivo: motivo, Cantidad: n})
+	}
+	sort.Slice(conteos, func(i, j int) bool {
+		if conteos[i].Cantidad != conteos[j].Cantidad {
+			return conteos[i].Cantidad > conteos[j].Cantidad
+		}
+		return conteos[i].IDTipo < conteos[j].IDTipo
+	})
+	if limite > 0 && len(conteos) > limite {
+		conteos = conteos[:limite]
+	}
+	return conteos
+}
+
+// sumarGrupos agrupa los estados de cuenta de las oficinas según grupo (el
+// sector o el edificio). La demanda pico del grupo es la suma de sus
+// oficinas en el mismo minuto.
+func sumarGrupos(estados []EstadoCuenta, grupo func(EstadoCuenta) string, catalogo map[string]TipoAviso) []EstadoCuenta {
+	grupos := make(map[string]*EstadoCuenta)
+	avisos := make(map[string]map[string]int)
+	for _, e := range estados {
+		nombre := grupo(e)
+		s := grupos[nombre]
+		if s == nil {
+			s = &EstadoCuenta{Nombre: nombre, demanda: make(map[int64]float64)}
+			s.Periodos = make([]ConsumoPeriodo, len(e.Periodos))
+			for i, p := range e.Periodos {
+				s.Periodos[i].Nombre = p.Nombre
+			}
+			grupos[nombre] = s
+			avisos[nombre] = make(map[string]int)
+		}
+		s.ConsumoKwh += e.ConsumoKwh
+		s.Costo += e.Costo
+		for i, p := range e.Periodos {
+			s.Periodos[i].ConsumoKwh += p.ConsumoKwh
+			s.Periodos[i].Costo += p.Costo
+		}
+		s.HorasPresencia += e.HorasPresencia
+		s.ConsumoAnteriorKwh += e.ConsumoAnteriorKwh
+		s.CostoAnterior += e.CostoAnterior
+		for minuto, kw := range e.demanda {
+			s.demanda[minuto] += kw
+		}
+		for _, a := range e.Avisos {
+			avisos[nombre][a.IDTipo] += a.Cantidad
+		}
+	}
+
+	lista := make([]EstadoCuenta, 0, len(grupos))
+	for nombre, s := range grupos {
+		for minuto, kw := range s.demanda {
+			if kw > s.DemandaPicoKw {
+				s.DemandaPicoKw = kw
+				s.InstantePico = minuto * 60
+			}
+		}
+		s.Avisos = ordenarAvisos(avisos[nombre], catalogo, avisosEnInforme)
+		lista = append(lista, *s)
+	}
+	sort.Slice(lista, func(i, j int) bool { return lista[i].Nombre < lista[j].Nombre })
+	return lista
+}
+
+// comandoInforme genera los estados de cuenta mensuales por oficina, por
+// edificio y por sector, en CSV y HTML, a partir de los resúmenes guardados:
+//
+//	go run . informe -mes 2025-03 -tarifas ../../config/tarifas.ejemplo.json
+func comandoInforme(args []string) error {
+	fs := flag.NewFlagSet("informe", flag.ContinueOnError)
+	hoy := time.Now()
+	mesAnterior := time.Date(hoy.Year(), hoy.Month()-1, 1, 0, 0, 0, 0, time.Local)
+	mesTxt := fs.String("mes", mesAnterior.Format(formatoMes), "mes del informe (AAAA-MM)")
+	listaOficinas := fs.String("oficina", "", "oficinas separadas por comas (por defecto, todas)")
+	rutaTarifas := fs.String("tarifas", "", "JSON con los períodos tarifarios (por defecto, solo costo_kwh)")
+	salida := fs.String("salida", "../../data/informes", "directorio donde dejar los informes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	mes, err := time.ParseInLocation(formatoMes, *mesTxt, time.Local)
+	if err != nil {
+		return fmt.Errorf("mes inválido: %s", *mesTxt)
+	}
+	desde, hasta := mes.Unix(), mes.AddDate(0, 1, 0).Unix()
+	desdeAnterior := mes.AddDate(0, -1, 0).Unix()
+
+	var periodos []PeriodoTarifa
+	if *rutaTarifas != "" {
+		datos, err := os.ReadFile(*rutaTarifas)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(datos, &periodos); err != nil {
+			return fmt.Errorf("%s: %v", *rutaTarifas, err)
+		}
+		if err := validarTarifas(periodos); err != nil {
+			return fmt.Errorf("%s: %v", *rutaTarifas, err)
+		}
+	}
+
+	if err := cfg.ValidarFirebase(); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := conectarFirebase(ctx); err != nil {
+		return err
+	}
+	params, err := leerParametros(ctx, "")
+	if err != nil {
+		return err
+	}
+	f := facturador{periodos: periodos, params: params}
+
+	var raw json.RawMessage
+	if err := clienteFirebase.NewRef(rutaFirebase("tipos_avisos")).Get(ctx, &raw); err != nil {
+		return fmt.Errorf("error leyendo tipos de avisos: %v", err)
+	}
+	catalogo, err := decodificarTiposAvisos(raw)
+	if err != nil {
+		return err
+	}
+
+	var lista []string
+	if *listaOficinas != "" {
+		lista = strings.Split(*listaOficinas, ",")
+	} else {
+		var ids map[string]interface{}
+		if err := clienteFirebase.NewRef(rutaFirebase("oficinas")).GetShallow(ctx, &ids); err != nil {
+			return fmt.Errorf("error leyendo oficinas: %v", err)
+		}
+		for id := range ids {
+			lista = append(lista, id)
+		}
+		sort.Strings(lista)
+	}
+	jerarquia, err := leerJerarquia(ctx, lista)
+	if err != nil {
+		return err
+	}
+
+	estados := make([]EstadoCuenta, 0, len(lista))
+	for _, oficina := range lista {
+		if !oficinaValida(oficina) {
+			return fmt.Errorf("oficina inválida: %q", oficina)
+		}
+		e, err := estadoCuentaOficina(ctx, f, oficina, jerarquia[oficina], desdeAnterior, desde, hasta, catalogo)
+		if err != nil {
+			return err
+		}
+		estados = append(estados, e)
+	}
+	edificios := sumarGrupos(estados, func(e EstadoCuenta) string { return e.Edificio }, catalogo)
+	sectores := sumarGrupos(estados, func(e EstadoCuenta) string { return e.Sector }, catalogo)
+
+	dir := filepath.Join(*salida, *mesTxt)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	archivos := map[string][]EstadoCuenta{"oficinas.csv": estados, "edificios.csv": edificios, "sectores.csv": sectores}
+	for nombre, filas := range archivos {
+		if err := escribirInformeCSV(filepath.Join(dir, nombre), f.nombres(), filas); err != nil {
+			return err
+		}
+	}
+	rutaHTML := filepath.Join(dir, "informe.html")
+	if err := escribirInformeHTML(rutaHTML, *mesTxt, f.nombres(), estados, edificios, sectores); err != nil {
+		return err
+	}
+	fmt.Printf("📄 Informe de %s: %d oficinas, %d edificios y %d sectores en %s\n",
+		*mesTxt, len(estados), len(edificios), len(sectores), dir)
+	return nil
+}
+
+// estadoCuentaOficina arma el estado de cuenta de una oficina.
+func estadoCuentaOficina(ctx context.Context, f facturador, oficina string, u Ubicacion, desdeAnterior, desde, hasta int64, catalogo map[string]TipoAviso) (EstadoCuenta, error) {
+	e := EstadoCuenta{Oficina: oficina, Nombre: oficina}
+	var nombre string
+	ref := clienteFirebase.NewRef(rutaFirebase("oficinas/") + oficina)
+	if err := ref.Child("nombre").Get(ctx, &nombre); err != nil {
+		return e, fmt.Errorf("error leyendo la oficina %s: %v", oficina, err)
+	}
+	if nombre != "" {
+		e.Nombre = nombre
+	}
+	u = u.completar()
+	e.Sector = u.Sector
+	e.Edificio = u.Sitio + " / " + u.Edificio
+
+	resumenes, err := leerResumenes(ctx, oficina, desde, hasta-1)
+	if err != nil {
+		return e, err
+	}
+	f.facturar(&e, resumenes)
+
+	anteriores, err := leerResumenes(ctx, oficina, desdeAnterior, desde-1)
+	if err != nil {
+		return e, err
+	}
+	e.ConsumoAnteriorKwh, e.CostoAnterior = f.costo(anteriores)
+
+	avisos, err := leerAvisos(ctx, oficina, desde, hasta-1)
+	if err != nil {
+		return e, err
+	}
+	e.Avisos = contarAvisos(avisos, catalogo, avisosEnInforme)
+	return e, nil
+}
+
+func escribirInformeCSV(ruta string, periodos []string, estados []EstadoCuenta) error {
+	f, err := os.Create(ruta)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+
+	encabezado := []string{"oficina", "nombre", "edificio", "sector", "consumo_kwh", "costo"}
+	for _, p := range periodos {
+		encabezado = append(encabezado, "kwh_"+p, "costo_"+p)
+	}
+	encabezado = append(encabezado, "demanda_pico_kw", "instante_pico", "horas_presencia",
+		"avisos", "consumo_anterior_kwh", "costo_anterior", "variacion_pct")
+	w.Write(encabezado)
+
+	for _, e := range estados {
+		fila := []string{e.Oficina, e.Nombre, e.Edificio, e.Sector, decimal(e.ConsumoKwh), decimal(e.Costo)}
+		for _, p := range e.Periodos {
+			fila = append(fila, decimal(p.ConsumoKwh), decimal(p.Costo))
+		}
+		var avisos []string
+		for _, a := range e.Avisos {
+			avisos = append(avisos, fmt.Sprintf("%s (%d)", a.Motivo, a.Cantidad))
+		}
+		pico := ""
+		if e.InstantePico > 0 {
+			pico = time.Unix(e.InstantePico, 0).Format(time.RFC3339)
+		}
+		variacion := ""
+		if v := e.Variacion(); !math.IsNaN(v) {
+			variacion = fmt.Sprintf("%.1f", v)
+		}
+		fila = append(fila, decimal(e.DemandaPicoKw), pico, fmt.Sprintf("%.1f", e.HorasPresencia),
+			strings.Join(avisos, "; "), decimal(e.ConsumoAnteriorKwh), decimal(e.CostoAnterior), variacion)
+		w.Write(fila)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func decimal(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+var plantillaInforme = template.Must(template.New("informe").Funcs(template.FuncMap{
+	"decimal": decimal,
+	"variacion": func(e EstadoCuenta) string {
+		if v := e.Variacion(); !math.IsNaN(v) {
+			return fmt.Sprintf("%+.1f %%", v)
+		}
+		return "—"
+	},
+	"instante": func(t int64) string {
+		if t == 0 {
+			return "—"
+		}
+		return time.Unix(t, 0).Format("02/01 15:04")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="es">
+<head>
+<meta charset="utf-8">
+<title>Estado de cuenta {{.Mes}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: right; }
+th:first-child, td:first-child, td.texto { text-align: left; }
+th { background: #f0f0f0; }
+ul { margin: 0; padding-left: 1.2em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Estado de cuenta {{.Mes}}</h1>
+{{define "tabla"}}
+<table>
+<tr>
+<th>{{.Titulo}}</th><th>Consumo (kWh)</th>{{range .Periodos}}<th>{{.}} (kWh)</th><th>{{.}} ($)</th>{{end}}<th>Costo ($)</th>
+<th>Demanda pico (kW)</th><th>Horas de presencia</th><th>Avisos principales</th><th>Mes anterior (kWh)</th><th>Variación</th>
+</tr>
+{{range .Filas}}
+<tr>
+<td>{{.Nombre}}{{if .Oficina}} <small>({{.Edificio}}, {{.Sector}})</small>{{end}}</td>
+<td>{{decimal .ConsumoKwh}}</td>
+{{range .Periodos}}<td>{{decimal .ConsumoKwh}}</td><td>{{decimal .Costo}}</td>{{end}}
+<td>{{decimal .Costo}}</td>
+<td>{{decimal .DemandaPicoKw}} <small>{{instante .InstantePico}}</small></td>
+<td>{{printf "%.1f" .HorasPresencia}}</td>
+<td class="texto">{{if .Avisos}}<ul>{{range .Avisos}}<li>{{.Motivo}} ({{.Cantidad}})</li>{{end}}</ul>{{else}}—{{end}}</td>
+<td>{{decimal .ConsumoAnteriorKwh}}</td>
+<td>{{variacion .}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+<h2>Sectores</h2>
+{{template "tabla" .Sectores}}
+<h2>Edificios</h2>
+{{template "tabla" .Edificios}}
+<h2>Oficinas</h2>
+{{template "tabla" .Oficinas}}
+<p><small>Generado el {{.Generado}} a partir de los resúmenes guardados.</small></p>
+</body>
+</html>
+`))
+
+type tablaInforme struct {
+	Titulo   string
+	Periodos []string
+	Filas    []EstadoCuenta
+}
+
+func escribirInformeHTML(ruta, mes string, periodos []string, oficinas, edificios, sectores []EstadoCuenta) error {
+	f, err := os.Create(ruta)
+	if err != nil {
+		return err
+	}
+	err = plantillaInforme.Execute(f, struct {
+		Mes                           string
+		Generado                      string
+		Sectores, Edificios, Oficinas tablaInforme
+	}{
+		Mes:       mes,
+		Generado:  time.Now().Format("2006-01-02 15:04"),
+		Sectores:  tablaInforme{"Sector", periodos, sectores},
+		Edificios: tablaInforme{"Edificio", periodos, edificios},
+		Oficinas:  tablaInforme{"Oficina", periodos, oficinas},
+	})
+	if err != nil {
+		f.Close()
+		return errors.Join(err, os.Remove(ruta))
+	}
+	return f.Close()
+}
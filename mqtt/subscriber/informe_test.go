@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeriodoTarifaIncluye(t *testing.T) {
+	// El 1 de diciembre de 2025 es lunes.
+	a := func(dia, hora, minuto int) time.Time { return time.Date(2025, 12, dia, hora, minuto, 0, 0, time.Local) }
+	punta := PeriodoTarifa{Nombre: "Punta", Desde: 18, Hasta: 22, Dias: []int{1, 2, 3, 4, 5}}
+	valle := PeriodoTarifa{Nombre: "Valle", Desde: 23, Hasta: 7}
+	media := PeriodoTarifa{Nombre: "Media", Desde: 7.5, Hasta: 18.5}
+	casos := []struct {
+		nombre   string
+		periodo  PeriodoTarifa
+		instante time.Time
+		incluye  bool
+	}{
+		{"punta, al empezar", punta, a(1, 18, 0), true},
+		{"punta, último minuto", punta, a(1, 21, 59), true},
+		{"punta, al terminar", punta, a(1, 22, 0), false},
+		{"punta, antes", punta, a(1, 17, 59), false},
+		{"punta, sábado", punta, a(6, 19, 0), false},
+		{"punta, domingo", punta, a(7, 19, 0), false},
+		{"punta, viernes", punta, a(5, 19, 0), true},
+		{"valle, antes de medianoche", valle, a(1, 23, 30), true},
+		{"valle, a medianoche", valle, a(2, 0, 0), true},
+		{"valle, después de medianoche", valle, a(2, 6, 59), true},
+		{"valle, al terminar", valle, a(2, 7, 0), false},
+		{"valle, antes de empezar", valle, a(1, 22, 59), false},
+		{"valle, mediodía", valle, a(1, 12, 0), false},
+		{"media hora, antes", media, a(1, 7, 29), false},
+		{"media hora, al empezar", media, a(1, 7, 30), true},
+		{"media hora, último minuto", media, a(1, 18, 29), true},
+		{"media hora, al terminar", media, a(1, 18, 30), false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if incluye := c.periodo.incluye(c.instante); incluye != c.incluye {
+				t.Errorf("%s.incluye(%s) = %v; se esperaba %v", c.periodo.Nombre, c.instante.Format("Mon 15:04"), incluye, c.incluye)
+			}
+		})
+	}
+}
+
+func TestValidarTarifas(t *testing.T) {
+	valle := PeriodoTarifa{Nombre: "Valle", Desde: 23, Hasta: 7, CostoKwh: 0.12}
+	casos := []struct {
+		nombre   string
+		periodos []PeriodoTarifa
+		error    string
+	}{
+		{"sin períodos", nil, ""},
+		{"cruza la medianoche", []PeriodoTarifa{valle}, ""},
+		{"todo el día", []PeriodoTarifa{{Nombre: "Plana", Desde: 0, Hasta: 24, CostoKwh: 0.2}}, ""},
+		{"con días", []PeriodoTarifa{{Nombre: "Finde", Desde: 8, Hasta: 20, Dias: []int{0, 6}}}, ""},
+		{"sin nombre", []PeriodoTarifa{{Desde: 8, Hasta: 20}}, "sin nombre"},
+		{"repetido", []PeriodoTarifa{valle, valle}, "repetido"},
+		{"se llama General", []PeriodoTarifa{{Nombre: "General", Desde: 8, Hasta: 20}}, "repetido"},
+		{"desde negativo", []PeriodoTarifa{{Nombre: "X", Desde: -1, Hasta: 20}}, "entre 0 y 24"},
+		{"hasta mayor que 24", []PeriodoTarifa{{Nombre: "X", Desde: 8, Hasta: 25}}, "entre 0 y 24"},
+		{"desde 24", []PeriodoTarifa{{Nombre: "X", Desde: 24, Hasta: 6}}, "entre 0 y 24"},
+		{"hasta 0", []PeriodoTarifa{{Nombre: "X", Desde: 22, Hasta: 0}}, "entre 0 y 24"},
+		{"desde igual a hasta", []PeriodoTarifa{{Nombre: "X", Desde: 8, Hasta: 8}}, "entre 0 y 24"},
+		{"costo negativo", []PeriodoTarifa{{Nombre: "X", Desde: 8, Hasta: 20, CostoKwh: -0.1}}, "costo_kwh"},
+		{"día inválido", []PeriodoTarifa{{Nombre: "X", Desde: 8, Hasta: 20, Dias: []int{7}}}, "día 7"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			err := validarTarifas(c.periodos)
+			if c.error == "" && err != nil {
+				t.Errorf("validarTarifas: %v", err)
+			}
+			if c.error != "" && (err == nil || !strings.Contains(err.Error(), c.error)) {
+				t.Errorf("validarTarifas: %v; se esperaba un error con %q", err, c.error)
+			}
+		})
+	}
+}
+
+func TestFacturar(t *testing.T) {
+	f := facturador{
+		periodos: []PeriodoTarifa{{Nombre: "Valle", Desde: 23, Hasta: 7, CostoKwh: 0.1}},
+		params:   ParametrosConfig{Voltaje: 220, CostoKwh: 0.3},
+	}
+	// Ventanas de un minuto de 0,0036 kWh de 22:00 a 00:59: cada consumo_kvh
+	// redondeado es 0.
+	inicio := time.Date(2025, 12, 1, 22, 0, 0, 0, time.Local)
+	resumenes := resumenesDePrueba(inicio, time.Minute, 180, 0.0036)
+	for i := range resumenes {
+		resumenes[i].TiempoPresente = 30
+	}
+	resumenes[100].CorrienteA = 20
+
+	casos := []struct {
+		nombre    string
+		resumenes []Resumen
+		general   float64
+		valle     float64
+	}{
+		{"sin resúmenes", nil, 0, 0},
+		{"solo general", resumenes[:60], 0.216, 0},
+		{"cruza al valle a las 23", resumenes, 0.216, 0.432},
+		{"solo valle", resumenes[60:], 0, 0.432},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			var e EstadoCuenta
+			f.facturar(&e, c.resumenes)
+			if len(e.Periodos) != 2 || e.Periodos[0].Nombre != "Valle" || e.Periodos[1].Nombre != "General" {
+				t.Fatalf("períodos: %+v", e.Periodos)
+			}
+			cerca := func(a, b float64) bool { return math.Abs(a-b) <= 0.011 }
+			valle, general := e.Periodos[0], e.Periodos[1]
+			if !cerca(valle.ConsumoKwh, c.valle) || !cerca(general.ConsumoKwh, c.general) {
+				t.Errorf("consumo: valle %v y general %v; se esperaban %v y %v", valle.ConsumoKwh, general.ConsumoKwh, c.valle, c.general)
+			}
+			if !cerca(valle.Costo, valle.ConsumoKwh*0.1) || !cerca(general.Costo, general.ConsumoKwh*0.3) {
+				t.Errorf("costo: valle %v y general %v", valle.Costo, general.Costo)
+			}
+			if math.Abs(e.ConsumoKwh-valle.ConsumoKwh-general.ConsumoKwh) > 1e-9 || math.Abs(e.Costo-valle.Costo-general.Costo) > 1e-9 {
+				t.Errorf("total %v kWh y %v; no es la suma de los períodos", e.ConsumoKwh, e.Costo)
+			}
+			if e.HorasPresencia != float64(30*len(c.resumenes))/3600 {
+				t.Errorf("horas de presencia: %v", e.HorasPresencia)
+			}
+		})
+	}
+
+	var e EstadoCuenta
+	f.facturar(&e, resumenes)
+	if e.DemandaPicoKw != 4.4 || e.InstantePico != resumenes[100].Timestamp {
+		t.Errorf("pico de %v kW en %d; se esperaban 4,4 kW en %d", e.DemandaPicoKw, e.InstantePico, resumenes[100].Timestamp)
+	}
+	if consumo, costo := f.costo(resumenes); consumo != e.ConsumoKwh || costo != e.Costo {
+		t.Errorf("costo: %v kWh y %v; se esperaban %v y %v", consumo, costo, e.ConsumoKwh, e.Costo)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+const (
+	colaOficinaPorDefecto = 256
+	capacidadEscrituras   = 1024
+	maxLoteEscrituras     = 500
+	// Tiempo que el escritor junta escrituras antes de enviar el lote.
+	ventanaEscrituras = 200 * time.Millisecond
+)
+
+// escritura es un dato pendiente de guardar en Firebase. Con agregar se
+// hace Push bajo ruta; si no, Set. alGuardar corre solo si se guardó.
+type escritura struct {
+	operacion string
+	ruta      string
+	valor     interface{}
+	agregar   bool
+	alGuardar func()
+}
+
+// colaOficina son las lecturas pendientes de una oficina. listo se cierra
+// cuando su trabajador termina.
+type colaOficina struct {
+	lecturas chan DatosSensor
+	listo    chan struct{}
+	// descartar se activa al quitar la oficina: el trabajador tira lo que le
+	// queda en lugar de procesarlo.
+	descartar atomic.Bool
+}
+
+// Ingesta desacopla la recepción MQTT del procesamiento. El callback de paho
+// solo decodifica y encola; cada oficina tiene una goroutine que acumula su
+// ventana y detecta avisos, y un único escritor guarda en Firebase por lotes.
+// Si una cola se llena, la lectura se descarta en lugar de frenar al router
+// de paho, y queda contada en las métricas.
+type Ingesta struct {
+	mu           sync.RWMutex
+	colas        map[string]*colaOficina
+	capacidad    int
+	retraso      time.Duration
+	desfaseMax   int64
+	reestampar   bool
+	cerrada      bool
+	trabajadores sync.WaitGroup
+
+	escrituras chan escritura
+	guardar    funcionGuardado
+	terminado  chan struct{}
+}
+
+// funcionGuardado guarda un lote y devuelve un error por escritura, nil para
+// las que se guardaron.
+type funcionGuardado func(ctx context.Context, lote []escritura) []error
+
+var ingesta *Ingesta
+
+func nuevaIngesta(c configuracion.Config, guardar funcionGuardado) *Ingesta {
+	capacidad := c.ColaOficina
+	if capacidad <= 0 {
+		capacidad = colaOficinaPorDefecto
+	}
+	return &Ingesta{
+		colas:      make(map[string]*colaOficina),
+		capacidad:  capacidad,
+		retraso:    time.Duration(c.RetrasoMax),
+		desfaseMax: int64(time.Duration(c.DesfaseMax) / time.Second),
+		reestampar: c.Reestampar,
+		escrituras: make(chan escritura, capacidadEscrituras),
+		guardar:    guardar,
+		terminado:  make(chan struct{}),
+	}
+}
+
+// iniciar arranca el escritor. ctx se usa para las escrituras, no para
+// detenerlo: eso lo hace cerrar.
+func (in *Ingesta) iniciar(ctx context.Context) {
+	go in.escribirLotes(ctx)
+}
+
+// Encolar entrega la lectura al trabajador de su oficina sin bloquear.
+// Devuelve false si se descartó.
+func (in *Ingesta) Encolar(datos DatosSensor) bool {
+	in.mu.RLock()
+	cola, existe := in.colas[datos.Oficina]
+	if existe {
+		defer in.mu.RUnlock()
+		return in.enviar(cola, datos)
+	}
+	in.mu.RUnlock()
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.cerrada {
+		return false
+	}
+	cola, existe = in.colas[datos.Oficina]
+	if !existe {
+		cola = &colaOficina{lecturas: make(chan DatosSensor, in.capacidad), listo: make(chan struct{})}
+		in.colas[datos.Oficina] = cola
+		in.trabajadores.Add(1)
+		metricaTrabajadores.Inc()
+		go in.trabajar(cola)
+	}
+	return in.enviar(cola, datos)
+}
+
+// enviar se llama con in.mu tomado, así cerrar y quitar no pueden cerrar
+// la cola a la vez.
+func (in *Ingesta) enviar(cola *colaOficina, datos DatosSensor) bool {
+	if in.cerrada {
+		return false
+	}
+	select {
+	case cola.lecturas <- datos:
+		metricaColaLecturas.Inc()
+		return true
+	default:
+		metricaLecturasDescartadas.Inc()
+		return false
+	}
+}
+
+// trabajar procesa las lecturas de una oficina en orden de timestamp. Si la
+// oficina deja de enviar durante retraso, se procesan las retenidas.
+func (in *Ingesta) trabajar(cola *colaOficina) {
+	defer in.trabajadores.Done()
+	defer metricaTrabajadores.Dec()
+	defer close(cola.listo)
+
+	orden := nuevoOrden(in.retraso)
+	reloj := &estimadorDesfase{}
+	var inactividad <-chan time.Time
+	var temporizador *time.Timer
+	if in.retraso > 0 {
+		temporizador = time.NewTimer(in.retraso)
+		defer temporizador.Stop()
+		inactividad = temporizador.C
+	}
+
+	for {
+		select {
+		case datos, ok := <-cola.lecturas:
+			if !ok {
+				if cola.descartar.Load() {
+					return
+				}
+				for _, d := range orden.vaciar() {
+					procesarLectura(d)
+				}
+				return
+			}
+			metricaColaLecturas.Dec()
+			if cola.descartar.Load() {
+				continue
+			}
+			datos = in.corregirReloj(reloj, datos)
+			listas, tardias := orden.agregar(datos)
+			for _, d := range listas {
+				procesarLectura(d)
+			}
+			for _, d := range tardias {
+				procesarLecturaTardia(d)
+			}
+			if temporizador != nil {
+				temporizador.Reset(in.retraso)
+			}
+		case <-inactividad:
+			for _, d := range orden.vaciar() {
+				procesarLectura(d)
+			}
+		}
+	}
+}
+
+// quitar detiene el trabajador de una oficina eliminada, descartando lo que
+// tenía en cola, y espera a que termine y a que se guarde lo que ya había
+// pasado al escritor. Después de quitar no queda nada en camino que vuelva
+// a crear el estado o los datos de la oficina.
+func (in *Ingesta) quitar(oficina string) {
+	in.mu.Lock()
+	cola, existe := in.colas[oficina]
+	if existe {
+		cola.descartar.Store(true)
+		close(cola.lecturas)
+		delete(in.colas, oficina)
+	}
+	in.mu.Unlock()
+	if !existe {
+		return
+	}
+	<-cola.listo
+	in.esperarEscrituras()
+}
+
+// esperarEscrituras vuelve cuando el escritor terminó con todo lo encolado
+// hasta ahora, se haya guardado o no. No se puede llamar desde alGuardar.
+func (in *Ingesta) esperarEscrituras() {
+	listo := make(chan struct{})
+	marca := escritura{alGuardar: func() { close(listo) }}
+	// Sin bloquear con in.mu tomado: si la cola está llena se reintenta.
+	for !in.intentarEscribir(marca) {
+		in.mu.RLock()
+		cerrada := in.cerrada
+		in.mu.RUnlock()
+		if cerrada {
+			return
+		}
+		time.Sleep(ventanaEscrituras / 10)
+	}
+	<-listo
+}
+
+// escribir pasa una escritura al escritor. Bloquea si su cola está llena:
+// la presión llega así a las colas por oficina.
+func (in *Ingesta) escribir(e escritura) {
+	metricaColaEscrituras.Inc()
+	in.escrituras <- e
+}
+
+// intentarEscribir es escribir sin bloquear, para usar desde el callback de
+// paho. Devuelve false si la cola estaba llena o la ingesta cerrada.
+func (in *Ingesta) intentarEscribir(e escritura) bool {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	if in.cerrada {
+		return false
+	}
+	select {
+	case in.escrituras <- e:
+		metricaColaEscrituras.Inc()
+		return true
+	default:
+		return false
+	}
+}
+
+// escribirLotes junta las escrituras que llegan durante ventanaEscrituras
+// y las guarda juntas.
+func (in *Ingesta) escribirLotes(ctx context.Context) {
+	defer close(in.terminado)
+	for e := range in.escrituras {
+		lote := []escritura{e}
+		ventana := time.NewTimer(ventanaEscrituras)
+	juntar:
+		for len(lote) < maxLoteEscrituras {
+			select {
+			case e, ok := <-in.escrituras:
+				if !ok {
+					break juntar
+				}
+				lote = append(lote, e)
+			case <-ventana.C:
+				break juntar
+			}
+		}
+		ventana.Stop()
+		metricaColaEscrituras.Sub(float64(len(lote)))
+		in.guardarLote(ctx, lote)
+	}
+}
+
+// guardarLote guarda las escrituras y avisa de cada una que falle. Los Set a
+// una misma ruta se reducen al último, que es el único que queda en la base.
+func (in *Ingesta) guardarLote(ctx context.Context, lote []escritura) {
+	ultimo := make(map[string]int)
+	for i, e := range lote {
+		if !e.agregar {
+			ultimo[e.ruta] = i
+		}
+	}
+	// Las escrituras sin ruta son marcas de esperarEscrituras.
+	var marcas []escritura
+	depurado := lote[:0:0]
+	for i, e := range lote {
+		switch {
+		case e.ruta == "":
+			marcas = append(marcas, e)
+		case e.agregar || ultimo[e.ruta] == i:
+			depurado = append(depurado, e)
+		}
+	}
+	defer func() {
+		for _, m := range marcas {
+			m.alGuardar()
+		}
+	}()
+	if len(depurado) == 0 {
+		return
+	}
+	metricaLoteEscrituras.Observe(float64(len(depurado)))
+
+	errs := in.guardar(ctx, depurado)
+	for i, e := range depurado {
+		if errs[i] != nil {
+			log.Printf("❌ Error guardando %s en %s: %v", e.operacion, e.ruta, errs[i])
+			continue
+		}
+		if e.alGuardar != nil {
+			e.alGuardar()
+		}
+	}
+}
+
+// cerrar deja de aceptar lecturas, espera a que los trabajadores vacíen sus
+// colas, guarda los resúmenes parciales y espera al escritor, todo dentro
+// del plazo de ctx.
+func (in *Ingesta) cerrar(ctx context.Context) {
+	in.mu.Lock()
+	in.cerrada = true
+	for oficina, cola := range in.colas {
+		close(cola.lecturas)
+		delete(in.colas, oficina)
+	}
+	in.mu.Unlock()
+
+	trabajadoresListos := make(chan struct{})
+	go func() {
+		in.trabajadores.Wait()
+		close(trabajadoresListos)
+	}()
+	select {
+	case <-trabajadoresListos:
+	case <-ctx.Done():
+		log.Println("⚠️  Plazo agotado esperando a los trabajadores de la ingesta")
+		return
+	}
+
+	cerrarVentanasPendientes()
+	close(in.escrituras)
+	select {
+	case <-in.terminado:
+	case <-ctx.Done():
+		log.Printf("⚠️  Plazo agotado con %d escrituras pendientes", len(in.escrituras))
+	}
+}
+
+// guardarEnFirebase envía el lote como una sola actualización multi-ruta.
+// Los Push se convierten en rutas con una clave generada localmente.
+func guardarEnFirebase(ctx context.Context, lote []escritura) []error {
+	errs := make([]error, len(lote))
+	rutas := make([]string, len(lote))
+	cambios := make(map[string]interface{}, len(lote))
+	for i, e := range lote {
+		// Un valor que no se puede serializar haría fallar el lote entero.
+		if _, err := json.Marshal(e.valor); err != nil {
+			errs[i] = fmt.Errorf("valor inválido: %v", err)
+			metricaErroresFirebase.WithLabelValues(e.operacion).Inc()
+			continue
+		}
+		rutas[i] = e.ruta
+		if e.agregar {
+			rutas[i] = e.ruta + "/" + clavePush()
+		}
+		cambios[rutas[i]] = e.valor
+	}
+	if len(cambios) == 0 {
+		return errs
+	}
+
+	raiz := clienteFirebase.NewRef("/")
+	err := medirFirebase("lote", func() error { return raiz.Update(ctx, cambios) })
+	if err == nil {
+		return errs
+	}
+	if !rechazoPorDatos(err) || len(cambios) == 1 {
+		for i, e := range lote {
+			if errs[i] == nil {
+				errs[i] = err
+				metricaErroresFirebase.WithLabelValues(e.operacion).Inc()
+			}
+		}
+		return errs
+	}
+
+	// Firebase rechazó el contenido del lote (reglas o datos): se reintenta
+	// de a una escritura para saber cuáles fallan.
+	for i, e := range lote {
+		if errs[i] != nil {
+			continue
+		}
+		errs[i] = medirFirebase(e.operacion, func() error {
+			return raiz.Update(ctx, map[string]interface{}{rutas[i]: e.valor})
+		})
+	}
+	return errs
+}
+
+// rechazoPorDatos distingue un 400/403 de Firebase, que depende de lo que se
+// escribe, de un error de red o del servidor, que afecta a todo el lote. El
+// SDK solo informa el código dentro del mensaje.
+func rechazoPorDatos(err error) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, "http error status: 400") || strings.HasPrefix(msg, "http error status: 403")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkIngesta mide lecturas por segundo de punta a punta (recepción,
+// ventana, avisos y escritor por lotes) con miles de oficinas y un guardado
+// que solo simula la latencia de Firebase. Para comparar entre commits:
+//
+//	go test ./mqtt/subscriber -run '^$' -bench Ingesta -count 5
+func BenchmarkIngesta(b *testing.B) {
+	for _, oficinas := range []int{1000, 5000} {
+		b.Run(fmt.Sprintf("oficinas=%d", oficinas), func(b *testing.B) {
+			benchmarkIngesta(b, oficinas, 2*time.Millisecond)
+		})
+	}
+}
+
+func benchmarkIngesta(b *testing.B, numOficinas int, latencia time.Duration) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	cfg = cfgPorDefecto
+	mu.Lock()
+	config = paramsPorDefecto
+	tiposAvisos = map[string]TipoAviso{avisoSensorNoResponde: {Motivo: "Sensor no responde"}}
+	mapaEstados = make(map[string]*EstadoOficina)
+	mu.Unlock()
+
+	var escrituras, llamadas atomic.Int64
+	ingesta = nuevaIngesta(cfg, func(_ context.Context, lote []escritura) []error {
+		llamadas.Add(1)
+		escrituras.Add(int64(len(lote)))
+		time.Sleep(latencia)
+		return make([]error, len(lote))
+	})
+	ingesta.iniciar(context.Background())
+
+	ids := make([]string, numOficinas)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("bench-%05d", i)
+	}
+	intervalo := int64(segundosPorLectura())
+	rondas := int64(b.N/numOficinas + 1)
+	base := time.Now().Unix() - rondas*intervalo
+
+	b.ResetTimer()
+	descartadas := 0
+	for i := 0; i < b.N; i++ {
+		ronda := int64(i / numOficinas)
+		datos := DatosSensor{
+			Oficina:     ids[i%numOficinas],
+			Timestamp:   base + ronda*intervalo,
+			Secuencia:   uint64(ronda + 1),
+			Presencia:   rand.Intn(2) == 0,
+			CorrienteA:  rand.Float64() * 25,
+			Temperatura: 18 + rand.Float64()*12,
+		}
+		if !ingesta.Encolar(datos) {
+			descartadas++
+		}
+	}
+	ctx, cancelar := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelar()
+	ingesta.cerrar(ctx)
+	b.StopTimer()
+
+	b.ReportMetric(float64(descartadas)/float64(b.N), "descartadas/op")
+	if n := llamadas.Load(); n > 0 {
+		b.ReportMetric(float64(escrituras.Load())/float64(n), "escrituras/lote")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+const (
+	esperaReinicioInicial = 5 * time.Second
+	esperaReinicioMaxima  = time.Minute
+)
+
+// InquilinoSupervisado es un subscriber que el supervisor mantiene en
+// marcha: el ejecutable actual con -config Config -inquilino ID y las
+// Opciones adicionales.
+type InquilinoSupervisado struct {
+	ID       string   `json:"id"`
+	Config   string   `json:"config,omitempty"`
+	Opciones []string `json:"opciones,omitempty"`
+	// plazoCierre es el plazo_cierre del hijo, que validarInquilinos lee
+	// de su configuración.
+	plazoCierre time.Duration
+}
+
+func (i InquilinoSupervisado) argumentos() []string {
+	// -inquilinos vacío evita que el proceso hijo, que hereda el entorno,
+	// se vuelva supervisor por MONITOREO_INQUILINOS.
+	args := []string{"-inquilino", i.ID, "-inquilinos="}
+	if i.Config != "" {
+		args = append(args, "-config", i.Config)
+	}
+	return append(args, i.Opciones...)
+}
+
+// ConfigInquilinos es el archivo de la opción inquilinos.
+type ConfigInquilinos struct {
+	Inquilinos []InquilinoSupervisado `json:"inquilinos"`
+}
+
+func cargarConfigInquilinos(ruta string) (ConfigInquilinos, error) {
+	var c ConfigInquilinos
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(datos, &c); err != nil {
+		return c, fmt.Errorf("%s: %v", ruta, err)
+	}
+	if len(c.Inquilinos) == 0 {
+		return c, fmt.Errorf("%s: no hay inquilinos", ruta)
+	}
+	return c, nil
+}
+
+// validarInquilinos carga la configuración de cada inquilino como lo hará
+// su proceso y rechaza los recursos que dos inquilinos no pueden compartir.
+// Un inquilino sin hub_ws lee sus datos de servidor_ws, que sirve un solo
+// inquilino, así que tampoco se puede compartir.
+func validarInquilinos(c ConfigInquilinos) error {
+	usados := make(map[string]string)
+	usar := func(id, recurso, valor string) error {
+		if valor == "" {
+			return nil
+		}
+		clave := recurso + "=" + valor
+		if otro, repetido := usados[clave]; repetido {
+			return fmt.Errorf("los inquilinos %s y %s usan el mismo %s %q", otro, id, recurso, valor)
+		}
+		usados[clave] = id
+		return nil
+	}
+
+	for n := range c.Inquilinos {
+		i := &c.Inquilinos[n]
+		if i.ID == "" {
+			return errors.New("hay un inquilino sin id")
+		}
+		if err := usar(i.ID, "id", i.ID); err != nil {
+			return err
+		}
+		ci, args, err := configuracion.Cargar("subscriber", cfgPorDefecto, i.argumentos())
+		if err != nil {
+			return fmt.Errorf("inquilino %s: %v", i.ID, err)
+		}
+		if len(args) > 0 {
+			return fmt.Errorf("inquilino %s: las opciones no pueden incluir comandos (%v)", i.ID, args)
+		}
+		i.plazoCierre = time.Duration(ci.PlazoCierre)
+		servidorWS := ""
+		if ci.HubWS == "" {
+			servidorWS = ci.ServidorWS
+		}
+		for _, r := range []struct{ recurso, valor string }{
+			{"cliente_id", ci.ClienteID},
+			{"hub_ws", ci.HubWS},
+			{"servidor_ws", servidorWS},
+			{"api", ci.API},
+			{"metricas", ci.Metricas},
+			{"almacen_mqtt", ci.AlmacenMQTT},
+			{"dead_letter", ci.DeadLetter},
+			{"archivo", ci.Archivo},
+		} {
+			if err := usar(i.ID, r.recurso, r.valor); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// supervisarInquilinos lanza un subscriber por inquilino y lo reinicia si
+// termina con error. Cada inquilino tiene su proceso, así que su estado y
+// sus fallas quedan aislados de los demás.
+func supervisarInquilinos(ruta string) error {
+	c, err := cargarConfigInquilinos(ruta)
+	if err != nil {
+		return err
+	}
+	if err := validarInquilinos(c); err != nil {
+		return err
+	}
+	ejecutable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	ctx, detener := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer detener()
+	var wg sync.WaitGroup
+	for _, i := range c.Inquilinos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			supervisarInquilino(ctx, ejecutable, i)
+		}()
+	}
+	log.Printf("🏢 Supervisando %d inquilinos", len(c.Inquilinos))
+	wg.Wait()
+	return nil
+}
+
+func supervisarInquilino(ctx context.Context, ejecutable string, i InquilinoSupervisado) {
+	espera := esperaReinicioInicial
+	for {
+		inicio := time.Now()
+		cmd := exec.CommandContext(ctx, ejecutable, i.argumentos()...)
+		salida := &salidaPrefijada{prefijo: "[" + i.ID + "] ", destino: os.Stderr}
+		cmd.Stdout, cmd.Stderr = salida, salida
+		// El hijo recibe SIGTERM para hacer su cierre ordenado; si se
+		// pasa de su plazo_cierre, se lo mata.
+		cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+		cmd.WaitDelay = i.plazoCierre + 5*time.Second
+
+		log.Printf("🏢 Iniciando el subscriber del inquilino %s", i.ID)
+		err := cmd.Run()
+		salida.vaciar()
+		if ctx.Err() != nil {
+			log.Printf("🏢 Subscriber del inquilino %s detenido", i.ID)
+			return
+		}
+		if err == nil {
+			log.Printf("🏢 El subscriber del inquilino %s terminó", i.ID)
+			return
+		}
+
+		if time.Since(inicio) > esperaReinicioMaxima {
+			espera = esperaReinicioInicial
+		}
+		log.Printf("❌ El subscriber del inquilino %s terminó: %v; se reinicia en %s", i.ID, err, espera)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(espera):
+		}
+		if espera *= 2; espera > esperaReinicioMaxima {
+			espera = esperaReinicioMaxima
+		}
+	}
+}
+
+// salidaPrefijada antepone el inquilino a cada línea de su proceso.
+type salidaPrefijada struct {
+	mu        sync.Mutex
+	prefijo   string
+	destino   *os.File
+	pendiente []byte
+}
+
+func (s *salidaPrefijada) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pendiente = append(s.pendiente, p...)
+	for {
+		fin := bytes.IndexByte(s.pendiente, '\n')
+		if fin < 0 {
+			break
+		}
+		s.destino.Write(append([]byte(s.prefijo), s.pendiente[:fin+1]...))
+		s.pendiente = s.pendiente[fin+1:]
+	}
+	return len(p), nil
+}
+
+func (s *salidaPrefijada) vaciar() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pendiente) > 0 {
+		s.destino.Write(append([]byte(s.prefijo), append(s.pendiente, '\n')...))
+		s.pendiente = nil
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidarInquilinos(t *testing.T) {
+	// Sin API ni métricas para probar solo lo que cambia cada caso.
+	inquilino := func(id string, opciones ...string) InquilinoSupervisado {
+		return InquilinoSupervisado{ID: id, Opciones: append([]string{"-cliente-id", "sub-" + id, "-api=", "-metricas="}, opciones...)}
+	}
+	casos := []struct {
+		nombre     string
+		inquilinos []InquilinoSupervisado
+		error      string
+	}{
+		{"uno sin hub_ws", []InquilinoSupervisado{inquilino("acme", "-hub-ws=")}, ""},
+		{"uno sin hub_ws y otro con", []InquilinoSupervisado{inquilino("acme", "-hub-ws="), inquilino("globex", "-hub-ws", ":8281")}, ""},
+		{"sin hub_ws con distinto servidor_ws", []InquilinoSupervisado{inquilino("acme", "-hub-ws=", "-servidor-ws", "ws-acme:8081"), inquilino("globex", "-hub-ws=", "-servidor-ws", "ws-globex:8081")}, ""},
+		{"sin hub_ws comparten servidor_ws", []InquilinoSupervisado{inquilino("acme", "-hub-ws="), inquilino("globex", "-hub-ws=")}, "servidor_ws"},
+		{"con hub_ws comparten servidor_ws", []InquilinoSupervisado{inquilino("acme", "-hub-ws", ":8181"), inquilino("globex", "-hub-ws", ":8281")}, ""},
+		{"repetido", []InquilinoSupervisado{inquilino("acme", "-hub-ws", ":8181"), {ID: "acme", Opciones: []string{"-hub-ws", ":8281"}}}, "mismo id"},
+		{"comparten hub_ws", []InquilinoSupervisado{inquilino("acme", "-hub-ws", ":8181"), inquilino("globex", "-hub-ws", ":8181")}, "hub_ws"},
+		{"con un comando", []InquilinoSupervisado{inquilino("acme", "backfill")}, "comandos"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			err := validarInquilinos(ConfigInquilinos{Inquilinos: c.inquilinos})
+			if c.error == "" && err != nil {
+				t.Errorf("validarInquilinos: %v", err)
+			}
+			if c.error != "" && (err == nil || !strings.Contains(err.Error(), c.error)) {
+				t.Errorf("validarInquilinos: %v; se esperaba un error con %q", err, c.error)
+			}
+		})
+	}
+}
+
+func TestValidarInquilinosPlazoCierre(t *testing.T) {
+	c := ConfigInquilinos{Inquilinos: []InquilinoSupervisado{
+		{ID: "acme", Config: "../../config/inquilinos/acme.ejemplo.json", Opciones: []string{"-plazo-cierre", "45s"}},
+		{ID: "globex", Config: "../../config/inquilinos/globex.ejemplo.json"},
+	}}
+	if err := validarInquilinos(c); err != nil {
+		t.Fatal(err)
+	}
+	if p := c.Inquilinos[0].plazoCierre; p != 45*time.Second {
+		t.Errorf("plazo de acme: %s; se esperaba 45s", p)
+	}
+	if p := c.Inquilinos[1].plazoCierre; p != time.Duration(cfgPorDefecto.PlazoCierre) {
+		t.Errorf("plazo de globex: %s; se esperaba el de cfgPorDefecto", p)
+	}
+}
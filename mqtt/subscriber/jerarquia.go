@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	intervaloJerarquia = 5 * time.Minute
+	sinAsignar         = "Sin asignar"
+)
+
+// Ubicacion ubica una oficina en sitio → edificio → piso, más el sector al
+// que pertenece. Se guarda en monitoreo_consumo/jerarquia/oficinas/<id>.
+type Ubicacion struct {
+	Sitio    string `json:"sitio"`
+	Edificio string `json:"edificio"`
+	Piso     string `json:"piso"`
+	Sector   string `json:"sector"`
+}
+
+// completar reemplaza los niveles vacíos por sinAsignar.
+func (u Ubicacion) completar() Ubicacion {
+	for _, campo := range []*string{&u.Sitio, &u.Edificio, &u.Piso, &u.Sector} {
+		if *campo == "" {
+			*campo = sinAsignar
+		}
+	}
+	return u
+}
+
+// normalizar quita los espacios de los extremos de cada nivel, que harían
+// de "Norte" y "Norte " dos edificios distintos.
+func (u Ubicacion) normalizar() Ubicacion {
+	for _, campo := range []*string{&u.Sitio, &u.Edificio, &u.Piso, &u.Sector} {
+		*campo = strings.TrimSpace(*campo)
+	}
+	return u
+}
+
+// validar rechaza un nivel sin el que lo contiene, como un piso sin
+// edificio: no hay dónde ubicarlo en el árbol.
+func (u Ubicacion) validar() error {
+	niveles := []struct{ nombre, valor string }{{"sitio", u.Sitio}, {"edificio", u.Edificio}, {"piso", u.Piso}}
+	for i := 1; i < len(niveles); i++ {
+		if niveles[i].valor != "" && niveles[i-1].valor == "" {
+			return fmt.Errorf("%s %q sin %s", niveles[i].nombre, niveles[i].valor, niveles[i-1].nombre)
+		}
+	}
+	return nil
+}
+
+var ubicaciones = make(map[string]Ubicacion)
+
+// ubicacionDe devuelve la ubicación de la oficina, con sinAsignar en los
+// niveles que no estén cargados.
+func ubicacionDe(oficina string) Ubicacion {
+	mu.RLock()
+	defer mu.RUnlock()
+	return ubicaciones[oficina].completar()
+}
+
+// leerJerarquia lee de Firebase la ubicación de todas las oficinas con una
+// sola consulta. De una ubicación inválida solo se conserva el sector. A las
+// oficinas de la lista que la jerarquía no asigna a un sector les completa
+// el campo sector de la propia oficina.
+func leerJerarquia(ctx context.Context, lista []string) (map[string]Ubicacion, error) {
+	var leidas map[string]Ubicacion
+	if err := clienteFirebase.NewRef(rutaFirebase("jerarquia/oficinas")).Get(ctx, &leidas); err != nil {
+		return nil, fmt.Errorf("error leyendo jerarquía: %v", err)
+	}
+	if leidas == nil {
+		leidas = make(map[string]Ubicacion)
+	}
+	for oficina, u := range leidas {
+		u = u.normalizar()
+		if err := u.validar(); err != nil {
+			log.Printf("⚠️  Ubicación de %s inválida (%v); queda sin asignar", oficina, err)
+			u = Ubicacion{Sector: u.Sector}
+		}
+		leidas[oficina] = u
+	}
+	for _, oficina := range lista {
+		u := leidas[oficina]
+		if u.Sector != "" {
+			continue
+		}
+		if err := clienteFirebase.NewRef(rutaFirebase("oficinas/%s/sector", oficina)).Get(ctx, &u.Sector); err != nil {
+			return nil, fmt.Errorf("error leyendo el sector de %s: %v", oficina, err)
+		}
+		u.Sector = strings.TrimSpace(u.Sector)
+		if u != (Ubicacion{}) {
+			leidas[oficina] = u
+		}
+	}
+	return leidas, nil
+}
+
+func cargarJerarquia(ctx context.Context) error {
+	// La lista de oficinas en memoria puede no haber llegado todavía al
+	// iniciar, así que se lee de Firebase.
+	var ids map[string]interface{}
+	if err := clienteFirebase.NewRef(rutaFirebase("oficinas")).GetShallow(ctx, &ids); err != nil {
+		return fmt.Errorf("error leyendo oficinas: %v", err)
+	}
+	lista := make([]string, 0, len(ids))
+	for id := range ids {
+		lista = append(lista, id)
+	}
+	leidas, err := leerJerarquia(ctx, lista)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	ubicaciones = leidas
+	mu.Unlock()
+	return nil
+}
+
+// refrescarJerarquia vuelve a leer la jerarquía periódicamente: se edita en
+// Firebase y no hay aviso de cambios.
+func refrescarJerarquia(ctx context.Context) {
+	t := time.NewTicker(intervaloJerarquia)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := cargarJerarquia(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("❌ %v", err)
+			}
+		}
+	}
+}
+
+// filtroJerarquia selecciona oficinas por cualquier combinación de niveles;
+// los campos vacíos no filtran. Como un edificio puede repetirse en varios
+// sitios, conviene indicar también el sitio.
+type filtroJerarquia Ubicacion
+
+func filtroDeQuery(q url.Values) filtroJerarquia {
+	return filtroJerarquia{
+		Sitio:    q.Get("sitio"),
+		Edificio: q.Get("edificio"),
+		Piso:     q.Get("piso"),
+		Sector:   q.Get("sector"),
+	}
+}
+
+func (f filtroJerarquia) incluye(u Ubicacion) bool {
+	coincide := func(filtro, valor string) bool { return filtro == "" || filtro == valor }
+	return coincide(f.Sitio, u.Sitio) && coincide(f.Edificio, u.Edificio) &&
+		coincide(f.Piso, u.Piso) && coincide(f.Sector, u.Sector)
+}
+
+// oficinasDe lista las oficinas conocidas que cumplen el filtro.
+func oficinasDe(f filtroJerarquia) []string {
+	var lista []string
+	for _, oficina := range listarOficinas() {
+		if f.incluye(ubicacionDe(oficina)) {
+			lista = append(lista, oficina)
+		}
+	}
+	return lista
+}
+
+// NodoJerarquia es un nivel de la jerarquía con los últimos resúmenes de sus
+// oficinas sumados.
+type NodoJerarquia struct {
+	Nivel            string           `json:"nivel"`
+	ID               string           `json:"id"`
+	Oficinas         int              `json:"oficinas"`
+	CorrienteA       float64          `json:"corriente_a"`
+	ConsumoKvh       float64          `json:"consumo_kvh"`
+	ConsumoTotalKvh  float64          `json:"consumo_total_kvh"`
+	MontoTotal       float64          `json:"monto_total"`
+	EmisionesTotalKg float64          `json:"emisiones_total_kg"`
+	Hijos            []*NodoJerarquia `json:"hijos,omitempty"`
+}
+
+func (n *NodoJerarquia) hijo(nivel, id string) *NodoJerarquia {
+	for _, h := range n.Hijos {
+		if h.ID == id {
+			return h
+		}
+	}
+	h := &NodoJerarquia{Nivel: nivel, ID: id}
+	n.Hijos = append(n.Hijos, h)
+	return h
+}
+
+func (n *NodoJerarquia) sumar(r Resumen) {
+	n.Oficinas++
+	n.CorrienteA += r.CorrienteA
+	n.ConsumoKvh += r.ConsumoKvh
+	n.ConsumoTotalKvh += r.ConsumoTotalKvh
+	n.MontoTotal += r.MontoTotal
+	n.EmisionesTotalKg += r.EmisionesTotalKg
+}
+
+// redondear redondea los totales y ordena los hijos.
+func (n *NodoJerarquia) redondear() {
+	n.CorrienteA = math.Round(n.CorrienteA*100) / 100
+	n.ConsumoKvh = math.Round(n.ConsumoKvh*100) / 100
+	n.ConsumoTotalKvh = math.Round(n.ConsumoTotalKvh*100) / 100
+	n.MontoTotal = math.Round(n.MontoTotal*100) / 100
+	n.EmisionesTotalKg = math.Round(n.EmisionesTotalKg*1000) / 1000
+	sort.Slice(n.Hijos, func(i, j int) bool { return n.Hijos[i].ID < n.Hijos[j].ID })
+	for _, h := range n.Hijos {
+		h.redondear()
+	}
+}
+
+// arbolJerarquia arma sitio → edificio → piso → oficina, y aparte los
+// sectores, con el último resumen de cada oficina sumado en cada nivel.
+func arbolJerarquia() (sitios, sectores []*NodoJerarquia) {
+	raiz := &NodoJerarquia{}
+	porSector := &NodoJerarquia{}
+	resumenes := datosResumenes()
+	for _, oficina := range listarOficinas() {
+		u := ubicacionDe(oficina)
+		r := resumenes[oficina]
+
+		sitio := raiz.hijo("sitio", u.Sitio)
+		edificio := sitio.hijo("edificio", u.Edificio)
+		piso := edificio.hijo("piso", u.Piso)
+		for _, n := range []*NodoJerarquia{sitio, edificio, piso, piso.hijo("oficina", oficina)} {
+			n.sumar(r)
+		}
+
+		sector := porSector.hijo("sector", u.Sector)
+		sector.sumar(r)
+		sector.hijo("oficina", oficina).sumar(r)
+	}
+	raiz.redondear()
+	porSector.redondear()
+	return raiz.Hijos, porSector.Hijos
+}
+
+// sumarAgregados suma los resúmenes agregados de varias oficinas por
+// período. La corriente es la suma de los promedios y la máxima, la suma de
+// los máximos de cada oficina, aunque no hayan coincidido.
+func sumarAgregados(porOficina [][]ResumenAgregado) []ResumenAgregado {
+	grupos := make(map[string]*ResumenAgregado)
+	for _, agregados := range porOficina {
+		for _, a := range agregados {
+			g, existe := grupos[a.Periodo]
+			if !existe {
+				copia := a
+				grupos[a.Periodo] = &copia
+				continue
+			}
+			g.Cantidad += a.Cantidad
+			g.CorrienteA += a.CorrienteA
+			g.CorrienteMaxA += a.CorrienteMaxA
+			g.ConsumoKvh += a.ConsumoKvh
+			g.MinTemp = math.Min(g.MinTemp, a.MinTemp)
+			g.MaxTemp = math.Max(g.MaxTemp, a.MaxTemp)
+			g.TiempoPresente += a.TiempoPresente
+			g.Monto += a.Monto
+			g.EmisionesKg += a.EmisionesKg
+			g.EmisionesEvitadasKg += a.EmisionesEvitadasKg
+			if a.Desde < g.Desde {
+				g.Desde = a.Desde
+			}
+			if a.Hasta > g.Hasta {
+				g.Hasta = a.Hasta
+			}
+		}
+	}
+
+	sumados := make([]ResumenAgregado, 0, len(grupos))
+	for _, g := range grupos {
+		g.CorrienteA = math.Round(g.CorrienteA*100) / 100
+		g.CorrienteMaxA = math.Round(g.CorrienteMaxA*100) / 100
+		g.ConsumoKvh = math.Round(g.ConsumoKvh*100) / 100
+		g.Monto = math.Round(g.Monto*100) / 100
+		g.EmisionesKg = math.Round(g.EmisionesKg*1000) / 1000
+		g.EmisionesEvitadasKg = math.Round(g.EmisionesEvitadasKg*1000) / 1000
+		sumados = append(sumados, *g)
+	}
+	sort.Slice(sumados, func(i, j int) bool {
+		return sumados[i].Desde < sumados[j].Desde
+	})
+	return sumados
+}
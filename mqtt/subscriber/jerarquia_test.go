@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const jerarquiaDePrueba = `{"monitoreo_consumo": {
+	"oficinas": {
+		"A": {"sector": "Ventas", "avisos": {"a1": {"timestamp": 100, "id_tipo": "1"}}},
+		"B": {"sector": "Informatica", "avisos": {"b1": {"timestamp": 100, "id_tipo": "1"}, "b2": {"timestamp": 200, "id_tipo": "2"}}},
+		"C": {"sector": "Compras"},
+		"D": {}
+	},
+	"jerarquia": {"oficinas": {
+		"A": {"sitio": "Central", "edificio": "Norte", "piso": "2"},
+		"B": {"sitio": "Central", "edificio": " Norte ", "piso": "1", "sector": "TI"},
+		"C": {"piso": "3"},
+		"E": {"edificio": "Sur"}
+	}}
+}}`
+
+// conJerarquia carga la jerarquía de prueba y la deja como estaba al
+// terminar.
+func conJerarquia(t *testing.T) *firebaseDePrueba {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	f := usarFirebaseDePrueba(t, jerarquiaDePrueba)
+	mu.Lock()
+	anteriores, resumenes := ubicaciones, ultimosResumenes
+	ultimosResumenes = map[string]Resumen{
+		"A": {ConsumoTotalKvh: 10, CorrienteA: 1},
+		"B": {ConsumoTotalKvh: 20, CorrienteA: 2},
+		"C": {ConsumoTotalKvh: 40, CorrienteA: 4},
+	}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		ubicaciones, ultimosResumenes = anteriores, resumenes
+		mu.Unlock()
+	})
+	if err := cargarJerarquia(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	conOficinas(t, "A", "B", "C", "D")
+	return f
+}
+
+func TestCargarJerarquia(t *testing.T) {
+	conJerarquia(t)
+	casos := []struct {
+		oficina   string
+		ubicacion Ubicacion
+	}{
+		// Sin sector en la jerarquía: el de la oficina.
+		{"A", Ubicacion{"Central", "Norte", "2", "Ventas"}},
+		// El sector de la jerarquía gana; los espacios no cuentan.
+		{"B", Ubicacion{"Central", "Norte", "1", "TI"}},
+		// Un piso sin edificio invalida la ubicación, pero no el sector.
+		{"C", Ubicacion{Sector: "Compras"}},
+		{"D", Ubicacion{}},
+		// Un edificio sin sitio.
+		{"E", Ubicacion{}},
+	}
+	for _, c := range casos {
+		t.Run(c.oficina, func(t *testing.T) {
+			mu.RLock()
+			u := ubicaciones[c.oficina]
+			mu.RUnlock()
+			if u != c.ubicacion {
+				t.Errorf("ubicación de %s: %+v; se esperaba %+v", c.oficina, u, c.ubicacion)
+			}
+		})
+	}
+	if u := ubicacionDe("D"); u != (Ubicacion{sinAsignar, sinAsignar, sinAsignar, sinAsignar}) {
+		t.Errorf("ubicacionDe(D): %+v", u)
+	}
+}
+
+func TestValidarUbicacion(t *testing.T) {
+	casos := []struct {
+		nombre    string
+		ubicacion Ubicacion
+		valida    bool
+	}{
+		{"vacía", Ubicacion{}, true},
+		{"solo sector", Ubicacion{Sector: "TI"}, true},
+		{"solo sitio", Ubicacion{Sitio: "Central"}, true},
+		{"completa", Ubicacion{"Central", "Norte", "2", "TI"}, true},
+		{"edificio sin sitio", Ubicacion{Edificio: "Norte", Piso: "2"}, false},
+		{"piso sin edificio", Ubicacion{Sitio: "Central", Piso: "2"}, false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if err := c.ubicacion.validar(); (err == nil) != c.valida {
+				t.Errorf("validar: %v; se esperaba válida %v", err, c.valida)
+			}
+		})
+	}
+}
+
+func TestAPIJerarquia(t *testing.T) {
+	conJerarquia(t)
+
+	w := httptest.NewRecorder()
+	nuevoMuxAPI().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/jerarquia", nil))
+	var arbol struct {
+		Sitios   []*NodoJerarquia `json:"sitios"`
+		Sectores []*NodoJerarquia `json:"sectores"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &arbol); err != nil {
+		t.Fatal(err)
+	}
+	if len(arbol.Sitios) != 2 || arbol.Sitios[0].ID != "Central" || arbol.Sitios[1].ID != sinAsignar {
+		t.Fatalf("sitios: %+v", arbol.Sitios)
+	}
+	central := arbol.Sitios[0]
+	if central.Oficinas != 2 || central.ConsumoTotalKvh != 30 || len(central.Hijos) != 1 || central.Hijos[0].Oficinas != 2 {
+		t.Errorf("Central: %+v; se esperaba un solo edificio Norte con A y B", central)
+	}
+	var sectores []string
+	for _, s := range arbol.Sectores {
+		sectores = append(sectores, s.ID)
+	}
+	if !reflect.DeepEqual(sectores, []string{"Compras", sinAsignar, "TI", "Ventas"}) {
+		t.Errorf("sectores: %v", sectores)
+	}
+
+	casos := []struct {
+		nombre   string
+		query    string
+		status   int
+		oficinas []string
+	}{
+		{"sin filtros", "", http.StatusOK, []string{"A", "B", "C", "D"}},
+		{"sitio y edificio", "sitio=Central&edificio=Norte", http.StatusOK, []string{"A", "B"}},
+		{"piso", "sitio=Central&edificio=Norte&piso=1", http.StatusOK, []string{"B"}},
+		{"sector de la oficina", "sector=Ventas", http.StatusOK, []string{"A"}},
+		{"sector de una ubicación inválida", "sector=Compras", http.StatusOK, []string{"C"}},
+		{"sin asignar", "sitio=" + url.QueryEscape(sinAsignar), http.StatusOK, []string{"C", "D"}},
+		{"sector reemplazado por la jerarquía", "sector=Informatica", http.StatusNotFound, nil},
+		{"edificio de otro sitio", "sitio=Otro&edificio=Norte", http.StatusNotFound, nil},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			nuevoMuxAPI().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/grupos/avisos?desde=0&hasta=1000&"+c.query, nil))
+			if w.Code != c.status {
+				t.Fatalf("status %d; se esperaba %d: %s", w.Code, c.status, w.Body)
+			}
+			if c.status != http.StatusOK {
+				return
+			}
+			var grupo struct {
+				Oficinas []string `json:"oficinas"`
+				Total    int      `json:"total"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &grupo); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(grupo.Oficinas, c.oficinas) {
+				t.Errorf("oficinas: %v; se esperaba %v", grupo.Oficinas, c.oficinas)
+			}
+			avisos := map[string]int{"A": 1, "B": 2}
+			total := 0
+			for _, o := range c.oficinas {
+				total += avisos[o]
+			}
+			if grupo.Total != total {
+				t.Errorf("%d avisos; se esperaban %d", grupo.Total, total)
+			}
+		})
+	}
+}
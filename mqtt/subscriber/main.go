@@ -3,10 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	firebase "firebase.google.com/go"
@@ -14,9 +19,10 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"google.golang.org/api/option"
 
-	"net/url"
-
-	"github.com/gorilla/websocket"
+	"monitoreo_consumo/internal/configuracion"
+	"monitoreo_consumo/internal/metricas"
+	"monitoreo_consumo/internal/mqttcliente"
+	"monitoreo_consumo/internal/wscliente"
 )
 
 type TipoAviso struct {
@@ -32,6 +38,13 @@ type ParametrosConfig struct {
 	UmbralCorriente     float64 `json:"umbral_corriente"`
 	Voltaje             float64 `json:"voltaje"`
 	CostoKwh            float64 `json:"costo_kwh"`
+	// Factor de emisión de la red (kgCO2/kWh) y, opcionalmente, uno por
+	// cada hora del día (24 valores) que tiene prioridad sobre el general.
+	FactorEmision       float64   `json:"factor_emision"`
+	FactoresEmisionHora []float64 `json:"factores_emision_hora"`
+	// Corriente promedio de referencia de una oficina sin gestión, usada
+	// como línea base para calcular las emisiones evitadas.
+	CorrienteBaseA float64 `json:"corriente_base_a"`
 }
 
 type DatosSensor struct {
@@ -40,8 +53,40 @@ type DatosSensor struct {
 	Presencia   bool    `json:"presencia"`
 	CorrienteA  float64 `json:"corriente_a"`
 	Temperatura float64 `json:"temperatura"`
+	Secuencia   uint64  `json:"secuencia,omitempty"`
+
+	// Hora de llegada al subscriber y, si se reestampó, el timestamp que
+	// envió el sensor.
+	recibida int64
+	original int64
+}
+
+// llegada es la hora de recepción, o el timestamp si la lectura no vino
+// por MQTT.
+func (d DatosSensor) llegada() int64 {
+	if d.recibida != 0 {
+		return d.recibida
+	}
+	return d.Timestamp
 }
 
+// Identificadores del catálogo monitoreo_consumo/tipos_avisos.
+const (
+	avisoLucesApagadasEstado    = "0"
+	avisoLucesEncendidas        = "1"
+	avisoLucesApagadasAusencia  = "2"
+	avisoAireApagadoEstado      = "3"
+	avisoAireEncendido          = "4"
+	avisoAireApagadoCondiciones = "5"
+	avisoConsumoAnomalo         = "6"
+	avisoCorteEnergia           = "7"
+	avisoSensorNoResponde       = "8"
+	avisoAlertaCorriente        = "9"
+	avisoRelojDesfasado         = "13"
+	avisoAccionAutomatica       = "14"
+	avisoDeslastre              = "15"
+)
+
 type Aviso struct {
 	Timestamp int64  `json:"timestamp"`
 	IDTipo    string `json:"id_tipo"`
@@ -58,10 +103,28 @@ type Resumen struct {
 	TiempoPresente  int     `json:"tiempo_presente"`
 	MontoEstimado   float64 `json:"monto_estimado"`
 	MontoTotal      float64 `json:"monto_total"`
+
+	EmisionesKg              float64 `json:"emisiones_kg"`
+	EmisionesTotalKg         float64 `json:"emisiones_total_kg"`
+	EmisionesEvitadasKg      float64 `json:"emisiones_evitadas_kg"`
+	EmisionesEvitadasTotalKg float64 `json:"emisiones_evitadas_total_kg"`
+}
+
+// Correccion guarda una lectura que llegó cuando su ventana ya estaba
+// resumida, con el consumo que le faltó a ese resumen.
+type Correccion struct {
+	Timestamp   int64   `json:"timestamp"`
+	Recibida    int64   `json:"recibida"`
+	CorrienteA  float64 `json:"corriente_a"`
+	ConsumoKvh  float64 `json:"consumo_kvh"`
+	Temperatura float64 `json:"temperatura"`
+	Presencia   bool    `json:"presencia"`
 }
 
 type EstadoOficina struct {
 	UltimaLectura         int64
+	UltimaRecepcion       int64
+	InicioVentana         int64
 	Corrientes            []float64
 	Consumos              []float64
 	Temperaturas          []float64
@@ -73,37 +136,61 @@ type EstadoOficina struct {
 	SinCorrienteDesde     *int64
 	SensorFueraDeServicio bool
 	ConsumoElevado        bool
+	DesfaseReloj          int64
+	RelojDesfasado        bool
+	EmisionesTotalKg      float64
+	EmisionesEvitadasKg   float64
 	Mutex                 sync.Mutex
 }
 
 var (
 	mu                 sync.RWMutex
 	config             ParametrosConfig
-	idsTiposAvisos     []string
 	dispositivoEstados map[string]map[string]bool = make(map[string]map[string]bool)
 	oficinas           []string
 	mapaEstados        = make(map[string]*EstadoOficina)
+	tiposAvisos        = make(map[string]TipoAviso)
 	clienteFirebase    *db.Client
+	cfg                configuracion.Config
+	// raizFirebase es monitoreo_consumo o, con inquilino,
+	// inquilinos/<id>/monitoreo_consumo.
+	raizFirebase = "monitoreo_consumo"
 )
 
-func wsListener(endpoint string, updateFunc func([]byte)) error {
-	u := url.URL{Scheme: "ws", Host: "localhost:8081", Path: endpoint}
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
-	if err != nil {
-		return err
-	}
-	go func() {
-		defer conn.Close()
-		for {
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				log.Printf("Error leyendo ws %s: %v", endpoint, err)
-				return
-			}
-			updateFunc(msg)
-		}
-	}()
-	return nil
+// rutaFirebase arma una ruta bajo la raíz del inquilino.
+func rutaFirebase(formato string, args ...interface{}) string {
+	return raizFirebase + "/" + fmt.Sprintf(formato, args...)
+}
+
+var cfgPorDefecto = configuracion.Config{
+	Broker:           "tcp://localhost:1883",
+	ClienteID:        "subscriptor-edge",
+	ServidorWS:       "localhost:8081",
+	HubWS:            ":8081",
+	Credenciales:     "../../credentials/firebase-credentials.json",
+	FirebaseURL:      "https://mqtt-mosquitto-3ae51-default-rtdb.firebaseio.com/",
+	API:              ":8090",
+	Metricas:         ":9101",
+	Intervalo:        configuracion.Duracion(10 * time.Second),
+	AlmacenMQTT:      "../../data/mqtt/subscriber",
+	DeadLetter:       "../../data/deadletter/subscriber.jsonl",
+	Archivo:          "../../data/archivo",
+	RetencionArchivo: 90,
+	PlazoCierre:      configuracion.Duracion(10 * time.Second),
+	ColaOficina:      256,
+	RetrasoMax:       configuracion.Duracion(20 * time.Second),
+	DesfaseMax:       configuracion.Duracion(30 * time.Second),
+}
+
+// segundosPorLectura es el tiempo que representa cada lectura de un sensor.
+func segundosPorLectura() int {
+	return int(time.Duration(cfg.Intervalo).Seconds())
+}
+
+func wsListener(ctx context.Context, endpoint string, updateFunc func([]byte)) *wscliente.Cliente {
+	c := wscliente.Nuevo(cfg.ServidorWS, endpoint, updateFunc)
+	go c.Ejecutar(ctx)
+	return c
 }
 
 func actualizarParamsConfig(data []byte) {
@@ -117,6 +204,7 @@ func actualizarParamsConfig(data []byte) {
 	if m.Tipo != "params" {
 		return
 	}
+	descartarFactoresHora(&m.Data)
 	mu.Lock()
 	config = m.Data
 	mu.Unlock()
@@ -124,8 +212,8 @@ func actualizarParamsConfig(data []byte) {
 
 func actualizarTiposAvisos(data []byte) {
 	var m struct {
-		Tipo string               `json:"tipo"`
-		Data map[string]TipoAviso `json:"data"`
+		Tipo string          `json:"tipo"`
+		Data json.RawMessage `json:"data"`
 	}
 	if err := json.Unmarshal(data, &m); err != nil {
 		return
@@ -133,11 +221,13 @@ func actualizarTiposAvisos(data []byte) {
 	if m.Tipo != "tipos_avisos" {
 		return
 	}
-	mu.Lock()
-	idsTiposAvisos = []string{}
-	for id := range m.Data {
-		idsTiposAvisos = append(idsTiposAvisos, id)
+	catalogo, err := decodificarTiposAvisos(m.Data)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return
 	}
+	mu.Lock()
+	tiposAvisos = catalogo
 	mu.Unlock()
 }
 
@@ -217,19 +307,23 @@ func obtenerEstado(oficina string) *EstadoOficina {
 }
 
 func detectarAvisos(datos DatosSensor, estado *EstadoOficina) []Aviso {
-	var avisos []Aviso
-	ahora := time.Now().Unix()
-
 	mu.RLock()
 	estadoDispositivo := dispositivoEstados[datos.Oficina]
 	localConfig := config
-	localIdsTipos := idsTiposAvisos
+	hayCatalogo := len(tiposAvisos) > 0
 	mu.RUnlock()
 
-	if len(localIdsTipos) == 0 {
-		return avisos
+	if !hayCatalogo {
+		return nil
 	}
+	return evaluarAvisos(datos, estado, localConfig, estadoDispositivo, time.Now().Unix())
+}
 
+// evaluarAvisos es detectarAvisos con los parámetros, el estado de los
+// dispositivos y la hora de los avisos explícitos; backfill la usa para
+// recalcular el pasado.
+func evaluarAvisos(datos DatosSensor, estado *EstadoOficina, localConfig ParametrosConfig, estadoDispositivo map[string]bool, ahora int64) []Aviso {
+	var avisos []Aviso
 	agregarAviso := func(idTipo, adicional string) {
 		avisos = append(avisos, Aviso{
 			Timestamp: ahora,
@@ -240,56 +334,61 @@ func detectarAvisos(datos DatosSensor, estado *EstadoOficina) []Aviso {
 
 	if datos.Presencia {
 		if !estadoDispositivo["luces"] && estado.LuzEncendida {
-			agregarAviso(localIdsTipos[0], "")
+			agregarAviso(avisoLucesApagadasEstado, "")
 			estado.LuzEncendida = false
 		} else if estadoDispositivo["luces"] && !estado.LuzEncendida {
-			agregarAviso(localIdsTipos[1], "")
+			agregarAviso(avisoLucesEncendidas, "")
 			estado.LuzEncendida = true
 		}
 	} else if !datos.Presencia && estado.LuzEncendida {
-		agregarAviso(localIdsTipos[2], "")
+		agregarAviso(avisoLucesApagadasAusencia, "")
 		estado.LuzEncendida = false
 	}
 
 	debePrenderAire := datos.Presencia && datos.Temperatura > localConfig.UmbralTemperaturaAC
 	if debePrenderAire {
 		if !estadoDispositivo["aire"] && estado.AireEncendido {
-			agregarAviso(localIdsTipos[3], "")
+			agregarAviso(avisoAireApagadoEstado, "")
 			estado.AireEncendido = false
 		} else if estadoDispositivo["aire"] && !estado.AireEncendido {
-			agregarAviso(localIdsTipos[4], "")
+			agregarAviso(avisoAireEncendido, "")
 			estado.AireEncendido = true
 		}
 	} else if !debePrenderAire && estado.AireEncendido {
-		agregarAviso(localIdsTipos[5], "")
+		agregarAviso(avisoAireApagadoCondiciones, "")
 		estado.AireEncendido = false
 	}
 
 	if !datos.Presencia && datos.CorrienteA > 10.0 {
-		agregarAviso(localIdsTipos[6], fmt.Sprintf("Consumo: %.2f A", datos.CorrienteA))
+		agregarAviso(avisoConsumoAnomalo, fmt.Sprintf("Consumo: %.2f A", datos.CorrienteA))
 	}
 
 	if datos.CorrienteA <= 0 {
 		if estado.SinCorrienteDesde == nil {
 			estado.SinCorrienteDesde = &datos.Timestamp
 		} else if datos.Timestamp-*estado.SinCorrienteDesde > 60 {
-			agregarAviso(localIdsTipos[7], fmt.Sprintf("Sin corriente desde: %d segundos", datos.Timestamp-*estado.SinCorrienteDesde))
+			agregarAviso(avisoCorteEnergia, fmt.Sprintf("Sin corriente desde: %d segundos", datos.Timestamp-*estado.SinCorrienteDesde))
 			estado.SinCorrienteDesde = nil
 		}
 	} else {
 		estado.SinCorrienteDesde = nil
 	}
 
-	tiempoSinRespuesta := ahora - estado.UltimaLectura
+	// Se mide con la hora de llegada para que un sensor con el reloj mal
+	// configurado no parezca caído.
+	tiempoSinRespuesta := int64(0)
+	if estado.UltimaRecepcion > 0 {
+		tiempoSinRespuesta = datos.llegada() - estado.UltimaRecepcion
+	}
 	if tiempoSinRespuesta > 60 && !estado.SensorFueraDeServicio {
-		agregarAviso(localIdsTipos[8], fmt.Sprintf("Tiempo sin respuesta: %d segundos", tiempoSinRespuesta))
+		agregarAviso(avisoSensorNoResponde, fmt.Sprintf("Tiempo sin respuesta: %d segundos", tiempoSinRespuesta))
 		estado.SensorFueraDeServicio = true
 	} else if tiempoSinRespuesta <= 60 && estado.SensorFueraDeServicio {
 		estado.SensorFueraDeServicio = false
 	}
 
 	if datos.CorrienteA > localConfig.UmbralCorriente && !estado.ConsumoElevado {
-		agregarAviso(localIdsTipos[9], fmt.Sprintf("Consumo: %.2f A", datos.CorrienteA))
+		agregarAviso(avisoAlertaCorriente, fmt.Sprintf("Consumo: %.2f A", datos.CorrienteA))
 		estado.ConsumoElevado = true
 	} else if datos.CorrienteA <= localConfig.UmbralCorriente && estado.ConsumoElevado {
 		estado.ConsumoElevado = false
@@ -298,21 +397,27 @@ func detectarAvisos(datos DatosSensor, estado *EstadoOficina) []Aviso {
 	return avisos
 }
 
-func guardarAviso(ctx context.Context, oficina string, aviso Aviso) error {
-	ref := clienteFirebase.NewRef(fmt.Sprintf("monitoreo_consumo/oficinas/%s/avisos", oficina))
-	newRef, err := ref.Push(ctx, aviso)
-	if err != nil {
-		return err
+func escrituraAviso(oficina string, aviso Aviso) escritura {
+	return escritura{
+		operacion: "aviso",
+		ruta:      rutaFirebase("oficinas/%s/avisos", oficina),
+		valor:     aviso,
+		agregar:   true,
+		alGuardar: func() {
+			log.Printf("[AVISO] Oficina:%s Tipo:%s Más:%s\n", oficina, aviso.IDTipo, aviso.Adicional)
+			publicarAviso(oficina, aviso)
+		},
 	}
-	log.Printf("Aviso guardado en: %s", newRef.Path)
-	return nil
 }
 
 func generarResumen(ahora int64, estado *EstadoOficina) Resumen {
 	mu.RLock()
 	localConfig := config
 	mu.RUnlock()
+	return calcularResumen(ahora, estado, localConfig)
+}
 
+func calcularResumen(ahora int64, estado *EstadoOficina, localConfig ParametrosConfig) Resumen {
 	sumaCorrientes := 0.0
 	sumaConsumos := 0.0
 	minT := 1000.0
@@ -326,7 +431,7 @@ func generarResumen(ahora int64, estado *EstadoOficina) Resumen {
 		promedioAmp = sumaCorrientes / float64(len(estado.Corrientes))
 	}
 
-	duracionSegundos := len(estado.Corrientes) * 10
+	duracionSegundos := len(estado.Corrientes) * segundosPorLectura()
 	duracionHoras := float64(duracionSegundos) / 3600.0
 
 	promedioW := promedioAmp * localConfig.Voltaje
@@ -346,6 +451,10 @@ func generarResumen(ahora int64, estado *EstadoOficina) Resumen {
 		}
 	}
 
+	emisiones, evitadas := calcularEmisiones(localConfig, ahora, promedioKwh, duracionHoras)
+	estado.EmisionesTotalKg += emisiones
+	estado.EmisionesEvitadasKg += evitadas
+
 	return Resumen{
 		Timestamp:       ahora,
 		CorrienteA:      math.Round(promedioAmp*100) / 100,
@@ -356,24 +465,31 @@ func generarResumen(ahora int64, estado *EstadoOficina) Resumen {
 		TiempoPresente:  estado.TiempoPresente,
 		MontoEstimado:   math.Round(promedioKwh*localConfig.CostoKwh*100) / 100,
 		MontoTotal:      math.Round(sumaConsumos*localConfig.CostoKwh*100) / 100,
+
+		EmisionesKg:              math.Round(emisiones*1000) / 1000,
+		EmisionesTotalKg:         math.Round(estado.EmisionesTotalKg*1000) / 1000,
+		EmisionesEvitadasKg:      math.Round(evitadas*1000) / 1000,
+		EmisionesEvitadasTotalKg: math.Round(estado.EmisionesEvitadasKg*1000) / 1000,
 	}
 }
 
 // Agregar función para actualizar lista de oficinas en Firebase
 func actualizarOficinasEnFirebase(ctx context.Context) error {
+	// Actualización multi-ruta: un Set sobre el nodo borraría los resúmenes,
+	// avisos y estados de dispositivos de cada oficina.
 	mu.RLock()
 	oficinasData := make(map[string]interface{})
 	for _, oficina := range oficinas {
-		oficinasData[oficina] = map[string]interface{}{
-			"nombre":    oficina,
-			"activa":    true,
-			"timestamp": time.Now().Unix(),
-		}
+		oficinasData[oficina+"/activa"] = true
+		oficinasData[oficina+"/timestamp"] = time.Now().Unix()
 	}
 	mu.RUnlock()
+	if len(oficinasData) == 0 {
+		return nil
+	}
 
-	ref := clienteFirebase.NewRef("monitoreo_consumo/oficinas")
-	if err := ref.Set(ctx, oficinasData); err != nil {
+	ref := clienteFirebase.NewRef(rutaFirebase("oficinas"))
+	if err := medirFirebase("oficinas", func() error { return ref.Update(ctx, oficinasData) }); err != nil {
 		return fmt.Errorf("error actualizando oficinas en Firebase: %v", err)
 	}
 
@@ -381,81 +497,359 @@ func actualizarOficinasEnFirebase(ctx context.Context) error {
 	return nil
 }
 
-func guardarResumen(ctx context.Context, oficina string, resumen Resumen) error {
-	ref := clienteFirebase.NewRef(fmt.Sprintf("monitoreo_consumo/oficinas/%s/resumenes", oficina))
-	newRef, err := ref.Push(ctx, resumen)
+func escrituraResumen(oficina string, resumen Resumen) escritura {
+	return escritura{
+		operacion: "resumen",
+		ruta:      rutaFirebase("oficinas/%s/resumenes", oficina),
+		valor:     resumen,
+		agregar:   true,
+		alGuardar: func() {
+			log.Printf("[RESUMEN] Oficina:%s %+v\n", oficina, resumen)
+			publicarResumen(oficina, resumen)
+		},
+	}
+}
+
+func conectarFirebase(ctx context.Context) error {
+	credenciales := option.WithCredentialsFile(cfg.Credenciales)
+	app, err := firebase.NewApp(ctx, nil, credenciales)
+	if err != nil {
+		return fmt.Errorf("error al inicializar Firebase: %v", err)
+	}
+	clienteFirebase, err = app.DatabaseWithURL(ctx, cfg.FirebaseURL)
 	if err != nil {
-		return err
+		return fmt.Errorf("error al obtener cliente de base de datos: %v", err)
 	}
-	log.Printf("Resumen guardado en: %s", newRef.Path)
 	return nil
 }
 
 func main() {
-	ctx := context.Background()
-	credenciales := option.WithCredentialsFile("../../credentials/firebase-credentials.json")
-	app, err := firebase.NewApp(ctx, nil, credenciales)
+	var (
+		args []string
+		err  error
+	)
+	cfg, args, err = configuracion.Cargar("subscriber", cfgPorDefecto, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		// La ayuda ya la imprimió el flag.FlagSet.
+		return
+	}
 	if err != nil {
-		log.Fatalf("Error al inicializar Firebase: %v", err)
+		log.Fatalf("❌ Configuración inválida: %v", err)
+	}
+	raizFirebase = cfg.RaizFirebase()
+	if len(args) > 0 {
+		if err := ejecutarComando(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatalf("❌ %v", err)
+		}
+		return
+	}
+	if cfg.Inquilinos != "" {
+		if err := supervisarInquilinos(cfg.Inquilinos); err != nil {
+			log.Fatalf("❌ Inquilinos inválidos: %v", err)
+		}
+		return
 	}
-	clienteFirebase, err = app.DatabaseWithURL(ctx, "https://mqtt-mosquitto-3ae51-default-rtdb.firebaseio.com/")
+	if err := cfg.ValidarFirebase(); err != nil {
+		log.Fatalf("❌ Configuración inválida: %v", err)
+	}
+	cfg.Imprimir(os.Stdout)
+	if cfg.Programacion != "" {
+		if programacion, err = cargarProgramacion(cfg.Programacion); err != nil {
+			log.Fatalf("❌ Programación inválida: %v", err)
+		}
+		log.Printf("📅 Programación cargada: %d acciones, %d excepciones", len(programacion.Acciones), len(programacion.Excepciones))
+	}
+	if cfg.Deslastre != "" {
+		limites, err := cargarConfigDeslastre(cfg.Deslastre)
+		if err != nil {
+			log.Fatalf("❌ Deslastre inválido: %v", err)
+		}
+		deslastre = nuevoDeslastre(limites, time.Duration(cfg.Intervalo), time.Duration(cfg.RetrasoMax))
+		log.Printf("⚡ Deslastre de carga en %d edificios", len(limites.Edificios))
+	}
+	if cfg.Notificaciones != "" {
+		canales, err := cargarConfigNotificaciones(cfg.Notificaciones)
+		if err == nil {
+			notificador, err = nuevoNotificador(canales)
+		}
+		if err != nil {
+			log.Fatalf("❌ Notificaciones inválidas: %v", err)
+		}
+		log.Printf("📣 Notificaciones por %d canales", len(canales.Canales))
+	}
+	if cfg.Escalamiento != "" {
+		politicas, err := cargarConfigEscalamiento(cfg.Escalamiento)
+		if err == nil {
+			escalamiento, err = nuevoEscalamiento(politicas, notificador)
+		}
+		if err != nil {
+			log.Fatalf("❌ Escalamiento inválido: %v", err)
+		}
+		log.Printf("📟 Escalamiento con %d políticas", len(politicas.Politicas))
+	}
+
+	topic := mqttcliente.Topico(cfg.Inquilino, "+", "sensores")
+	manejador := func(c mqtt.Client, msg mqtt.Message) {
+		recibirLectura(c, msg.Topic(), msg.Payload())
+	}
+	// La suscripción se renueva en cada (re)conexión.
+	opciones, err := mqttcliente.Opciones(cfg, func(c mqtt.Client) {
+		token := c.Subscribe(topic, mqttcliente.QoS, manejador)
+		if token.Wait() && token.Error() != nil {
+			log.Printf("❌ Error suscribiendo a %s: %v", topic, token.Error())
+			return
+		}
+		log.Printf("📡 Suscrito a %s", topic)
+	})
 	if err != nil {
-		log.Fatalf("Error al obtener cliente de base de datos: %v", err)
+		log.Fatalf("❌ Configuración MQTT inválida: %v", err)
+	}
+
+	// ctxServicio se cancela con SIGINT/SIGTERM y controla la vida de los
+	// servicios; las escrituras usan ctx para poder completar el cierre.
+	ctxServicio, detener := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer detener()
+	ctx := context.Background()
+	plazo := time.Duration(cfg.PlazoCierre)
+	var servicios sync.WaitGroup
+	iniciarServicio := func(fn func()) {
+		servicios.Add(1)
+		go func() {
+			defer servicios.Done()
+			fn()
+		}()
 	}
 
-	opciones := mqtt.NewClientOptions().AddBroker("tcp://localhost:1883").SetClientID("subscriptor-edge")
-	clienteMQTT := mqtt.NewClient(opciones)
-	if token := clienteMQTT.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+	if err := conectarFirebase(ctx); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if err := cargarJerarquia(ctx); err != nil {
+		log.Printf("❌ %v", err)
+	}
+	if err := cargarEmisiones(ctx); err != nil {
+		log.Printf("❌ %v", err)
+	}
+	iniciarServicio(func() { refrescarJerarquia(ctxServicio) })
+	if escalamiento != nil {
+		if err := escalamiento.cargar(ctx); err != nil {
+			log.Printf("❌ %v", err)
+		}
 	}
 
-	wsListener("/ws/params", actualizarParamsConfig)
-	wsListener("/ws/tipos_avisos", actualizarTiposAvisos)
-	wsListener("/ws/oficinas", actualizarOficinas)
-	wsListener("/ws/dispositivos", actualizarDispositivos)
+	ingesta = nuevaIngesta(cfg, guardarEnFirebase)
+	ingesta.iniciar(ctx)
+	if cfg.Archivo != "" {
+		archivo = nuevoArchivo(cfg.Archivo, cfg.RetencionArchivo)
+		archivo.iniciar()
+	}
 
-	topic := "oficinas/+/sensores"
-	clienteMQTT.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
-		var datos DatosSensor
-		if err := json.Unmarshal(msg.Payload(), &datos); err != nil {
-			return
+	var clientesWS []*wscliente.Cliente
+	if cfg.HubWS != "" {
+		hub = nuevoHub()
+		hub.suscribirLocal("params", actualizarParamsConfig)
+		hub.suscribirLocal("tipos_avisos", actualizarTiposAvisos)
+		hub.suscribirLocal("oficinas", actualizarOficinas)
+		hub.suscribirLocal("dispositivos", actualizarDispositivos)
+		if err := cargarEstadoInicial(ctx, hub); err != nil {
+			log.Printf("❌ Error cargando estado inicial: %v", err)
 		}
-		ahora := time.Now().Unix()
-		estado := obtenerEstado(datos.Oficina)
-		estado.Mutex.Lock()
-		defer estado.Mutex.Unlock()
+		iniciarServicio(func() { hub.iniciar(ctxServicio, cfg.HubWS, plazo) })
+	} else {
+		clientesWS = append(clientesWS,
+			wsListener(ctxServicio, "/ws/params", actualizarParamsConfig),
+			wsListener(ctxServicio, "/ws/tipos_avisos", actualizarTiposAvisos),
+			wsListener(ctxServicio, "/ws/oficinas", actualizarOficinas),
+		)
+		clienteDispositivos = wsListener(ctxServicio, "/ws/dispositivos", actualizarDispositivos)
+		clientesWS = append(clientesWS, clienteDispositivos)
+	}
+	if cfg.RetardoAutomatizacion > 0 {
+		automatizacion = nuevaAutomatizacion(time.Duration(cfg.RetardoAutomatizacion))
+		iniciarServicio(func() { automatizacion.ejecutar(ctxServicio) })
+	}
+	if programacion != nil {
+		iniciarServicio(func() { programacion.ejecutar(ctxServicio) })
+	}
+	if deslastre != nil {
+		iniciarServicio(func() { deslastre.ejecutar(ctxServicio, time.Duration(cfg.Intervalo)) })
+	}
+	if notificador != nil {
+		notificador.iniciar(ctxServicio, iniciarServicio)
+	}
+	if escalamiento != nil {
+		iniciarServicio(func() { escalamiento.ejecutar(ctxServicio) })
+	}
+
+	if cfg.Metricas != "" {
+		salud := wscliente.ManejadorSalud(clientesWS...)
+		iniciarServicio(func() { metricas.Servir(ctxServicio, cfg.Metricas, salud, plazo) })
+	}
+	if cfg.API != "" {
+		iniciarServicio(func() { iniciarAPI(ctxServicio, cfg.API, plazo) })
+	}
+
+	clienteMQTT = mqttcliente.Conectar(opciones)
 
-		estado.Corrientes = append(estado.Corrientes, datos.CorrienteA)
-		estado.Temperaturas = append(estado.Temperaturas, datos.Temperatura)
-		if datos.Presencia {
-			estado.TiempoPresente += 10
+	<-ctxServicio.Done()
+	detener()
+	log.Println("🛑 Señal recibida, cerrando subscriber...")
+	ctxCierre, cancelar := context.WithTimeout(context.Background(), time.Duration(cfg.PlazoCierre))
+	defer cancelar()
+
+	// Primero se deja de recibir lecturas para que las ventanas no cambien
+	// mientras se cierran.
+	clienteMQTT.Disconnect(250)
+	ingesta.cerrar(ctxCierre)
+	// Los avisos guardados al cerrar la ingesta pueden haber cambiado
+	// incidentes.
+	if escalamiento != nil {
+		if err := escalamiento.guardarPendientes(ctxCierre); err != nil {
+			log.Printf("❌ %v", err)
 		}
+	}
+	if archivo != nil {
+		archivo.cerrar()
+	}
+	if hub != nil {
+		hub.cerrar()
+	}
+
+	terminados := make(chan struct{})
+	go func() {
+		servicios.Wait()
+		close(terminados)
+	}()
+	select {
+	case <-terminados:
+		log.Println("👋 Subscriber detenido")
+	case <-ctxCierre.Done():
+		log.Println("⚠️  Plazo de cierre agotado, saliendo igualmente")
+	}
+}
+
+// procesarLectura acumula la lectura en la ventana de su oficina y detecta
+// avisos. La llama solo el trabajador de la oficina; las escrituras quedan
+// en manos del escritor de la ingesta.
+//
+// Las ventanas de 60 segundos se cuentan con el timestamp de las lecturas:
+// la primera lectura que cae fuera de la ventana abierta la cierra.
+func procesarLectura(datos DatosSensor) {
+	ahora := time.Now().Unix()
+	estado := obtenerEstado(datos.Oficina)
+	estado.Mutex.Lock()
+	defer estado.Mutex.Unlock()
+
+	if len(estado.Corrientes) > 0 && datos.Timestamp-estado.InicioVentana >= 60 {
+		cerrarVentana(datos.Oficina, estado, ahora)
+	}
+	if len(estado.Corrientes) == 0 {
+		estado.InicioVentana = datos.Timestamp
+	}
+	acumularLectura(estado, datos)
+	if datos.Timestamp > estado.UltimaLectura {
 		estado.UltimaLectura = datos.Timestamp
+	}
 
-		avisos := detectarAvisos(datos, estado)
-		for _, av := range avisos {
-			if err := guardarAviso(ctx, datos.Oficina, av); err != nil {
-				log.Println("Error guardando aviso:", err)
-			} else {
-				log.Printf("[AVISO] Oficina:%s Tipo:%s Más:%s\n", datos.Oficina, av.IDTipo, av.Adicional)
-			}
-		}
+	for _, av := range detectarAvisos(datos, estado) {
+		metricaAvisos.WithLabelValues(av.IDTipo).Inc()
+		ingesta.escribir(escrituraAviso(datos.Oficina, av))
+	}
+	if automatizacion != nil {
+		automatizacion.evaluar(datos)
+	}
+	if deslastre != nil {
+		deslastre.registrar(datos)
+	}
+	if escalamiento != nil {
+		escalamiento.registrar(datos)
+	}
+	if llegada := datos.llegada(); llegada > estado.UltimaRecepcion {
+		estado.UltimaRecepcion = llegada
+	}
+}
 
-		if ahora-estado.UltimoResumen >= 60 {
-			resumen := generarResumen(ahora, estado)
-			if err := guardarResumen(ctx, datos.Oficina, resumen); err != nil {
-				log.Println("Error guardando resumen:", err)
-			} else {
-				estado.UltimoResumen = ahora
-				estado.Corrientes = nil
-				estado.Temperaturas = nil
-				estado.TiempoPresente = 0
-				log.Printf("[RESUMEN] Oficina:%s %+v\n", datos.Oficina, resumen)
-			}
-		}
+func acumularLectura(estado *EstadoOficina, datos DatosSensor) {
+	estado.Corrientes = append(estado.Corrientes, datos.CorrienteA)
+	estado.Temperaturas = append(estado.Temperaturas, datos.Temperatura)
+	if datos.Presencia {
+		estado.TiempoPresente += segundosPorLectura()
+	}
+}
+
+// procesarLecturaTardia ubica una lectura que llegó después de otras más
+// nuevas: si su ventana sigue abierta se suma a ella; si no, se guarda como
+// corrección. No genera avisos, que ya no serían actuales.
+func procesarLecturaTardia(datos DatosSensor) {
+	estado := obtenerEstado(datos.Oficina)
+	estado.Mutex.Lock()
+	defer estado.Mutex.Unlock()
+
+	if len(estado.Corrientes) > 0 && datos.Timestamp >= estado.InicioVentana {
+		metricaLecturasTardias.WithLabelValues("ventana").Inc()
+		acumularLectura(estado, datos)
+		return
+	}
+
+	metricaLecturasTardias.WithLabelValues("correccion").Inc()
+	mu.RLock()
+	voltaje := config.Voltaje
+	mu.RUnlock()
+	horas := float64(segundosPorLectura()) / 3600.0
+	correccion := Correccion{
+		Timestamp:   datos.Timestamp,
+		Recibida:    time.Now().Unix(),
+		CorrienteA:  datos.CorrienteA,
+		ConsumoKvh:  math.Round(datos.CorrienteA*voltaje*horas/1000.0*10000) / 10000,
+		Temperatura: datos.Temperatura,
+		Presencia:   datos.Presencia,
+	}
+	ingesta.escribir(escritura{
+		operacion: "correccion",
+		ruta:      rutaFirebase("oficinas/%s/correcciones", datos.Oficina),
+		valor:     correccion,
+		agregar:   true,
 	})
+}
 
-	select {}
+// cerrarVentana genera el resumen de las lecturas acumuladas, lo encola para
+// guardarlo y reinicia la ventana. Se llama con estado.Mutex tomado.
+func cerrarVentana(oficina string, estado *EstadoOficina, ahora int64) {
+	resumen := generarResumen(ahora, estado)
+	estado.UltimoResumen = ahora
+	estado.Corrientes = nil
+	estado.Temperaturas = nil
+	estado.TiempoPresente = 0
+
+	ingesta.escribir(escrituraResumen(oficina, resumen))
+	registrarEmisiones(oficina, resumen)
+	for _, e := range escriturasEmisiones(oficina) {
+		ingesta.escribir(e)
+	}
+}
+
+// cerrarVentanasPendientes genera un resumen parcial de cada oficina con
+// lecturas acumuladas. Se usa al detener el subscriber.
+func cerrarVentanasPendientes() {
+	ahora := time.Now().Unix()
+	for _, oficina := range listarOficinasConEstado() {
+		estado := obtenerEstado(oficina)
+		estado.Mutex.Lock()
+		if len(estado.Corrientes) > 0 {
+			cerrarVentana(oficina, estado, ahora)
+			log.Printf("💾 Resumen parcial de %s encolado", oficina)
+		}
+		estado.Mutex.Unlock()
+	}
+}
+
+func listarOficinasConEstado() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	lista := make([]string, 0, len(mapaEstados))
+	for oficina := range mapaEstados {
+		lista = append(lista, oficina)
+	}
+	return lista
 }
 
 // En subscriber/main.go, agrega esta función
@@ -487,10 +881,29 @@ func manejarEliminarOficina(data []byte) {
 			break
 		}
 	}
+	mu.Unlock()
+
+	// Primero se detiene su trabajador: las lecturas que tenía en cola
+	// volverían a crear el estado y a escribir resúmenes y avisos.
+	if ingesta != nil {
+		ingesta.quitar(oficina)
+	}
 
 	// Eliminar del mapa de estados
+	mu.Lock()
 	delete(mapaEstados, oficina)
+	delete(emisionesPorOficina, oficina)
 	mu.Unlock()
+	olvidarMetricasOficina(oficina)
+	if automatizacion != nil {
+		automatizacion.olvidar(oficina)
+	}
+	if deslastre != nil {
+		deslastre.olvidar(oficina)
+	}
+	if escalamiento != nil {
+		escalamiento.olvidar(oficina)
+	}
 
 	// Eliminar de Firebase
 	ctx := context.Background()
@@ -503,17 +916,17 @@ func manejarEliminarOficina(data []byte) {
 
 // Función para eliminar oficina de Firebase
 func eliminarOficinaFirebase(ctx context.Context, oficina string) error {
-	ref := clienteFirebase.NewRef(fmt.Sprintf("monitoreo_consumo/oficinas/%s", oficina))
+	ref := clienteFirebase.NewRef(rutaFirebase("oficinas/%s", oficina))
 	if err := ref.Delete(ctx); err != nil {
 		return fmt.Errorf("error eliminando oficina de Firebase: %v", err)
 	}
 
 	// También eliminar resumenes y avisos asociados
-	refResumenes := clienteFirebase.NewRef(fmt.Sprintf("monitoreo_consumo/resumenes/%s", oficina))
-	refResumenes.Delete(ctx)
-
-	refAvisos := clienteFirebase.NewRef(fmt.Sprintf("monitoreo_consumo/avisos/%s", oficina))
-	refAvisos.Delete(ctx)
-
-	return nil
+	var errs []error
+	for _, ruta := range []string{rutaFirebase("resumenes/%s", oficina), rutaFirebase("avisos/%s", oficina)} {
+		if err := clienteFirebase.NewRef(ruta).Delete(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error eliminando %s de Firebase: %v", ruta, err))
+		}
+	}
+	return errors.Join(errs...)
 }
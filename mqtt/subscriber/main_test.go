@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// usarIngestaDePrueba arranca una ingesta que guarda en clienteFirebase, sin
+// esperar lecturas fuera de orden, y la cierra al terminar la prueba.
+func usarIngestaDePrueba(t *testing.T) {
+	t.Helper()
+	anteriorCfg, anteriorIngesta := cfg, ingesta
+	mu.Lock()
+	anteriorConfig, anterioresEstados := config, mapaEstados
+	config = paramsPorDefecto
+	mapaEstados = make(map[string]*EstadoOficina)
+	mu.Unlock()
+	cfg = cfgPorDefecto
+	cfg.RetrasoMax = 0
+	ingesta = nuevaIngesta(cfg, guardarEnFirebase)
+	ingesta.iniciar(context.Background())
+
+	t.Cleanup(func() {
+		ctx, cancelar := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelar()
+		ingesta.cerrar(ctx)
+		cfg, ingesta = anteriorCfg, anteriorIngesta
+		mu.Lock()
+		config, mapaEstados = anteriorConfig, anterioresEstados
+		mu.Unlock()
+	})
+}
+
+func TestManejarEliminarOficina(t *testing.T) {
+	f := usarFirebaseDePrueba(t, `{"monitoreo_consumo": {"oficinas": {
+		"A": {"activa": true, "resumenes": {"r1": {"timestamp": 1}}},
+		"B": {"activa": true}
+	}}}`)
+	conOficinas(t, "A", "B")
+	conEmisiones(t, map[string]RollupEmisiones{})
+	usarIngestaDePrueba(t)
+
+	// Con el estado de A tomado, el trabajador se queda en la primera
+	// lectura y las demás esperan en su cola.
+	bloqueado := &EstadoOficina{}
+	mu.Lock()
+	mapaEstados["A"] = bloqueado
+	mu.Unlock()
+	bloqueado.Mutex.Lock()
+
+	colaDeA := func() (int, bool) {
+		ingesta.mu.RLock()
+		defer ingesta.mu.RUnlock()
+		cola, existe := ingesta.colas["A"]
+		if !existe {
+			return 0, false
+		}
+		return len(cola.lecturas), true
+	}
+	esperar := func(que string, condicion func() bool) {
+		t.Helper()
+		limite := time.Now().Add(5 * time.Second)
+		for !condicion() {
+			if time.Now().After(limite) {
+				bloqueado.Mutex.Unlock()
+				t.Fatalf("no se cumplió: %s", que)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	inicio := time.Now().Unix() - 600
+	ingesta.Encolar(DatosSensor{Oficina: "A", Timestamp: inicio, Secuencia: 1, CorrienteA: 5})
+	esperar("el trabajador toma la primera lectura", func() bool { n, _ := colaDeA(); return n == 0 })
+	// Cada una cerraría la ventana anterior y escribiría un resumen.
+	for i := int64(1); i <= 3; i++ {
+		ingesta.Encolar(DatosSensor{Oficina: "A", Timestamp: inicio + 70*i, Secuencia: uint64(i + 1), CorrienteA: 5})
+	}
+
+	eliminada := make(chan struct{})
+	go func() {
+		manejarEliminarOficina([]byte(`{"tipo": "eliminar_oficina", "data": {"oficina": "A"}}`))
+		close(eliminada)
+	}()
+	esperar("quitar la cola de A", func() bool { _, existe := colaDeA(); return !existe })
+	select {
+	case <-eliminada:
+		bloqueado.Mutex.Unlock()
+		t.Fatal("se eliminó la oficina sin esperar a su trabajador")
+	case <-time.After(50 * time.Millisecond):
+	}
+	bloqueado.Mutex.Unlock()
+	select {
+	case <-eliminada:
+	case <-time.After(5 * time.Second):
+		t.Fatal("manejarEliminarOficina no terminó")
+	}
+
+	mu.RLock()
+	_, conEstado := mapaEstados["A"]
+	_, conAcumulado := emisionesPorOficina["A"]
+	mu.RUnlock()
+	if conEstado || conAcumulado {
+		t.Errorf("quedó estado (%v) o acumulado de emisiones (%v) de A", conEstado, conAcumulado)
+	}
+	if f.existe("monitoreo_consumo/oficinas/A") || f.existe("monitoreo_consumo/emisiones/oficinas/A") {
+		t.Error("quedaron datos de A en Firebase")
+	}
+	if !f.existe("monitoreo_consumo/oficinas/B") {
+		t.Error("se borró B")
+	}
+	if existeOficina("A") || !existeOficina("B") {
+		t.Errorf("oficinas: %v", listarOficinas())
+	}
+}
+
+func TestEliminarOficinaFirebase(t *testing.T) {
+	const datos = `{"monitoreo_consumo": {
+		"oficinas": {"A": {"activa": true}, "B": {"activa": true}},
+		"resumenes": {"A": {"r1": {"timestamp": 1}}},
+		"avisos": {"A": {"a1": {"timestamp": 1}}}
+	}}`
+	casos := []struct {
+		nombre    string
+		falla     string
+		error     string
+		quedan    []string
+		eliminada []string
+	}{
+		{"sin fallas", "", "", nil, []string{"oficinas/A", "resumenes/A", "avisos/A"}},
+		{"falla la oficina", "oficinas/A", "eliminando oficina", []string{"oficinas/A", "resumenes/A", "avisos/A"}, nil},
+		{"fallan los resúmenes", "resumenes/A", "resumenes/A", []string{"resumenes/A"}, []string{"oficinas/A", "avisos/A"}},
+		{"fallan los avisos", "avisos/A", "avisos/A", []string{"avisos/A"}, []string{"oficinas/A", "resumenes/A"}},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			f := usarFirebaseDePrueba(t, datos)
+			if c.falla != "" {
+				f.fallas["DELETE monitoreo_consumo/"+c.falla] = http.StatusForbidden
+			}
+			err := eliminarOficinaFirebase(context.Background(), "A")
+			if c.error == "" && err != nil {
+				t.Errorf("eliminarOficinaFirebase: %v", err)
+			}
+			if c.error != "" && (err == nil || !strings.Contains(err.Error(), c.error)) {
+				t.Errorf("eliminarOficinaFirebase: %v; se esperaba un error con %q", err, c.error)
+			}
+			for _, ruta := range c.quedan {
+				if !f.existe("monitoreo_consumo/" + ruta) {
+					t.Errorf("se borró %s", ruta)
+				}
+			}
+			for _, ruta := range c.eliminada {
+				if f.existe("monitoreo_consumo/" + ruta) {
+					t.Errorf("no se borró %s", ruta)
+				}
+			}
+			if !f.existe("monitoreo_consumo/oficinas/B") {
+				t.Error("se borró B")
+			}
+		})
+	}
+}
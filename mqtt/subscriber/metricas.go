@@ -0,0 +1,206 @@
+package main
+
+import (
+	"time"
+
+	"monitoreo_consumo/internal/metricas"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricaMensajesRecibidos = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "mensajes_recibidos_total",
+		Help:      "Mensajes MQTT de sensores recibidos por oficina.",
+	}, []string{"oficina"})
+
+	metricaErroresParseo = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "errores_parseo_total",
+		Help:      "Mensajes MQTT descartados por JSON inválido.",
+	})
+
+	metricaLatenciaFirebase = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricas.Namespace,
+		Name:      "firebase_escritura_segundos",
+		Help:      "Duración de las escrituras en Firebase por operación.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operacion"})
+
+	metricaErroresFirebase = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "firebase_errores_total",
+		Help:      "Escrituras fallidas en Firebase por operación.",
+	}, []string{"operacion"})
+
+	metricaAvisos = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "avisos_total",
+		Help:      "Avisos generados por tipo.",
+	}, []string{"tipo"})
+
+	metricaCorriente = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "corriente_amperes",
+		Help:      "Última corriente leída por oficina.",
+	}, []string{"oficina"})
+
+	metricaTemperatura = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "temperatura_celsius",
+		Help:      "Última temperatura leída por oficina.",
+	}, []string{"oficina"})
+
+	metricaDeadLetter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "deadletter_total",
+		Help:      "Mensajes MQTT que no se pudieron decodificar, enviados a dead-letter.",
+	})
+
+	metricaLecturasArchivadas = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "archivo_lecturas_total",
+		Help:      "Lecturas escritas en el archivo de lecturas crudas.",
+	})
+
+	metricaErroresArchivo = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "archivo_errores_total",
+		Help:      "Errores al escribir el archivo de lecturas crudas.",
+	})
+
+	metricaAccionesAutomaticas = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "automatizacion_acciones_total",
+		Help:      "Apagados automáticos de dispositivos, por dispositivo y resultado (ok o error).",
+	}, []string{"dispositivo", "resultado"})
+
+	metricaAccionesProgramadas = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "programacion_acciones_total",
+		Help:      "Acciones programadas sobre dispositivos, por dispositivo y resultado (ok, error u omitida).",
+	}, []string{"dispositivo", "resultado"})
+
+	metricaCorrienteEdificio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "deslastre_corriente_edificio_amperes",
+		Help:      "Suma de la última corriente de las oficinas de cada edificio con límite.",
+	}, []string{"edificio"})
+
+	metricaAiresDeslastrados = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "deslastre_aires_apagados",
+		Help:      "Aires apagados por el deslastre de carga en cada edificio.",
+	}, []string{"edificio"})
+
+	metricaAccionesDeslastre = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "deslastre_acciones_total",
+		Help:      "Aires apagados y restablecidos por el deslastre de carga, por resultado (ok o error).",
+	}, []string{"accion", "resultado"})
+
+	metricaNotificaciones = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "notificaciones_total",
+		Help:      "Notificaciones de avisos por canal y resultado (enviada, error o descartada).",
+	}, []string{"canal", "resultado"})
+
+	metricaEscalamientos = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "escalamiento_notificaciones_total",
+		Help:      "Niveles de escalamiento avisados, por nivel.",
+	}, []string{"nivel"})
+
+	metricaIncidentesAbiertos = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "escalamiento_incidentes_abiertos",
+		Help:      "Incidentes abiertos que siguen escalando o esperan resolverse.",
+	})
+
+	metricaCuarentena = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "lecturas_cuarentena_total",
+		Help:      "Lecturas rechazadas por validación, por motivo.",
+	}, []string{"motivo"})
+
+	metricaDesfaseReloj = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "desfase_reloj_segundos",
+		Help:      "Desfase estimado del reloj del sensor respecto del subscriber, por oficina.",
+	}, []string{"oficina"})
+
+	metricaTrabajadores = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "ingesta_trabajadores",
+		Help:      "Goroutines de procesamiento activas, una por oficina.",
+	})
+
+	metricaColaLecturas = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "ingesta_cola_lecturas",
+		Help:      "Lecturas encoladas esperando a su trabajador, sumando todas las oficinas.",
+	})
+
+	metricaLecturasDescartadas = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "ingesta_lecturas_descartadas_total",
+		Help:      "Lecturas descartadas por tener llena la cola de su oficina.",
+	})
+
+	metricaLecturasDuplicadas = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "ingesta_lecturas_duplicadas_total",
+		Help:      "Lecturas descartadas por repetir timestamp y secuencia de otra ya recibida.",
+	})
+
+	metricaLecturasReordenadas = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "ingesta_lecturas_reordenadas_total",
+		Help:      "Lecturas recibidas fuera de orden dentro del retraso admitido.",
+	})
+
+	metricaLecturasTardias = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricas.Namespace,
+		Name:      "ingesta_lecturas_tardias_total",
+		Help:      "Lecturas llegadas después del retraso admitido, por destino (ventana o correccion).",
+	}, []string{"destino"})
+
+	metricaColaEscrituras = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricas.Namespace,
+		Name:      "ingesta_cola_escrituras",
+		Help:      "Escrituras esperando al escritor de Firebase.",
+	})
+
+	metricaLoteEscrituras = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricas.Namespace,
+		Name:      "ingesta_lote_escrituras",
+		Help:      "Escrituras por lote del escritor de Firebase.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+)
+
+// medirFirebase ejecuta una escritura registrando su duración y si falló.
+func medirFirebase(operacion string, escribir func() error) error {
+	inicio := time.Now()
+	err := escribir()
+	metricaLatenciaFirebase.WithLabelValues(operacion).Observe(time.Since(inicio).Seconds())
+	if err != nil {
+		metricaErroresFirebase.WithLabelValues(operacion).Inc()
+	}
+	return err
+}
+
+func registrarLectura(datos DatosSensor) {
+	metricaMensajesRecibidos.WithLabelValues(datos.Oficina).Inc()
+	metricaCorriente.WithLabelValues(datos.Oficina).Set(datos.CorrienteA)
+	metricaTemperatura.WithLabelValues(datos.Oficina).Set(datos.Temperatura)
+}
+
+func olvidarMetricasOficina(oficina string) {
+	metricaMensajesRecibidos.DeleteLabelValues(oficina)
+	metricaCorriente.DeleteLabelValues(oficina)
+	metricaTemperatura.DeleteLabelValues(oficina)
+	metricaDesfaseReloj.DeleteLabelValues(oficina)
+}
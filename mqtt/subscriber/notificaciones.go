@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	tamanoColaNotificaciones = 100
+	porMinutoPorDefecto      = 10
+	reintentosPorDefecto     = 3
+	esperaReintentoInicial   = 2 * time.Second
+	esperaReintentoMaxima    = time.Minute
+	esperaWebhook            = 10 * time.Second
+
+	encabezadoFirma     = "X-Monitoreo-Firma"
+	encabezadoTimestamp = "X-Monitoreo-Timestamp"
+)
+
+const (
+	plantillaAsuntoPorDefecto = "[Impacto {{.Impacto}}] {{.Motivo}} en la oficina {{.Oficina}}"
+	plantillaCuerpoPorDefecto = `{{.Motivo}} en la oficina {{.Oficina}}
+Hora: {{.Hora}}
+Tipo: {{.IDTipo}} (impacto {{.Impacto}})
+{{if .Detalle}}Detalle: {{.Detalle}}
+{{end}}{{if .Adicional}}Adicional: {{.Adicional}}
+{{end}}{{if .Nivel}}Escalamiento: nivel {{.Nivel}}, sin reconocer
+{{end}}`
+)
+
+// Notificacion es el aviso con los datos de su tipo, tal como lo ven las
+// plantillas y el cuerpo por defecto de los webhooks.
+type Notificacion struct {
+	Oficina   string `json:"oficina"`
+	IDTipo    string `json:"id_tipo"`
+	Motivo    string `json:"motivo"`
+	Detalle   string `json:"detalle,omitempty"`
+	Impacto   int64  `json:"impacto"`
+	Adicional string `json:"adicional,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+	Hora      string `json:"hora"`
+	// Nivel es el nivel de escalamiento de un incidente; 0 en los avisos.
+	Nivel int `json:"nivel,omitempty"`
+}
+
+func nuevaNotificacion(oficina string, aviso Aviso, tipo TipoAviso) Notificacion {
+	motivo := tipo.Motivo
+	if motivo == "" {
+		motivo = "Aviso " + aviso.IDTipo
+	}
+	return Notificacion{
+		Oficina:   oficina,
+		IDTipo:    aviso.IDTipo,
+		Motivo:    motivo,
+		Detalle:   tipo.Detalle,
+		Impacto:   tipo.Impacto,
+		Adicional: aviso.Adicional,
+		Timestamp: aviso.Timestamp,
+		Hora:      time.Unix(aviso.Timestamp, 0).Format("2006-01-02 15:04:05"),
+	}
+}
+
+// CanalNotificacion es un destino de los avisos: un webhook HTTP o un correo
+// SMTP. Recibe los avisos con impacto entre ImpactoMin e ImpactoMax (0 sin
+// tope) y, si se indican, solo los de Tipos. Secreto y Clave admiten
+// variables de entorno ("$WEBHOOK_SECRETO") para no dejarlos en el archivo.
+type CanalNotificacion struct {
+	Nombre     string   `json:"nombre"`
+	Tipo       string   `json:"tipo"`
+	ImpactoMin int64    `json:"impacto_min,omitempty"`
+	ImpactoMax int64    `json:"impacto_max,omitempty"`
+	Tipos      []string `json:"tipos,omitempty"`
+	// SoloEscalamiento deja el canal para los niveles de escalamiento: no
+	// recibe los avisos a medida que se guardan.
+	SoloEscalamiento bool `json:"solo_escalamiento,omitempty"`
+
+	URL         string `json:"url,omitempty"`
+	Secreto     string `json:"secreto,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+
+	SMTP    string   `json:"smtp,omitempty"`
+	Usuario string   `json:"usuario,omitempty"`
+	Clave   string   `json:"clave,omitempty"`
+	De      string   `json:"de,omitempty"`
+	Para    []string `json:"para,omitempty"`
+	Asunto  string   `json:"asunto,omitempty"`
+
+	// Plantilla es el cuerpo del correo o del webhook, en text/template.
+	// Vacía, el correo usa un texto fijo y el webhook la Notificacion en
+	// JSON.
+	Plantilla  string `json:"plantilla,omitempty"`
+	PorMinuto  int    `json:"por_minuto,omitempty"`
+	Reintentos int    `json:"reintentos,omitempty"`
+}
+
+func (c CanalNotificacion) recibe(n Notificacion) bool {
+	if c.SoloEscalamiento {
+		return false
+	}
+	if n.Impacto < c.ImpactoMin || (c.ImpactoMax > 0 && n.Impacto > c.ImpactoMax) {
+		return false
+	}
+	return len(c.Tipos) == 0 || contiene(c.Tipos, n.IDTipo)
+}
+
+// ConfigNotificaciones es el archivo de la opción notificaciones.
+type ConfigNotificaciones struct {
+	Canales []CanalNotificacion `json:"canales"`
+}
+
+func cargarConfigNotificaciones(ruta string) (ConfigNotificaciones, error) {
+	var c ConfigNotificaciones
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(datos, &c); err != nil {
+		return c, fmt.Errorf("%s: %v", ruta, err)
+	}
+	return c, nil
+}
+
+// errPermanente marca un envío que no tiene sentido reintentar.
+type errPermanente struct{ error }
+
+// canal es un CanalNotificacion listo para enviar, con su cola y su límite.
+type canal struct {
+	cfg       CanalNotificacion
+	asunto    *template.Template
+	cuerpo    *template.Template
+	limitador *limitador
+	cola      chan Notificacion
+	enviar    func(ctx context.Context, n Notificacion) error
+}
+
+func nuevoCanal(c CanalNotificacion) (*canal, error) {
+	c.Secreto = os.ExpandEnv(c.Secreto)
+	c.Clave = os.ExpandEnv(c.Clave)
+	if c.Nombre == "" {
+		return nil, errors.New("canal sin nombre")
+	}
+	if c.PorMinuto <= 0 {
+		c.PorMinuto = porMinutoPorDefecto
+	}
+	if c.Reintentos <= 0 {
+		c.Reintentos = reintentosPorDefecto
+	}
+	k := &canal{
+		cfg:       c,
+		limitador: nuevoLimitador(c.PorMinuto),
+		cola:      make(chan Notificacion, tamanoColaNotificaciones),
+	}
+
+	plantilla := c.Plantilla
+	switch c.Tipo {
+	case "webhook":
+		if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+			return nil, fmt.Errorf("canal %s: url inválida %q", c.Nombre, c.URL)
+		}
+		if k.cfg.ContentType == "" {
+			k.cfg.ContentType = "application/json"
+		}
+		k.enviar = k.enviarWebhook
+	case "email":
+		if _, _, err := net.SplitHostPort(c.SMTP); err != nil {
+			return nil, fmt.Errorf("canal %s: smtp inválido %q: %v", c.Nombre, c.SMTP, err)
+		}
+		if c.De == "" || len(c.Para) == 0 {
+			return nil, fmt.Errorf("canal %s: faltan de o para", c.Nombre)
+		}
+		asunto := c.Asunto
+		if asunto == "" {
+			asunto = plantillaAsuntoPorDefecto
+		}
+		var err error
+		if k.asunto, err = template.New("asunto").Parse(asunto); err != nil {
+			return nil, fmt.Errorf("canal %s: asunto: %v", c.Nombre, err)
+		}
+		if plantilla == "" {
+			plantilla = plantillaCuerpoPorDefecto
+		}
+		k.enviar = k.enviarEmail
+	default:
+		return nil, fmt.Errorf("canal %s: tipo desconocido %q (webhook o email)", c.Nombre, c.Tipo)
+	}
+	if plantilla != "" {
+		var err error
+		if k.cuerpo, err = template.New("cuerpo").Parse(plantilla); err != nil {
+			return nil, fmt.Errorf("canal %s: plantilla: %v", c.Nombre, err)
+		}
+	}
+	return k, nil
+}
+
+func ejecutarPlantilla(t *template.Template, n Notificacion) ([]byte, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, n); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// firmarWebhook firma "<timestamp>.<cuerpo>" con HMAC-SHA256. Incluir el
+// timestamp permite al receptor rechazar reenvíos viejos.
+func firmarWebhook(secreto string, timestamp int64, cuerpo []byte) string {
+	mac := hmac.New(sha256.New, []byte(secreto))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(cuerpo)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (k *canal) enviarWebhook(ctx context.Context, n Notificacion) error {
+	cuerpo, err := json.Marshal(n)
+	if k.cuerpo != nil {
+		cuerpo, err = ejecutarPlantilla(k.cuerpo, n)
+	}
+	if err != nil {
+		return errPermanente{err}
+	}
+
+	ctx, cancelar := context.WithTimeout(ctx, esperaWebhook)
+	defer cancelar()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.cfg.URL, bytes.NewReader(cuerpo))
+	if err != nil {
+		return errPermanente{err}
+	}
+	req.Header.Set("Content-Type", k.cfg.ContentType)
+	if k.cfg.Secreto != "" {
+		ahora := time.Now().Unix()
+		req.Header.Set(encabezadoTimestamp, strconv.FormatInt(ahora, 10))
+		req.Header.Set(encabezadoFirma, firmarWebhook(k.cfg.Secreto, ahora, cuerpo))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("respuesta %s", resp.Status)
+	}
+	return errPermanente{fmt.Errorf("respuesta %s", resp.Status)}
+}
+
+func (k *canal) enviarEmail(_ context.Context, n Notificacion) error {
+	asunto, err := ejecutarPlantilla(k.asunto, n)
+	if err != nil {
+		return errPermanente{err}
+	}
+	cuerpo, err := ejecutarPlantilla(k.cuerpo, n)
+	if err != nil {
+		return errPermanente{err}
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", k.cfg.De)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(k.cfg.Para, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(string(asunto))))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write(cuerpo)
+	qp.Close()
+
+	var auth smtp.Auth
+	if k.cfg.Usuario != "" {
+		host, _, _ := net.SplitHostPort(k.cfg.SMTP)
+		auth = smtp.PlainAuth("", k.cfg.Usuario, k.cfg.Clave, host)
+	}
+	err = smtp.SendMail(k.cfg.SMTP, auth, k.cfg.De, k.cfg.Para, msg.Bytes())
+	// Las respuestas 5xx de SMTP son rechazos definitivos (destinatario
+	// inexistente, autenticación inválida); las 4xx se reintentan.
+	var rechazo *textproto.Error
+	if errors.As(err, &rechazo) && rechazo.Code >= 500 {
+		return errPermanente{err}
+	}
+	return err
+}
+
+// entregar envía con reintentos y espera exponencial. Los errores
+// permanentes, como un 4xx del webhook o un 5xx de SMTP, no se reintentan.
+func (k *canal) entregar(ctx context.Context, n Notificacion) error {
+	espera := esperaReintentoInicial
+	for intento := 0; ; intento++ {
+		err := k.enviar(ctx, n)
+		var permanente errPermanente
+		if err == nil || errors.As(err, &permanente) || intento >= k.cfg.Reintentos {
+			return err
+		}
+		log.Printf("⚠️  Notificación por %s falló (intento %d): %v", k.cfg.Nombre, intento+1, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(espera):
+		}
+		if espera *= 2; espera > esperaReintentoMaxima {
+			espera = esperaReintentoMaxima
+		}
+	}
+}
+
+func (k *canal) ejecutar(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			if n := len(k.cola); n > 0 {
+				log.Printf("⚠️  Se descartan %d notificaciones pendientes de %s", n, k.cfg.Nombre)
+			}
+			return
+		case n := <-k.cola:
+			if !k.limitador.esperar(ctx) {
+				continue
+			}
+			if err := k.entregar(ctx, n); err != nil {
+				metricaNotificaciones.WithLabelValues(k.cfg.Nombre, "error").Inc()
+				log.Printf("❌ No se pudo notificar el aviso %s de %s por %s: %v", n.IDTipo, n.Oficina, k.cfg.Nombre, err)
+				continue
+			}
+			metricaNotificaciones.WithLabelValues(k.cfg.Nombre, "enviada").Inc()
+		}
+	}
+}
+
+// limitador es un balde de fichas: admite ráfagas de hasta porMinuto envíos
+// y recupera una ficha cada minuto/porMinuto.
+type limitador struct {
+	mu       sync.Mutex
+	fichas   float64
+	maximo   float64
+	periodo  time.Duration
+	anterior time.Time
+}
+
+func nuevoLimitador(porMinuto int) *limitador {
+	return &limitador{
+		fichas:   float64(porMinuto),
+		maximo:   float64(porMinuto),
+		periodo:  time.Minute / time.Duration(porMinuto),
+		anterior: time.Now(),
+	}
+}
+
+// esperar bloquea hasta tener una ficha. Devuelve false si se cancela ctx.
+func (l *limitador) esperar(ctx context.Context) bool {
+	for {
+		l.mu.Lock()
+		ahora := time.Now()
+		l.fichas += float64(ahora.Sub(l.anterior)) / float64(l.periodo)
+		if l.fichas > l.maximo {
+			l.fichas = l.maximo
+		}
+		l.anterior = ahora
+		if l.fichas >= 1 {
+			l.fichas--
+			l.mu.Unlock()
+			return true
+		}
+		falta := time.Duration((1 - l.fichas) * float64(l.periodo))
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(falta):
+		}
+	}
+}
+
+// Notificador reparte los avisos guardados entre los canales que los
+// reciben. Cada canal tiene su cola y su goroutine, así que uno lento o
+// caído no demora a los demás ni a la ingesta.
+type Notificador struct {
+	canales []*canal
+}
+
+var notificador *Notificador
+
+func nuevoNotificador(c ConfigNotificaciones) (*Notificador, error) {
+	if len(c.Canales) == 0 {
+		return nil, errors.New("no hay canales de notificación")
+	}
+	nombres := make(map[string]bool)
+	n := &Notificador{}
+	for _, cfgCanal := range c.Canales {
+		k, err := nuevoCanal(cfgCanal)
+		if err != nil {
+			return nil, err
+		}
+		if nombres[cfgCanal.Nombre] {
+			return nil, fmt.Errorf("canal repetido: %s", cfgCanal.Nombre)
+		}
+		nombres[cfgCanal.Nombre] = true
+		n.canales = append(n.canales, k)
+	}
+	return n, nil
+}
+
+func (n *Notificador) iniciar(ctx context.Context, iniciarServicio func(func())) {
+	for _, k := range n.canales {
+		k := k
+		iniciarServicio(func() { k.ejecutar(ctx) })
+	}
+}
+
+// notificar encola el aviso en los canales que lo reciben sin bloquear: si
+// la cola de un canal está llena, el aviso se descarta para ese canal.
+func (n *Notificador) notificar(oficina string, aviso Aviso) {
+	mu.RLock()
+	tipo := tiposAvisos[aviso.IDTipo]
+	mu.RUnlock()
+	notificacion := nuevaNotificacion(oficina, aviso, tipo)
+
+	for _, k := range n.canales {
+		if k.cfg.recibe(notificacion) {
+			k.encolar(notificacion)
+		}
+	}
+}
+
+func (n *Notificador) canal(nombre string) *canal {
+	for _, k := range n.canales {
+		if k.cfg.Nombre == nombre {
+			return k
+		}
+	}
+	return nil
+}
+
+// enviarA encola la notificación en un canal sin mirar su ruteo.
+func (n *Notificador) enviarA(nombre string, notificacion Notificacion) {
+	if k := n.canal(nombre); k != nil {
+		k.encolar(notificacion)
+	}
+}
+
+func (k *canal) encolar(n Notificacion) {
+	select {
+	case k.cola <- n:
+	default:
+		metricaNotificaciones.WithLabelValues(k.cfg.Nombre, "descartada").Inc()
+		log.Printf("⚠️  Cola de notificaciones de %s llena, se descarta el aviso %s de %s", k.cfg.Nombre, n.IDTipo, n.Oficina)
+	}
+}
+
+func comandoNotificaciones(args []string) error {
+	if len(args) == 0 {
+		return errors.New("uso: notificaciones probar [-oficina X] [-tipo ID] [-motivo M] [-impacto N] [-canal C] | receptor [-http :8025] [-smtp :2525] [-secreto S]")
+	}
+	switch args[0] {
+	case "probar":
+		return probarNotificaciones(args[1:])
+	case "receptor":
+		return comandoReceptor(args[1:])
+	}
+	return fmt.Errorf("subcomando desconocido: notificaciones %s", args[0])
+}
+
+// probarNotificaciones envía un aviso de prueba a los canales que lo reciben
+// según su impacto y tipo, o solo al indicado en -canal, sin límite de
+// envíos, y muestra el resultado.
+func probarNotificaciones(args []string) error {
+	if cfg.Notificaciones == "" {
+		return errors.New("notificaciones no está configurado")
+	}
+	fs := flag.NewFlagSet("notificaciones probar", flag.ContinueOnError)
+	oficina := fs.String("oficina", "A", "oficina del aviso de prueba")
+	idTipo := fs.String("tipo", "0", "ID de tipo de aviso")
+	motivo := fs.String("motivo", "Aviso de prueba", "motivo del aviso")
+	impacto := fs.Int64("impacto", 3, "impacto del aviso")
+	nombre := fs.String("canal", "", "enviar solo a este canal, sin mirar su ruteo")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := cargarConfigNotificaciones(cfg.Notificaciones)
+	if err != nil {
+		return err
+	}
+	n, err := nuevoNotificador(c)
+	if err != nil {
+		return err
+	}
+	if *nombre != "" && n.canal(*nombre) == nil {
+		return fmt.Errorf("canal desconocido: %s", *nombre)
+	}
+	aviso := Aviso{Timestamp: time.Now().Unix(), IDTipo: *idTipo, Adicional: "Enviado con notificaciones probar"}
+	notificacion := nuevaNotificacion(*oficina, aviso, TipoAviso{Motivo: *motivo, Impacto: *impacto})
+
+	fallas := 0
+	for _, k := range n.canales {
+		if *nombre != "" && k.cfg.Nombre != *nombre {
+			continue
+		}
+		if *nombre == "" && !k.cfg.recibe(notificacion) {
+			fmt.Printf("%-20s no recibe avisos de impacto %d y tipo %s\n", k.cfg.Nombre, *impacto, *idTipo)
+			continue
+		}
+		if err := k.entregar(context.Background(), notificacion); err != nil {
+			fallas++
+			fmt.Printf("%-20s ❌ %v\n", k.cfg.Nombre, err)
+			continue
+		}
+		fmt.Printf("%-20s ✅ enviada\n", k.cfg.Nombre)
+	}
+	if fallas > 0 {
+		return fmt.Errorf("%d canales fallaron", fallas)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFirmarWebhook(t *testing.T) {
+	cuerpo := []byte(`{"oficina":"A"}`)
+	firma := firmarWebhook("secreto", 1700000000, cuerpo)
+	if firma != firmarWebhook("secreto", 1700000000, cuerpo) {
+		t.Fatal("la firma no es determinista")
+	}
+	casos := []struct {
+		nombre    string
+		secreto   string
+		timestamp int64
+		cuerpo    string
+	}{
+		{"otro secreto", "otro", 1700000000, `{"oficina":"A"}`},
+		{"otro timestamp", "secreto", 1700000001, `{"oficina":"A"}`},
+		{"otro cuerpo", "secreto", 1700000000, `{"oficina":"B"}`},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if firmarWebhook(c.secreto, c.timestamp, []byte(c.cuerpo)) == firma {
+				t.Error("la firma no cambió")
+			}
+		})
+	}
+}
+
+func TestVerificarFirma(t *testing.T) {
+	cuerpo := []byte(`{"oficina":"A"}`)
+	ahora := time.Now().Unix()
+	encabezados := func(timestamp string, firma string) http.Header {
+		h := http.Header{}
+		h.Set(encabezadoTimestamp, timestamp)
+		h.Set(encabezadoFirma, firma)
+		return h
+	}
+	firmado := func(ts int64) http.Header {
+		return encabezados(strconv.FormatInt(ts, 10), firmarWebhook("secreto", ts, cuerpo))
+	}
+	casos := []struct {
+		nombre  string
+		secreto string
+		h       http.Header
+		cuerpo  string
+		valida  bool
+	}{
+		{"firma correcta", "secreto", firmado(ahora), string(cuerpo), true},
+		{"otro secreto", "otro", firmado(ahora), string(cuerpo), false},
+		{"cuerpo alterado", "secreto", firmado(ahora), `{"oficina":"B"}`, false},
+		{"timestamp viejo", "secreto", firmado(ahora - int64(2*toleranciaFirma/time.Second)), string(cuerpo), false},
+		{"timestamp futuro", "secreto", firmado(ahora + int64(2*toleranciaFirma/time.Second)), string(cuerpo), false},
+		{"sin timestamp", "secreto", encabezados("", firmarWebhook("secreto", ahora, cuerpo)), string(cuerpo), false},
+		{"timestamp cambiado", "secreto", encabezados(strconv.FormatInt(ahora-1, 10), firmarWebhook("secreto", ahora, cuerpo)), string(cuerpo), false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			err := verificarFirma(c.secreto, c.h, []byte(c.cuerpo))
+			if (err == nil) != c.valida {
+				t.Errorf("verificarFirma: %v; se esperaba válida %v", err, c.valida)
+			}
+		})
+	}
+}
+
+func TestLimitadorEsperar(t *testing.T) {
+	const periodo = 50 * time.Millisecond
+	casos := []struct {
+		nombre     string
+		fichas     float64
+		antiguedad time.Duration
+		cancelado  bool
+		admite     bool
+		esperaMin  time.Duration
+		fichasFin  float64
+	}{
+		{nombre: "con fichas no espera", fichas: 2, admite: true, fichasFin: 1},
+		{nombre: "sin fichas espera un período", fichas: 0, admite: true, esperaMin: periodo * 8 / 10},
+		{nombre: "media ficha espera medio período", fichas: 0.5, admite: true, esperaMin: periodo * 4 / 10},
+		{nombre: "recupera fichas con el tiempo", fichas: 0, antiguedad: 2 * periodo, admite: true, fichasFin: 1},
+		{nombre: "no acumula más que el máximo", fichas: 0, antiguedad: time.Hour, admite: true, fichasFin: 2},
+		{nombre: "cancelado no admite", fichas: 0, cancelado: true, admite: false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			l := nuevoLimitador(3)
+			l.periodo = periodo
+			l.fichas = c.fichas
+			l.anterior = time.Now().Add(-c.antiguedad)
+			ctx, cancelar := context.WithCancel(context.Background())
+			if c.cancelado {
+				cancelar()
+			} else {
+				defer cancelar()
+			}
+
+			inicio := time.Now()
+			if admite := l.esperar(ctx); admite != c.admite {
+				t.Fatalf("esperar: %v; se esperaba %v", admite, c.admite)
+			}
+			if espera := time.Since(inicio); espera < c.esperaMin {
+				t.Errorf("esperó %s; se esperaba al menos %s", espera, c.esperaMin)
+			}
+			// El tiempo que pasa durante la prueba suma una fracción de ficha.
+			if c.admite && (l.fichas < c.fichasFin || l.fichas > c.fichasFin+0.5) {
+				t.Errorf("quedaron %.2f fichas; se esperaban %.0f", l.fichas, c.fichasFin)
+			}
+		})
+	}
+}
+
+func TestEnviarEmailRechazos(t *testing.T) {
+	casos := []struct {
+		nombre     string
+		saludo     string
+		permanente bool
+	}{
+		{"5xx es permanente", "554 no se aceptan correos", true},
+		{"4xx se reintenta", "421 servicio no disponible", false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(conn, "%s\r\n", c.saludo)
+				conn.Close()
+			}()
+
+			k, err := nuevoCanal(CanalNotificacion{Nombre: "correo", Tipo: "email", SMTP: ln.Addr().String(), De: "monitoreo@example.com", Para: []string{"guardia@example.com"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = k.enviarEmail(context.Background(), Notificacion{Oficina: "A", IDTipo: "1", Motivo: "Prueba"})
+			if err == nil {
+				t.Fatal("el envío no falló")
+			}
+			var permanente errPermanente
+			if errors.As(err, &permanente) != c.permanente {
+				t.Errorf("%v: permanente %v; se esperaba %v", err, !c.permanente, c.permanente)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Cuánto tiempo se recuerdan las lecturas ya vistas para descartar
+// duplicados, contado hacia atrás desde la última liberada.
+const memoriaDuplicados = 5 * time.Minute
+
+type claveLectura struct {
+	timestamp int64
+	secuencia uint64
+}
+
+// lecturasPorTiempo es un heap de lecturas ordenadas por timestamp y
+// secuencia.
+type lecturasPorTiempo []DatosSensor
+
+func (l lecturasPorTiempo) Len() int { return len(l) }
+func (l lecturasPorTiempo) Less(i, j int) bool {
+	if l[i].Timestamp != l[j].Timestamp {
+		return l[i].Timestamp < l[j].Timestamp
+	}
+	return l[i].Secuencia < l[j].Secuencia
+}
+func (l lecturasPorTiempo) Swap(i, j int)       { l[i], l[j] = l[j], l[i] }
+func (l *lecturasPorTiempo) Push(x interface{}) { *l = append(*l, x.(DatosSensor)) }
+func (l *lecturasPorTiempo) Pop() interface{} {
+	viejo := *l
+	x := viejo[len(viejo)-1]
+	*l = viejo[:len(viejo)-1]
+	return x
+}
+
+// ordenLecturas reordena las lecturas de una oficina según su timestamp.
+// Retiene cada lectura hasta que llega otra retraso segundos más nueva (o
+// hasta que se vacía por inactividad) y descarta las repetidas. Lo usa solo
+// el trabajador de la oficina, así que no necesita mutex.
+type ordenLecturas struct {
+	retraso   int64
+	retenidas lecturasPorTiempo
+	vistas    map[claveLectura]struct{}
+	// Mayor timestamp recibido y timestamp de la última lectura liberada.
+	maximo   int64
+	liberado int64
+}
+
+func nuevoOrden(retraso time.Duration) *ordenLecturas {
+	return &ordenLecturas{
+		retraso: int64(retraso / time.Second),
+		vistas:  make(map[claveLectura]struct{}),
+	}
+}
+
+// agregar devuelve, en orden, las lecturas que ya se pueden procesar, y las
+// que llegaron después de haber liberado lecturas más nuevas.
+func (o *ordenLecturas) agregar(datos DatosSensor) (listas, tardias []DatosSensor) {
+	clave := claveLectura{datos.Timestamp, datos.Secuencia}
+	if datos.original != 0 {
+		// Una lectura reestampada repetida tiene otra hora de llegada.
+		clave.timestamp = datos.original
+	}
+	if _, vista := o.vistas[clave]; vista {
+		metricaLecturasDuplicadas.Inc()
+		return nil, nil
+	}
+	o.vistas[clave] = struct{}{}
+
+	if datos.Timestamp < o.liberado {
+		return nil, []DatosSensor{datos}
+	}
+	if datos.Timestamp < o.maximo {
+		metricaLecturasReordenadas.Inc()
+	} else {
+		o.maximo = datos.Timestamp
+	}
+	heap.Push(&o.retenidas, datos)
+	return o.liberar(o.maximo - o.retraso), nil
+}
+
+// vaciar libera todas las lecturas retenidas.
+func (o *ordenLecturas) vaciar() []DatosSensor {
+	return o.liberar(o.maximo)
+}
+
+func (o *ordenLecturas) liberar(hasta int64) []DatosSensor {
+	var listas []DatosSensor
+	for len(o.retenidas) > 0 && o.retenidas[0].Timestamp <= hasta {
+		datos := heap.Pop(&o.retenidas).(DatosSensor)
+		o.liberado = datos.Timestamp
+		listas = append(listas, datos)
+	}
+	if len(listas) > 0 {
+		limite := o.liberado - int64(memoriaDuplicados/time.Second)
+		for clave := range o.vistas {
+			if clave.timestamp < limite {
+				delete(o.vistas, clave)
+			}
+		}
+	}
+	return listas
+}
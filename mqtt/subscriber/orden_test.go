@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrdenLecturas(t *testing.T) {
+	type paso struct {
+		timestamp int64
+		secuencia uint64
+		listas    []int64
+		tardias   []int64
+	}
+	casos := []struct {
+		nombre  string
+		retraso time.Duration
+		pasos   []paso
+		vaciar  []int64
+	}{
+		{
+			nombre:  "sin retraso se libera al llegar",
+			retraso: 0,
+			pasos: []paso{
+				{timestamp: 100, secuencia: 1, listas: []int64{100}},
+				{timestamp: 110, secuencia: 2, listas: []int64{110}},
+				{timestamp: 105, secuencia: 3, tardias: []int64{105}},
+			},
+		},
+		{
+			nombre:  "reordena dentro del retraso",
+			retraso: 20 * time.Second,
+			pasos: []paso{
+				{timestamp: 100, secuencia: 1},
+				{timestamp: 110, secuencia: 3},
+				{timestamp: 105, secuencia: 2},
+				{timestamp: 120, secuencia: 4, listas: []int64{100}},
+				{timestamp: 140, secuencia: 5, listas: []int64{105, 110, 120}},
+			},
+			vaciar: []int64{140},
+		},
+		{
+			nombre:  "descarta duplicados",
+			retraso: 10 * time.Second,
+			pasos: []paso{
+				{timestamp: 100, secuencia: 1},
+				{timestamp: 100, secuencia: 1},
+				{timestamp: 110, secuencia: 2, listas: []int64{100}},
+				{timestamp: 100, secuencia: 1},
+			},
+			vaciar: []int64{110},
+		},
+		{
+			nombre:  "mismo timestamp ordena por secuencia",
+			retraso: 10 * time.Second,
+			pasos: []paso{
+				{timestamp: 100, secuencia: 2},
+				{timestamp: 100, secuencia: 1},
+			},
+			vaciar: []int64{100, 100},
+		},
+		{
+			nombre:  "más vieja que lo liberado es tardía",
+			retraso: 10 * time.Second,
+			pasos: []paso{
+				{timestamp: 100, secuencia: 1},
+				{timestamp: 130, secuencia: 3, listas: []int64{100}},
+				{timestamp: 90, secuencia: 2, tardias: []int64{90}},
+			},
+			vaciar: []int64{130},
+		},
+	}
+
+	timestamps := func(lecturas []DatosSensor) []int64 {
+		var ts []int64
+		for _, l := range lecturas {
+			ts = append(ts, l.Timestamp)
+		}
+		return ts
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			o := nuevoOrden(c.retraso)
+			for i, p := range c.pasos {
+				listas, tardias := o.agregar(DatosSensor{Oficina: "A", Timestamp: p.timestamp, Secuencia: p.secuencia})
+				if got := timestamps(listas); !reflect.DeepEqual(got, p.listas) {
+					t.Errorf("paso %d: listas %v; se esperaba %v", i, got, p.listas)
+				}
+				if got := timestamps(tardias); !reflect.DeepEqual(got, p.tardias) {
+					t.Errorf("paso %d: tardías %v; se esperaba %v", i, got, p.tardias)
+				}
+			}
+			if got := timestamps(o.vaciar()); !reflect.DeepEqual(got, c.vaciar) {
+				t.Errorf("vaciar: %v; se esperaba %v", got, c.vaciar)
+			}
+		})
+	}
+}
+
+func TestOrdenLecturasSecuenciaAlVaciar(t *testing.T) {
+	o := nuevoOrden(10 * time.Second)
+	o.agregar(DatosSensor{Timestamp: 100, Secuencia: 2})
+	o.agregar(DatosSensor{Timestamp: 100, Secuencia: 1})
+	listas := o.vaciar()
+	if len(listas) != 2 || listas[0].Secuencia != 1 || listas[1].Secuencia != 2 {
+		t.Errorf("orden por secuencia: %+v", listas)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+const (
+	intervaloProgramacion = 15 * time.Second
+	// toleranciaProgramacion es el atraso con que una ejecución todavía se
+	// considera puntual, como la de un reinicio corto.
+	toleranciaProgramacion = 2 * time.Minute
+	// maxDiasRecuperacion acota la búsqueda de ejecuciones perdidas.
+	maxDiasRecuperacion      = 31
+	rutaRevisionProgramacion = "programacion/ultima_revision"
+)
+
+// AccionProgramada cambia un dispositivo de algunas oficinas a una hora fija
+// ("HH:MM") los días indicados (0 es domingo; vacío para todos).
+type AccionProgramada struct {
+	Nombre string `json:"nombre"`
+	// Oficinas a las que se aplica; vacío para todas.
+	Oficinas    []string `json:"oficinas,omitempty"`
+	Hora        string   `json:"hora"`
+	Dias        []int    `json:"dias,omitempty"`
+	Dispositivo string   `json:"dispositivo"`
+	Estado      bool     `json:"estado"`
+	// Recuperar es el atraso máximo con que se ejecuta, al volver de una
+	// caída, una ejecución perdida; 0 no las recupera.
+	Recuperar configuracion.Duracion `json:"recuperar,omitempty"`
+	// Mantener es cuánto tiempo la automatización no apaga el dispositivo
+	// que esta acción encendió, por ejemplo para preenfriar sin presencia.
+	Mantener configuracion.Duracion `json:"mantener,omitempty"`
+
+	hora, minuto int
+}
+
+func (a AccionProgramada) aplicaA(oficina string) bool {
+	return len(a.Oficinas) == 0 || contiene(a.Oficinas, oficina)
+}
+
+func (a AccionProgramada) rige(dia time.Weekday) bool {
+	if len(a.Dias) == 0 {
+		return true
+	}
+	for _, d := range a.Dias {
+		if time.Weekday(d) == dia {
+			return true
+		}
+	}
+	return false
+}
+
+// ocurrencias devuelve los instantes de la acción en (desde, hasta].
+func (a AccionProgramada) ocurrencias(desde, hasta time.Time, zona *time.Location) []time.Time {
+	var lista []time.Time
+	desde, hasta = desde.In(zona), hasta.In(zona)
+	dia := time.Date(desde.Year(), desde.Month(), desde.Day(), 0, 0, 0, 0, zona)
+	for ; !dia.After(hasta); dia = dia.AddDate(0, 0, 1) {
+		t := time.Date(dia.Year(), dia.Month(), dia.Day(), a.hora, a.minuto, 0, 0, zona)
+		if t.After(desde) && !t.After(hasta) && a.rige(t.Weekday()) {
+			lista = append(lista, t)
+		}
+	}
+	return lista
+}
+
+// ExcepcionCalendario suspende las acciones entre dos fechas AAAA-MM-DD
+// inclusive, como un feriado o unas vacaciones. Acciones y Oficinas la
+// limitan; vacías, alcanza a todas.
+type ExcepcionCalendario struct {
+	Nombre   string   `json:"nombre"`
+	Desde    string   `json:"desde"`
+	Hasta    string   `json:"hasta,omitempty"`
+	Acciones []string `json:"acciones,omitempty"`
+	Oficinas []string `json:"oficinas,omitempty"`
+}
+
+func (e ExcepcionCalendario) excluye(accion, oficina, dia string) bool {
+	hasta := e.Hasta
+	if hasta == "" {
+		hasta = e.Desde
+	}
+	return dia >= e.Desde && dia <= hasta &&
+		(len(e.Acciones) == 0 || contiene(e.Acciones, accion)) &&
+		(len(e.Oficinas) == 0 || contiene(e.Oficinas, oficina))
+}
+
+func contiene(lista []string, valor string) bool {
+	for _, v := range lista {
+		if v == valor {
+			return true
+		}
+	}
+	return false
+}
+
+// Programacion es el archivo de la opción programacion. ZonaHoraria es un
+// nombre de la base IANA; vacía, la hora local del subscriber.
+type Programacion struct {
+	ZonaHoraria string                `json:"zona_horaria,omitempty"`
+	Acciones    []AccionProgramada    `json:"acciones"`
+	Excepciones []ExcepcionCalendario `json:"excepciones,omitempty"`
+
+	zona *time.Location
+}
+
+var programacion *Programacion
+
+func cargarProgramacion(ruta string) (*Programacion, error) {
+	datos, err := os.ReadFile(ruta)
+	if err != nil {
+		return nil, err
+	}
+	var p Programacion
+	if err := json.Unmarshal(datos, &p); err != nil {
+		return nil, fmt.Errorf("%s: %v", ruta, err)
+	}
+	if err := p.validar(); err != nil {
+		return nil, fmt.Errorf("%s: %v", ruta, err)
+	}
+	return &p, nil
+}
+
+func (p *Programacion) validar() error {
+	p.zona = time.Local
+	if p.ZonaHoraria != "" {
+		zona, err := time.LoadLocation(p.ZonaHoraria)
+		if err != nil {
+			return fmt.Errorf("zona_horaria inválida: %v", err)
+		}
+		p.zona = zona
+	}
+
+	nombres := make(map[string]bool)
+	for i := range p.Acciones {
+		a := &p.Acciones[i]
+		if a.Nombre == "" || nombres[a.Nombre] {
+			return fmt.Errorf("acción %d: el nombre falta o está repetido", i)
+		}
+		nombres[a.Nombre] = true
+		t, err := time.Parse("15:04", a.Hora)
+		if err != nil {
+			return fmt.Errorf("acción %s: hora inválida %q, use HH:MM", a.Nombre, a.Hora)
+		}
+		a.hora, a.minuto = t.Hour(), t.Minute()
+		if a.Dispositivo != "luces" && a.Dispositivo != "aire" {
+			return fmt.Errorf("acción %s: dispositivo desconocido %q", a.Nombre, a.Dispositivo)
+		}
+		for _, d := range a.Dias {
+			if d < 0 || d > 6 {
+				return fmt.Errorf("acción %s: día inválido %d (0 es domingo)", a.Nombre, d)
+			}
+		}
+		if a.Recuperar < 0 || a.Mantener < 0 {
+			return fmt.Errorf("acción %s: recuperar y mantener no pueden ser negativos", a.Nombre)
+		}
+	}
+
+	for _, e := range p.Excepciones {
+		fechas := []string{e.Desde}
+		if e.Hasta != "" {
+			fechas = append(fechas, e.Hasta)
+		}
+		for _, fecha := range fechas {
+			if _, err := time.Parse(formatoDia, fecha); err != nil {
+				return fmt.Errorf("excepción %q: fecha inválida %q, use AAAA-MM-DD", e.Nombre, fecha)
+			}
+		}
+		if e.Hasta != "" && e.Hasta < e.Desde {
+			return fmt.Errorf("excepción %q: hasta es anterior a desde", e.Nombre)
+		}
+		for _, nombre := range e.Acciones {
+			if !nombres[nombre] {
+				return fmt.Errorf("excepción %q: acción desconocida %q", e.Nombre, nombre)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Programacion) exceptuada(accion, oficina string, instante time.Time) (ExcepcionCalendario, bool) {
+	dia := instante.In(p.zona).Format(formatoDia)
+	for _, e := range p.Excepciones {
+		if e.excluye(accion, oficina, dia) {
+			return e, true
+		}
+	}
+	return ExcepcionCalendario{}, false
+}
+
+type ejecucionProgramada struct {
+	accion   *AccionProgramada
+	oficina  string
+	instante time.Time
+}
+
+// pendientes devuelve, para cada oficina y dispositivo, la última ejecución
+// en (desde, hasta] que no cae en una excepción. Las anteriores ya no
+// importan: el estado final es el de la última.
+func (p *Programacion) pendientes(oficinas []string, desde, hasta time.Time) []ejecucionProgramada {
+	if limite := hasta.AddDate(0, 0, -maxDiasRecuperacion); desde.Before(limite) {
+		desde = limite
+	}
+	ultimas := make(map[string]ejecucionProgramada)
+	for i := range p.Acciones {
+		a := &p.Acciones[i]
+		ocurrencias := a.ocurrencias(desde, hasta, p.zona)
+		for _, oficina := range oficinas {
+			if !a.aplicaA(oficina) {
+				continue
+			}
+			for j := len(ocurrencias) - 1; j >= 0; j-- {
+				if e, ok := p.exceptuada(a.Nombre, oficina, ocurrencias[j]); ok {
+					log.Printf("📅 %s omitida en %s por la excepción %q", a.Nombre, oficina, e.Nombre)
+					continue
+				}
+				clave := oficina + "/" + a.Dispositivo
+				if previa, existe := ultimas[clave]; !existe || ocurrencias[j].After(previa.instante) {
+					ultimas[clave] = ejecucionProgramada{accion: a, oficina: oficina, instante: ocurrencias[j]}
+				}
+				break
+			}
+		}
+	}
+
+	lista := make([]ejecucionProgramada, 0, len(ultimas))
+	for _, e := range ultimas {
+		lista = append(lista, e)
+	}
+	sort.Slice(lista, func(i, j int) bool {
+		if !lista[i].instante.Equal(lista[j].instante) {
+			return lista[i].instante.Before(lista[j].instante)
+		}
+		return lista[i].oficina < lista[j].oficina
+	})
+	return lista
+}
+
+// leerUltimaRevision devuelve hasta cuándo se revisó la programación antes
+// de detener el subscriber, o cero si no hay registro.
+func leerUltimaRevision(ctx context.Context) (time.Time, error) {
+	var ultima int64
+	if err := clienteFirebase.NewRef(rutaFirebase(rutaRevisionProgramacion)).Get(ctx, &ultima); err != nil {
+		return time.Time{}, fmt.Errorf("error leyendo la última revisión de la programación: %v", err)
+	}
+	if ultima == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(ultima, 0), nil
+}
+
+// ejecutar revisa la programación periódicamente. Al iniciar retoma desde la
+// última revisión guardada en Firebase para recuperar lo que se perdió
+// mientras el subscriber estaba detenido.
+func (p *Programacion) ejecutar(ctx context.Context) {
+	ultima, err := leerUltimaRevision(ctx)
+	if err != nil {
+		log.Printf("❌ %v", err)
+	}
+	if ultima.IsZero() {
+		ultima = time.Now()
+	}
+
+	revisar := func() {
+		// Sin la lista de oficinas no se sabe a quién aplicar las acciones;
+		// la ventana sigue abierta hasta que llegue.
+		oficinas := listarOficinas()
+		if len(oficinas) == 0 {
+			return
+		}
+		ahora := time.Now()
+		for _, e := range p.pendientes(oficinas, ultima, ahora) {
+			p.accionar(e, ahora)
+		}
+		ultima = ahora
+		ingesta.intentarEscribir(escritura{
+			operacion: "programacion",
+			ruta:      rutaFirebase(rutaRevisionProgramacion),
+			valor:     ahora.Unix(),
+		})
+	}
+
+	revisar()
+	t := time.NewTicker(intervaloProgramacion)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			revisar()
+		}
+	}
+}
+
+func (p *Programacion) accionar(e ejecucionProgramada, ahora time.Time) {
+	a := e.accion
+	atraso := ahora.Sub(e.instante)
+	if atraso > toleranciaProgramacion && atraso > time.Duration(a.Recuperar) {
+		metricaAccionesProgramadas.WithLabelValues(a.Dispositivo, "omitida").Inc()
+		log.Printf("⏭️  %s en %s se perdió hace %s y no se recupera", a.Nombre, e.oficina, atraso.Round(time.Second))
+		return
+	}
+
+	c := ComandoDispositivo{
+		Oficina:     e.oficina,
+		Dispositivo: a.Dispositivo,
+		Estado:      a.Estado,
+		Origen:      "programacion",
+		Motivo:      a.Nombre,
+		Timestamp:   ahora.Unix(),
+	}
+	if err := accionarDispositivo(context.Background(), c); err != nil {
+		metricaAccionesProgramadas.WithLabelValues(a.Dispositivo, "error").Inc()
+		log.Printf("❌ Error en la acción programada %s sobre %s: %v", a.Nombre, e.oficina, err)
+		return
+	}
+	metricaAccionesProgramadas.WithLabelValues(a.Dispositivo, "ok").Inc()
+	if a.Estado && a.Mantener > 0 && automatizacion != nil {
+		automatizacion.posponer(e.oficina, a.Dispositivo, ahora.Add(time.Duration(a.Mantener)))
+	}
+	if atraso > toleranciaProgramacion {
+		log.Printf("⏰ %s: %s.%s = %v (recuperada, %s tarde)", a.Nombre, e.oficina, a.Dispositivo, a.Estado, atraso.Round(time.Second))
+	} else {
+		log.Printf("⏰ %s: %s.%s = %v", a.Nombre, e.oficina, a.Dispositivo, a.Estado)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+func zonaDePrueba(t *testing.T) *time.Location {
+	t.Helper()
+	zona, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("sin la base de zonas horarias: %v", err)
+	}
+	return zona
+}
+
+func TestAccionProgramadaOcurrencias(t *testing.T) {
+	zona := zonaDePrueba(t)
+	a := func(anio int, mes time.Month, dia, hora, minuto int) time.Time {
+		return time.Date(anio, mes, dia, hora, minuto, 0, 0, zona)
+	}
+	casos := []struct {
+		nombre       string
+		hora, minuto int
+		dias         []int
+		desde, hasta time.Time
+		esperadas    []string
+	}{
+		{
+			nombre: "sin días, todos",
+			hora:   8, desde: a(2025, 12, 1, 0, 0), hasta: a(2025, 12, 4, 0, 0),
+			esperadas: []string{"2025-12-01 08:00 EST", "2025-12-02 08:00 EST", "2025-12-03 08:00 EST"},
+		},
+		{
+			nombre: "excluye desde e incluye hasta",
+			hora:   8, desde: a(2025, 12, 1, 8, 0), hasta: a(2025, 12, 2, 8, 0),
+			esperadas: []string{"2025-12-02 08:00 EST"},
+		},
+		{
+			nombre: "vacío si no llega a la hora",
+			hora:   8, minuto: 30, desde: a(2025, 12, 1, 8, 0), hasta: a(2025, 12, 1, 8, 29),
+		},
+		{
+			// Del sábado 6 al sábado 13 de diciembre.
+			nombre: "días hábiles",
+			hora:   7, dias: []int{1, 2, 3, 4, 5}, desde: a(2025, 12, 6, 0, 0), hasta: a(2025, 12, 13, 23, 0),
+			esperadas: []string{
+				"2025-12-08 07:00 EST", "2025-12-09 07:00 EST", "2025-12-10 07:00 EST",
+				"2025-12-11 07:00 EST", "2025-12-12 07:00 EST",
+			},
+		},
+		{
+			nombre: "límites en otra zona",
+			hora:   22, desde: time.Date(2025, 12, 2, 2, 0, 0, 0, time.UTC), hasta: time.Date(2025, 12, 3, 3, 0, 0, 0, time.UTC),
+			esperadas: []string{"2025-12-01 22:00 EST", "2025-12-02 22:00 EST"},
+		},
+		{
+			// El 8 de marzo de 2026 el día tiene 23 horas.
+			nombre: "cambio al horario de verano",
+			hora:   8, desde: a(2026, 3, 7, 0, 0), hasta: a(2026, 3, 9, 12, 0),
+			esperadas: []string{"2026-03-07 08:00 EST", "2026-03-08 08:00 EDT", "2026-03-09 08:00 EDT"},
+		},
+		{
+			// Las 2:30 no existen ese día: se normalizan con la zona de
+			// antes del cambio, pero la acción corre una vez.
+			nombre: "hora que no existe",
+			hora:   2, minuto: 30, desde: a(2026, 3, 7, 12, 0), hasta: a(2026, 3, 9, 0, 0),
+			esperadas: []string{"2026-03-08 01:30 EST"},
+		},
+		{
+			// El 1 de noviembre de 2026 la 1:30 ocurre dos veces.
+			nombre: "hora repetida",
+			hora:   1, minuto: 30, desde: a(2026, 10, 31, 12, 0), hasta: a(2026, 11, 2, 0, 0),
+			esperadas: []string{"2026-11-01 01:30 EDT"},
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			accion := AccionProgramada{Dias: c.dias, hora: c.hora, minuto: c.minuto}
+			var ocurrencias []string
+			for _, o := range accion.ocurrencias(c.desde, c.hasta, zona) {
+				ocurrencias = append(ocurrencias, o.Format("2006-01-02 15:04 MST"))
+			}
+			if !reflect.DeepEqual(ocurrencias, c.esperadas) {
+				t.Errorf("ocurrencias: %v; se esperaba %v", ocurrencias, c.esperadas)
+			}
+		})
+	}
+
+	// De 8 a 8 cruzando el cambio pasan 23 horas.
+	o := AccionProgramada{hora: 8}.ocurrencias(a(2026, 3, 7, 0, 0), a(2026, 3, 9, 0, 0), zona)
+	if len(o) != 2 || o[1].Sub(o[0]) != 23*time.Hour {
+		t.Errorf("ocurrencias: %v", o)
+	}
+}
+
+func TestExcepcionCalendarioExcluye(t *testing.T) {
+	feriado := ExcepcionCalendario{Nombre: "Feriado", Desde: "2025-12-08"}
+	vacaciones := ExcepcionCalendario{
+		Nombre: "Vacaciones", Desde: "2025-12-24", Hasta: "2026-01-02",
+		Acciones: []string{"encender"}, Oficinas: []string{"A"},
+	}
+	casos := []struct {
+		nombre               string
+		excepcion            ExcepcionCalendario
+		accion, oficina, dia string
+		excluye              bool
+	}{
+		{"un solo día", feriado, "encender", "A", "2025-12-08", true},
+		{"un solo día, el anterior", feriado, "encender", "A", "2025-12-07", false},
+		{"un solo día, el siguiente", feriado, "apagar", "B", "2025-12-09", false},
+		{"primer día", vacaciones, "encender", "A", "2025-12-24", true},
+		{"cruza el año", vacaciones, "encender", "A", "2025-12-31", true},
+		{"último día", vacaciones, "encender", "A", "2026-01-02", true},
+		{"después", vacaciones, "encender", "A", "2026-01-03", false},
+		{"otra acción", vacaciones, "apagar", "A", "2025-12-28", false},
+		{"otra oficina", vacaciones, "encender", "B", "2025-12-28", false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			if excluye := c.excepcion.excluye(c.accion, c.oficina, c.dia); excluye != c.excluye {
+				t.Errorf("excluye(%s, %s, %s) = %v; se esperaba %v", c.accion, c.oficina, c.dia, excluye, c.excluye)
+			}
+		})
+	}
+}
+
+func TestProgramacionValidar(t *testing.T) {
+	encender := AccionProgramada{Nombre: "encender", Hora: "08:00", Dispositivo: "luces", Estado: true}
+	con := func(cambiar func(*AccionProgramada)) []AccionProgramada {
+		a := encender
+		cambiar(&a)
+		return []AccionProgramada{a}
+	}
+	casos := []struct {
+		nombre       string
+		programacion Programacion
+		error        string
+	}{
+		{"vacía", Programacion{}, ""},
+		{"completa", Programacion{
+			ZonaHoraria: "America/New_York",
+			Acciones:    con(func(a *AccionProgramada) { a.Dias = []int{0, 6}; a.Recuperar = configuracion.Duracion(time.Hour) }),
+			Excepciones: []ExcepcionCalendario{{Nombre: "Feriado", Desde: "2025-12-08", Acciones: []string{"encender"}}},
+		}, ""},
+		{"zona desconocida", Programacion{ZonaHoraria: "America/Ninguna"}, "zona_horaria"},
+		{"sin nombre", Programacion{Acciones: con(func(a *AccionProgramada) { a.Nombre = "" })}, "falta o está repetido"},
+		{"nombre repetido", Programacion{Acciones: []AccionProgramada{encender, encender}}, "falta o está repetido"},
+		{"hora fuera de rango", Programacion{Acciones: con(func(a *AccionProgramada) { a.Hora = "24:00" })}, "hora inválida"},
+		{"hora sin minutos", Programacion{Acciones: con(func(a *AccionProgramada) { a.Hora = "8" })}, "hora inválida"},
+		{"dispositivo desconocido", Programacion{Acciones: con(func(a *AccionProgramada) { a.Dispositivo = "horno" })}, "dispositivo"},
+		{"día inválido", Programacion{Acciones: con(func(a *AccionProgramada) { a.Dias = []int{7} })}, "día inválido 7"},
+		{"recuperar negativo", Programacion{Acciones: con(func(a *AccionProgramada) { a.Recuperar = -1 })}, "negativos"},
+		{"mantener negativo", Programacion{Acciones: con(func(a *AccionProgramada) { a.Mantener = -1 })}, "negativos"},
+		{"fecha inválida", Programacion{Excepciones: []ExcepcionCalendario{{Nombre: "X", Desde: "2025-13-01"}}}, "fecha inválida"},
+		{"hasta inválida", Programacion{Excepciones: []ExcepcionCalendario{{Nombre: "X", Desde: "2025-12-01", Hasta: "1/1/2026"}}}, "fecha inválida"},
+		{"hasta anterior", Programacion{Excepciones: []ExcepcionCalendario{{Nombre: "X", Desde: "2025-12-02", Hasta: "2025-12-01"}}}, "anterior"},
+		{"acción desconocida", Programacion{
+			Acciones:    []AccionProgramada{encender},
+			Excepciones: []ExcepcionCalendario{{Nombre: "X", Desde: "2025-12-01", Acciones: []string{"apagar"}}},
+		}, "acción desconocida"},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			err := c.programacion.validar()
+			if c.error == "" && err != nil {
+				t.Errorf("validar: %v", err)
+			}
+			if c.error != "" && (err == nil || !strings.Contains(err.Error(), c.error)) {
+				t.Errorf("validar: %v; se esperaba un error con %q", err, c.error)
+			}
+		})
+	}
+
+	p := Programacion{Acciones: con(func(a *AccionProgramada) { a.Hora = "07:45" })}
+	if err := p.validar(); err != nil || p.zona != time.Local || p.Acciones[0].hora != 7 || p.Acciones[0].minuto != 45 {
+		t.Errorf("validar: %v, zona %v y %d:%d", err, p.zona, p.Acciones[0].hora, p.Acciones[0].minuto)
+	}
+}
+
+func TestProgramacionPendientes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	zona := zonaDePrueba(t)
+	a := func(mes time.Month, dia, hora int) time.Time { return time.Date(2025, mes, dia, hora, 0, 0, 0, zona) }
+	acciones := []AccionProgramada{
+		{Nombre: "encender", Hora: "08:00", Dispositivo: "luces", Estado: true},
+		{Nombre: "apagar", Hora: "20:00", Dispositivo: "luces"},
+		{Nombre: "preenfriar", Hora: "07:30", Dispositivo: "aire", Estado: true, Oficinas: []string{"B"}},
+	}
+	casos := []struct {
+		nombre       string
+		excepciones  []ExcepcionCalendario
+		desde, hasta time.Time
+		esperadas    []string
+	}{
+		{
+			nombre: "la última del día por oficina y dispositivo",
+			desde:  a(12, 3, 9), hasta: a(12, 3, 21),
+			esperadas: []string{"A apagar 12-03 20:00", "B apagar 12-03 20:00"},
+		},
+		{
+			nombre: "ordenadas por instante y oficina",
+			desde:  a(12, 3, 6), hasta: a(12, 3, 9),
+			esperadas: []string{"B preenfriar 12-03 07:30", "A encender 12-03 08:00", "B encender 12-03 08:00"},
+		},
+		{
+			nombre: "de varios días, solo la última",
+			desde:  a(12, 1, 0), hasta: a(12, 3, 10),
+			esperadas: []string{"B preenfriar 12-03 07:30", "A encender 12-03 08:00", "B encender 12-03 08:00"},
+		},
+		{
+			nombre:      "una excepción de un día deja la anterior",
+			excepciones: []ExcepcionCalendario{{Nombre: "Feriado", Desde: "2025-12-03", Acciones: []string{"encender", "preenfriar"}, Oficinas: []string{"A"}}},
+			desde:       a(12, 1, 0), hasta: a(12, 3, 10),
+			esperadas: []string{"A apagar 12-02 20:00", "B preenfriar 12-03 07:30", "B encender 12-03 08:00"},
+		},
+		{
+			nombre:      "todo exceptuado",
+			excepciones: []ExcepcionCalendario{{Nombre: "Cierre", Desde: "2025-12-01", Hasta: "2025-12-03"}},
+			desde:       a(12, 1, 0), hasta: a(12, 3, 21),
+		},
+		{
+			// La búsqueda no va más atrás de 31 días: del 2 de noviembre a
+			// las 10 en adelante está todo exceptuado.
+			nombre:      "a lo sumo 31 días",
+			excepciones: []ExcepcionCalendario{{Nombre: "Cierre", Desde: "2025-11-02", Hasta: "2025-12-03"}},
+			desde:       a(10, 1, 0), hasta: a(12, 3, 10),
+		},
+		{
+			nombre:      "justo dentro de los 31 días",
+			excepciones: []ExcepcionCalendario{{Nombre: "Cierre", Desde: "2025-11-03", Hasta: "2025-12-03"}},
+			desde:       a(10, 1, 0), hasta: a(12, 3, 10),
+			esperadas: []string{"A apagar 11-02 20:00", "B apagar 11-02 20:00"},
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			p := Programacion{ZonaHoraria: "America/New_York", Acciones: append([]AccionProgramada(nil), acciones...), Excepciones: c.excepciones}
+			if err := p.validar(); err != nil {
+				t.Fatal(err)
+			}
+			var pendientes []string
+			for _, e := range p.pendientes([]string{"A", "B"}, c.desde, c.hasta) {
+				pendientes = append(pendientes, fmt.Sprintf("%s %s %s", e.oficina, e.accion.Nombre, e.instante.Format("01-02 15:04")))
+			}
+			if !reflect.DeepEqual(pendientes, c.esperadas) {
+				t.Errorf("pendientes: %v; se esperaba %v", pendientes, c.esperadas)
+			}
+		})
+	}
+}
+
+func TestProgramacionAccionarRecuperacion(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	anterior := automatizacion
+	t.Cleanup(func() { automatizacion = anterior })
+
+	ahora := time.Now()
+	casos := []struct {
+		nombre    string
+		recuperar time.Duration
+		atraso    time.Duration
+		ejecuta   bool
+	}{
+		{"puntual", 0, 0, true},
+		{"dentro de la tolerancia", 0, toleranciaProgramacion, true},
+		{"pasada la tolerancia sin recuperar", 0, toleranciaProgramacion + time.Second, false},
+		{"dentro de recuperar", 15 * time.Minute, 15 * time.Minute, true},
+		{"pasado recuperar", 15 * time.Minute, 15*time.Minute + time.Second, false},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			automatizacion = nuevaAutomatizacion(time.Minute)
+			accion := &AccionProgramada{
+				Nombre: "preenfriar", Dispositivo: "aire", Estado: true,
+				Recuperar: configuracion.Duracion(c.recuperar),
+				Mantener:  configuracion.Duracion(time.Hour),
+			}
+			var p Programacion
+			p.accionar(ejecucionProgramada{accion: accion, oficina: "A", instante: ahora.Add(-c.atraso)}, ahora)
+
+			// Ejecutada, encendió el aire y pausó su apagado automático.
+			hasta, ejecuta := automatizacion.pausas["A/aire"]
+			if ejecuta != c.ejecuta {
+				t.Fatalf("ejecutada %v; se esperaba %v", ejecuta, c.ejecuta)
+			}
+			if ejecuta && !hasta.Equal(ahora.Add(time.Hour)) {
+				t.Errorf("pausa hasta %v", hasta)
+			}
+		})
+	}
+}
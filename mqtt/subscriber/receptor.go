@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// toleranciaFirma es la antigüedad máxima del timestamp de un webhook
+// firmado que acepta el receptor.
+const toleranciaFirma = 5 * time.Minute
+
+// comandoReceptor levanta un receptor local de webhooks y un servidor SMTP
+// mínimo que imprimen lo que reciben, para probar los canales de
+// notificación sin servicios externos.
+func comandoReceptor(args []string) error {
+	fs := flag.NewFlagSet("notificaciones receptor", flag.ContinueOnError)
+	dirHTTP := fs.String("http", ":8025", "dirección del receptor de webhooks (vacío para desactivarlo)")
+	dirSMTP := fs.String("smtp", ":2525", "dirección del servidor SMTP (vacío para desactivarlo)")
+	secreto := fs.String("secreto", "", "secreto para verificar la firma de los webhooks")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dirHTTP == "" && *dirSMTP == "" {
+		return errors.New("no hay nada que escuchar")
+	}
+
+	ctx, detener := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer detener()
+	errs := make(chan error, 2)
+
+	if *dirHTTP != "" {
+		srv := &http.Server{Addr: *dirHTTP, Handler: receptorWebhooks(*secreto)}
+		go func() {
+			log.Printf("🪝 Receptor de webhooks en %s", *dirHTTP)
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+		defer srv.Close()
+	}
+	if *dirSMTP != "" {
+		ln, err := net.Listen("tcp", *dirSMTP)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		go func() {
+			log.Printf("📬 Servidor SMTP de prueba en %s", *dirSMTP)
+			servirSMTP(ln)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+func receptorWebhooks(secreto string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cuerpo, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		firma := "sin firma"
+		if secreto != "" {
+			if err := verificarFirma(secreto, r.Header, cuerpo); err != nil {
+				log.Printf("🚫 Webhook %s %s rechazado: %v", r.Method, r.URL.Path, err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			firma = "firma válida"
+		}
+		log.Printf("🪝 Webhook %s %s (%s, %s)\n%s", r.Method, r.URL.Path, r.Header.Get("Content-Type"), firma, cuerpo)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// verificarFirma es la comprobación que debería hacer un receptor real de
+// los webhooks firmados.
+func verificarFirma(secreto string, h http.Header, cuerpo []byte) error {
+	ts, err := strconv.ParseInt(h.Get(encabezadoTimestamp), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s inválido", encabezadoTimestamp)
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > toleranciaFirma || d < -toleranciaFirma {
+		return fmt.Errorf("timestamp fuera de tolerancia (%s)", d.Round(time.Second))
+	}
+	if !hmac.Equal([]byte(h.Get(encabezadoFirma)), []byte(firmarWebhook(secreto, ts, cuerpo))) {
+		return errors.New("firma inválida")
+	}
+	return nil
+}
+
+func servirSMTP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go atenderSMTP(conn)
+	}
+}
+
+// atenderSMTP implementa lo justo de SMTP para net/smtp.SendMail: no
+// anuncia STARTTLS ni AUTH, así que solo sirve para canales sin usuario.
+func atenderSMTP(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	responder := func(linea string) { fmt.Fprintf(conn, "%s\r\n", linea) }
+
+	var de string
+	var para []string
+	responder("220 monitoreo-receptor ESMTP")
+	for {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+		linea, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		linea = strings.TrimRight(linea, "\r\n")
+		comando := strings.ToUpper(linea)
+		switch {
+		case strings.HasPrefix(comando, "EHLO"), strings.HasPrefix(comando, "HELO"):
+			responder("250 monitoreo-receptor")
+		case strings.HasPrefix(comando, "MAIL FROM:"):
+			de, para = strings.TrimSpace(linea[len("MAIL FROM:"):]), nil
+			responder("250 OK")
+		case strings.HasPrefix(comando, "RCPT TO:"):
+			para = append(para, strings.TrimSpace(linea[len("RCPT TO:"):]))
+			responder("250 OK")
+		case comando == "DATA":
+			if len(para) == 0 {
+				responder("503 faltan destinatarios")
+				continue
+			}
+			responder("354 terminar con <CRLF>.<CRLF>")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				l = strings.TrimRight(l, "\r\n")
+				if l == "." {
+					break
+				}
+				msg.WriteString(strings.TrimPrefix(l, ".") + "\n")
+			}
+			log.Printf("📬 Correo de %s para %s\n%s", de, strings.Join(para, ", "), msg.String())
+			responder("250 OK")
+		case comando == "RSET":
+			de, para = "", nil
+			responder("250 OK")
+		case comando == "NOOP":
+			responder("250 OK")
+		case comando == "QUIT":
+			responder("221 Adiós")
+			return
+		default:
+			responder("502 comando no implementado")
+		}
+	}
+}
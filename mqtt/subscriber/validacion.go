@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"monitoreo_consumo/internal/mqttcliente"
+)
+
+const (
+	// Rango físico de los sensores de temperatura.
+	temperaturaMinima      = -40.0
+	temperaturaMaxima      = 85.0
+	toleranciaFuturoMinima = 10 * time.Minute
+	maxPayloadCuarentena   = 4096
+)
+
+// toleranciaFuturo es cuánto puede adelantar un timestamp respecto de la
+// llegada sin ir a cuarentena. Es más amplia que desfase_max, para que un
+// reloj adelantado llegue a generar el aviso de desfase.
+func toleranciaFuturo() int64 {
+	tolerancia := toleranciaFuturoMinima
+	if d := 2 * time.Duration(cfg.DesfaseMax); d > tolerancia {
+		tolerancia = d
+	}
+	return int64(tolerancia / time.Second)
+}
+
+// Cuarentena guarda una lectura rechazada con los motivos, para revisarla
+// sin que afecte los resúmenes.
+type Cuarentena struct {
+	Topico   string   `json:"topico"`
+	Oficina  string   `json:"oficina,omitempty"`
+	Motivos  []string `json:"motivos"`
+	Recibida int64    `json:"recibida"`
+	Payload  string   `json:"payload"`
+}
+
+type problemaLectura struct {
+	codigo  string
+	detalle string
+}
+
+// oficinaValida rechaza los IDs que no pueden ser una clave de Firebase.
+func oficinaValida(oficina string) bool {
+	return oficina != "" && len(oficina) <= 64 && !strings.ContainsAny(oficina, ".$#[]/")
+}
+
+// validarLectura devuelve los problemas de una lectura ya decodificada; nil
+// si es válida.
+func validarLectura(topico string, datos DatosSensor) []problemaLectura {
+	var problemas []problemaLectura
+	agregar := func(codigo, formato string, args ...interface{}) {
+		problemas = append(problemas, problemaLectura{codigo, fmt.Sprintf(formato, args...)})
+	}
+
+	if !oficinaValida(datos.Oficina) {
+		agregar("oficina_invalida", "oficina inválida: %q", datos.Oficina)
+	} else {
+		if oficina := mqttcliente.OficinaDelTopico(topico); oficina != "" && oficina != datos.Oficina {
+			agregar("topico", "la oficina %s no corresponde al tópico %s", datos.Oficina, topico)
+		}
+		mu.RLock()
+		conocidas := len(oficinas)
+		mu.RUnlock()
+		// Sin lista de oficinas todavía no se puede decidir.
+		if conocidas > 0 && !existeOficina(datos.Oficina) {
+			agregar("oficina_desconocida", "oficina desconocida: %s", datos.Oficina)
+		}
+	}
+
+	// JSON no admite NaN ni infinitos: esas lecturas ya fueron a dead-letter.
+	if datos.CorrienteA < 0 {
+		agregar("corriente", "corriente negativa: %.2f A", datos.CorrienteA)
+	}
+	if datos.Temperatura < temperaturaMinima || datos.Temperatura > temperaturaMaxima {
+		agregar("temperatura", "temperatura fuera de rango: %.1f °C", datos.Temperatura)
+	}
+
+	switch {
+	case datos.Timestamp <= 0:
+		agregar("timestamp", "timestamp inválido: %d", datos.Timestamp)
+	case datos.Timestamp > datos.llegada()+toleranciaFuturo():
+		agregar("timestamp_futuro", "timestamp %d segundos en el futuro", datos.Timestamp-datos.llegada())
+	}
+	return problemas
+}
+
+// recibirLectura decodifica y valida un mensaje de sensores. Las lecturas
+// válidas pasan a la ingesta y el resto, a cuarentena.
+// Los que ni siquiera se pueden decodificar van a dead-letter.
+func recibirLectura(cliente mqtt.Client, topico string, payload []byte) {
+	recibida := time.Now().Unix()
+	var datos DatosSensor
+	if err := json.Unmarshal(payload, &datos); err != nil {
+		metricaErroresParseo.Inc()
+		enviarADeadLetter(cliente, topico, payload, recibida, err)
+		return
+	}
+	datos.recibida = recibida
+
+	if problemas := validarLectura(topico, datos); len(problemas) > 0 {
+		ponerEnCuarentena(topico, datos.Oficina, recibida, payload, problemas)
+		return
+	}
+	registrarLectura(datos)
+	if archivo != nil {
+		archivo.Agregar(datos)
+	}
+	ingesta.Encolar(datos)
+}
+
+func ponerEnCuarentena(topico, oficina string, recibida int64, payload []byte, problemas []problemaLectura) {
+	c := Cuarentena{
+		Topico:   topico,
+		Recibida: recibida,
+		Payload:  string(payload),
+	}
+	if oficinaValida(oficina) {
+		c.Oficina = oficina
+	}
+	if len(c.Payload) > maxPayloadCuarentena {
+		c.Payload = c.Payload[:maxPayloadCuarentena]
+	}
+	for _, p := range problemas {
+		metricaCuarentena.WithLabelValues(p.codigo).Inc()
+		c.Motivos = append(c.Motivos, p.detalle)
+	}
+	log.Printf("🚫 Lectura en cuarentena (%s): %s", topico, strings.Join(c.Motivos, "; "))
+
+	// Se guarda sin bloquear: con la cola de escrituras llena se pierde
+	// antes que frenar la recepción.
+	if !ingesta.intentarEscribir(escritura{
+		operacion: "cuarentena",
+		ruta:      rutaFirebase("cuarentena"),
+		valor:     c,
+		agregar:   true,
+	}) {
+		log.Printf("⚠️  Cola de escrituras llena, no se guardó la lectura en cuarentena")
+	}
+}
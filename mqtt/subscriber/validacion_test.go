@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"monitoreo_consumo/internal/configuracion"
+)
+
+func TestValidarLectura(t *testing.T) {
+	mu.Lock()
+	anteriores, cfgAnterior := oficinas, cfg
+	oficinas = []string{"A", "B"}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		oficinas, cfg = anteriores, cfgAnterior
+		mu.Unlock()
+	})
+	const llegada = 1700000000
+
+	casos := []struct {
+		nombre     string
+		topico     string
+		datos      DatosSensor
+		desfaseMax time.Duration
+		codigos    []string
+	}{
+		{"válida", "oficinas/A/sensores", DatosSensor{Oficina: "A", Timestamp: llegada, CorrienteA: 5, Temperatura: 22}, 0, nil},
+		{"válida de inquilino", "inquilinos/acme/oficinas/B/sensores", DatosSensor{Oficina: "B", Timestamp: llegada, Temperatura: 22}, 0, nil},
+		{"oficina vacía", "oficinas/A/sensores", DatosSensor{Timestamp: llegada, Temperatura: 22}, 0, []string{"oficina_invalida"}},
+		{"oficina con caracteres de Firebase", "oficinas/A.1/sensores", DatosSensor{Oficina: "A.1", Timestamp: llegada, Temperatura: 22}, 0, []string{"oficina_invalida"}},
+		{"otra oficina que la del tópico", "oficinas/A/sensores", DatosSensor{Oficina: "B", Timestamp: llegada, Temperatura: 22}, 0, []string{"topico"}},
+		{"oficina desconocida", "oficinas/Z/sensores", DatosSensor{Oficina: "Z", Timestamp: llegada, Temperatura: 22}, 0, []string{"oficina_desconocida"}},
+		{"corriente negativa", "oficinas/A/sensores", DatosSensor{Oficina: "A", Timestamp: llegada, CorrienteA: -1, Temperatura: 22}, 0, []string{"corriente"}},
+		{"temperatura fuera de rango", "oficinas/A/sensores", DatosSensor{Oficina: "A", Timestamp: llegada, Temperatura: 120}, 0, []string{"temperatura"}},
+		{"sin timestamp", "oficinas/A/sensores", DatosSensor{Oficina: "A", Temperatura: 22}, 0, []string{"timestamp"}},
+		{"adelantada dentro de la tolerancia", "oficinas/A/sensores", DatosSensor{Oficina: "A", Timestamp: llegada + 9*60, Temperatura: 22}, 0, nil},
+		{"adelantada fuera de la tolerancia", "oficinas/A/sensores", DatosSensor{Oficina: "A", Timestamp: llegada + 11*60, Temperatura: 22}, 0, []string{"timestamp_futuro"}},
+		{"la tolerancia crece con desfase_max", "oficinas/A/sensores", DatosSensor{Oficina: "A", Timestamp: llegada + 30*60, Temperatura: 22}, 20 * time.Minute, nil},
+		{"varios problemas", "oficinas/A/sensores", DatosSensor{Oficina: "A", Timestamp: llegada, CorrienteA: -2, Temperatura: -60}, 0, []string{"corriente", "temperatura"}},
+	}
+	for _, c := range casos {
+		t.Run(c.nombre, func(t *testing.T) {
+			cfg.DesfaseMax = configuracion.Duracion(c.desfaseMax)
+			c.datos.recibida = llegada
+			var codigos []string
+			for _, p := range validarLectura(c.topico, c.datos) {
+				codigos = append(codigos, p.codigo)
+			}
+			if !reflect.DeepEqual(codigos, c.codigos) {
+				t.Errorf("problemas %v; se esperaba %v", codigos, c.codigos)
+			}
+		})
+	}
+}
@@ -1,9 +1,17 @@
 const firebaseConfig = require('./config/firebase-config');
 
+// Con MONITOREO_INQUILINO se inicializa el espacio de ese inquilino, con su
+// propio catálogo de avisos y parámetros.
+const RAIZ = process.env.MONITOREO_INQUILINO
+    ? `inquilinos/${process.env.MONITOREO_INQUILINO}/monitoreo_consumo`
+    : 'monitoreo_consumo';
+
 async function inicializarBaseDeDatos(db) {
-    const paramsRef = db.ref('monitoreo_consumo/params');
-    const tiposAvisosRef = db.ref('monitoreo_consumo/tipos_avisos');
-    const oficinasRef = db.ref('monitoreo_consumo/oficinas');
+    // Es la configuración que leen el subscriber y socket.js.
+    const paramsRef = db.ref(`${RAIZ}/configuracion`);
+    const tiposAvisosRef = db.ref(`${RAIZ}/tipos_avisos`);
+    const oficinasRef = db.ref(`${RAIZ}/oficinas`);
+    const jerarquiaRef = db.ref(`${RAIZ}/jerarquia/oficinas`);
 
     const paramsPorDefecto = {
         hora_inicio: 8.0,
@@ -12,6 +20,8 @@ async function inicializarBaseDeDatos(db) {
         umbral_corriente: 21.5,
         voltaje: 220.0,
         costo_kwh: 0.25,
+        factor_emision: 0.5,
+        corriente_base_a: 8.0,
     };
 
     const tiposAvisosPorDefecto = {
@@ -28,6 +38,9 @@ async function inicializarBaseDeDatos(db) {
         "10": { motivo: "Oficina agregada", detalle: "Se agregó una nueva oficina", impacto: 1 },
         "11": { motivo: "Oficina eliminada", detalle: "Se eliminó una oficina", impacto: 1 },
         "12": { motivo: "Configuración modificada", detalle: "Se modificó la configuración del sistema", impacto: 1 },
+        "13": { motivo: "Reloj desfasado", detalle: "El reloj del sensor difiere del servidor", impacto: 2 },
+        "14": { motivo: "Acción automática", detalle: "Dispositivo apagado por la automatización", impacto: 1 },
+        "15": { motivo: "Deslastre de carga", detalle: "Aire apagado o restablecido por el límite del edificio", impacto: 2 },
     };
 
     const oficinasPorDefecto = {
@@ -63,7 +76,20 @@ async function inicializarBaseDeDatos(db) {
             console.log("📝 Insertando configuración por defecto...");
             await paramsRef.set(paramsPorDefecto);
         } else {
-            console.log("✅ Configuración ya existe");
+            // Agrega los parámetros nuevos sin tocar los existentes.
+            const existentes = snapParams.val();
+            const faltantes = {};
+            for (const [clave, valor] of Object.entries(paramsPorDefecto)) {
+                if (existentes[clave] === undefined) {
+                    faltantes[clave] = valor;
+                }
+            }
+            if (Object.keys(faltantes).length > 0) {
+                console.log("📝 Agregando parámetros nuevos:", Object.keys(faltantes).join(", "));
+                await paramsRef.update(faltantes);
+            } else {
+                console.log("✅ Configuración ya existe");
+            }
         }
     } catch (err) {
         console.error("❌ Error params:", err);
@@ -75,7 +101,20 @@ async function inicializarBaseDeDatos(db) {
             console.log("📝 Insertando tipos de avisos por defecto...");
             await tiposAvisosRef.set(tiposAvisosPorDefecto);
         } else {
-            console.log("✅ Tipos de avisos ya existen");
+            // Agrega los tipos nuevos sin tocar los existentes.
+            const existentes = snapTipos.val();
+            const faltantes = {};
+            for (const [id, tipo] of Object.entries(tiposAvisosPorDefecto)) {
+                if (!existentes[id]) {
+                    faltantes[id] = tipo;
+                }
+            }
+            if (Object.keys(faltantes).length > 0) {
+                console.log("📝 Agregando tipos de avisos nuevos:", Object.keys(faltantes).join(", "));
+                await tiposAvisosRef.update(faltantes);
+            } else {
+                console.log("✅ Tipos de avisos ya existen");
+            }
         }
     } catch (err) {
         console.error("❌ Error tipos_avisos:", err);
@@ -93,6 +132,32 @@ async function inicializarBaseDeDatos(db) {
         console.error("❌ Error oficinas:", err);
     }
 
+    try {
+        // Ubica en la jerarquía las oficinas que todavía no lo están, con el
+        // sector que ya tienen.
+        const oficinas = (await oficinasRef.once('value')).val() || {};
+        const ubicadas = (await jerarquiaRef.once('value')).val() || {};
+        const faltantes = {};
+        for (const [id, oficina] of Object.entries(oficinas)) {
+            if (!ubicadas[id]) {
+                faltantes[id] = {
+                    sitio: "Central",
+                    edificio: "Principal",
+                    piso: "1",
+                    sector: oficina.sector || "",
+                };
+            }
+        }
+        if (Object.keys(faltantes).length > 0) {
+            console.log("📝 Agregando oficinas a la jerarquía:", Object.keys(faltantes).join(", "));
+            await jerarquiaRef.update(faltantes);
+        } else {
+            console.log("✅ Jerarquía ya existe");
+        }
+    } catch (err) {
+        console.error("❌ Error jerarquia:", err);
+    }
+
     console.log("🎉 Base de datos inicializada correctamente");
 }
 
@@ -15,6 +15,11 @@ if (!admin.apps.length) {
     });
 }
 
+// Raíz de los datos en Firebase; con MONITOREO_INQUILINO, la de ese inquilino.
+const RAIZ = process.env.MONITOREO_INQUILINO
+    ? `inquilinos/${process.env.MONITOREO_INQUILINO}/monitoreo_consumo`
+    : 'monitoreo_consumo';
+
 console.log('🔌 Iniciando servidor WebSocket...');
 
 // Crear servidor HTTP para WebSockets en puerto 8081
@@ -371,9 +376,9 @@ wssOficinas.on('connection', (ws) => {
 async function eliminarOficinaDeFirebase(oficinaId) {
     try {
         const db = initializeFirebase();
-        await db.ref(`monitoreo_consumo/oficinas/${oficinaId}`).remove();
-        await db.ref(`monitoreo_consumo/resumenes/${oficinaId}`).remove();
-        await db.ref(`monitoreo_consumo/avisos/${oficinaId}`).remove();
+        await db.ref(`${RAIZ}/oficinas/${oficinaId}`).remove();
+        await db.ref(`${RAIZ}/resumenes/${oficinaId}`).remove();
+        await db.ref(`${RAIZ}/avisos/${oficinaId}`).remove();
         
         console.log(`✅ Oficina ${oficinaId} eliminada de Firebase`);
     } catch (error) {
@@ -408,7 +413,7 @@ const wssParams = new WebSocket.Server({ noServer: true });
 wssParams.on('connection', (ws) => {
     console.log('🔌 Cliente conectado a PARAMS');
 
-    db.ref('monitoreo_consumo/configuracion').once('value')
+    db.ref(`${RAIZ}/configuracion`).once('value')
         .then((snapshot) => {
             const configData = snapshot.val() || {
                 hora_inicio: 8.0,
@@ -436,7 +441,7 @@ wssParams.on('connection', (ws) => {
 
                 // GUARDAR EN FIREBASE
                 try {
-                    await db.ref('monitoreo_consumo/configuracion').set(data.data);
+                    await db.ref(`${RAIZ}/configuracion`).set(data.data);
                     console.log('✅ Configuración guardada en Firebase');
                 } catch (firebaseError) {
                     console.error('❌ Error guardando en Firebase:', firebaseError);